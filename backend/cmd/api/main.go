@@ -2,15 +2,20 @@ package main
 
 import (
     "context"
+    "fmt"
     "log"
     "net/http"
     "os/signal"
+    "strconv"
+    "strings"
     "syscall"
     "time"
 
     "github.com/example/multistory/internal/executor"
+    "github.com/example/multistory/internal/jobs"
     "github.com/example/multistory/internal/platform"
     "github.com/example/multistory/internal/realtime"
+    "github.com/example/multistory/internal/scheduler"
     "github.com/example/multistory/internal/server"
     "github.com/example/multistory/internal/story"
 )
@@ -23,17 +28,80 @@ func main() {
             "http://localhost:8501",
         },
     }
+    heartbeat, err := time.ParseDuration(platform.Env("SSE_HEARTBEAT_INTERVAL", "15s"))
+    if err != nil {
+        log.Fatalf("invalid SSE_HEARTBEAT_INTERVAL: %v", err)
+    }
+    retry, err := time.ParseDuration(platform.Env("SSE_RETRY_INTERVAL", "3s"))
+    if err != nil {
+        log.Fatalf("invalid SSE_RETRY_INTERVAL: %v", err)
+    }
+    overflow, err := realtime.ParseOverflowPolicy(platform.Env("SSE_OVERFLOW", string(realtime.OverflowDropOldest)))
+    if err != nil {
+        log.Fatalf("invalid SSE_OVERFLOW: %v", err)
+    }
+    blockTimeout, err := time.ParseDuration(platform.Env("SSE_BLOCK_TIMEOUT", "1s"))
+    if err != nil {
+        log.Fatalf("invalid SSE_BLOCK_TIMEOUT: %v", err)
+    }
+    cfg.HeartbeatInterval = heartbeat
+    cfg.RetryInterval = retry
+    cfg.Overflow = overflow
+    cfg.BlockTimeout = blockTimeout
+    tokens, err := socketTokens()
+    if err != nil {
+        log.Fatalf("invalid WS_TOKENS: %v", err)
+    }
+    cfg.SocketTokens = tokens
 
-    repo := story.NewMemoryRepository()
+    repo, closeRepo, err := openRepository(context.Background())
+    if err != nil {
+        log.Fatalf("storage init error: %v", err)
+    }
+    defer closeRepo()
     hub := realtime.NewHub()
-    runner := executor.NewStub()
-    svc := story.NewService(repo, runner, hub)
+    // Connections touch their presence entry on every heartbeat, so allow a few to go missing.
+    presenceTTL, err := time.ParseDuration(platform.Env("PRESENCE_TTL", (3 * heartbeat).String()))
+    if err != nil {
+        log.Fatalf("invalid PRESENCE_TTL: %v", err)
+    }
+    presence := realtime.NewPresence(hub, presenceTTL)
+    runner, closeRunner, err := newRunner()
+    if err != nil {
+        log.Fatalf("executor init error: %v", err)
+    }
+    defer closeRunner()
+    cacheEntries, err := strconv.Atoi(platform.Env("EXEC_CACHE_ENTRIES", "1000"))
+    if err != nil {
+        log.Fatalf("invalid EXEC_CACHE_ENTRIES: %v", err)
+    }
+    var cache story.OutputCache
+    if cacheEntries > 0 {
+        cache = story.NewMemoryOutputCache(cacheEntries)
+    }
+    svc := story.NewService(repo, runner, hub, cache)
+
+    queue, err := queueConfig()
+    if err != nil {
+        log.Fatalf("execution queue config error: %v", err)
+    }
+    executions := jobs.NewManager(svc, hub, queue)
 
-    srv := server.New(cfg, svc, hub)
+    schedules := scheduler.New(repo, executions, hub)
+
+    srv := server.New(cfg, svc, hub, presence, executions, schedules)
 
     ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
     defer stop()
 
+    schedulerDone := make(chan struct{})
+    go func() {
+        defer close(schedulerDone)
+        schedules.Run(ctx)
+    }()
+
+    go presence.Run(ctx)
+
     go func() {
         log.Printf("http server listening on %s", srv.Addr)
         if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -44,9 +112,140 @@ func main() {
     <-ctx.Done()
     shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
+    // Shutdown ends open event streams and WebSocket connections with a server.shutdown event
+    // before waiting for them.
     if err := srv.Shutdown(shutdownCtx); err != nil {
         log.Printf("graceful shutdown error: %v", err)
     }
+    // The scheduler stops on the signal context; wait so it cannot queue runs during drain.
+    <-schedulerDone
+    if err := executions.Shutdown(shutdownCtx); err != nil {
+        log.Printf("execution shutdown error: %v", err)
+    }
 
     log.Println("server stopped")
 }
+
+// newRunner selects the execution backend from EXECUTOR ("stub" or "local"). The local runner
+// keeps python and shell state in kernel sessions that close after KERNEL_IDLE_TIMEOUT, and runs
+// everything under the limits from localLimits.
+func newRunner() (story.Runner, func(), error) {
+    switch kind := platform.Env("EXECUTOR", "stub"); kind {
+    case "stub":
+        return executor.NewStub(), func() {}, nil
+    case "local":
+        idle, err := time.ParseDuration(platform.Env("KERNEL_IDLE_TIMEOUT", "10m"))
+        if err != nil {
+            return nil, nil, fmt.Errorf("invalid KERNEL_IDLE_TIMEOUT: %w", err)
+        }
+        limits, err := localLimits()
+        if err != nil {
+            return nil, nil, err
+        }
+        if limits.DenyNetwork {
+            if err := executor.CheckIsolation(); err != nil {
+                return nil, nil, fmt.Errorf("EXEC_DENY_NETWORK: %w", err)
+            }
+        }
+        kernels := executor.NewKernelPool(idle)
+        return executor.NewLocal(executor.LocalConfig{Kernels: kernels, Limits: limits}), kernels.Close, nil
+    default:
+        return nil, nil, fmt.Errorf("unknown EXECUTOR %q", kind)
+    }
+}
+
+// localLimits reads the local runner's guardrails. Set a value to 0 to lift that limit.
+func localLimits() (executor.Limits, error) {
+    timeout, err := time.ParseDuration(platform.Env("EXEC_TIMEOUT", "5m"))
+    if err != nil {
+        return executor.Limits{}, fmt.Errorf("invalid EXEC_TIMEOUT: %w", err)
+    }
+    cpu, err := time.ParseDuration(platform.Env("EXEC_CPU_LIMIT", "1m"))
+    if err != nil {
+        return executor.Limits{}, fmt.Errorf("invalid EXEC_CPU_LIMIT: %w", err)
+    }
+    memoryMB, err := strconv.ParseUint(platform.Env("EXEC_MEMORY_MB", "2048"), 10, 64)
+    if err != nil {
+        return executor.Limits{}, fmt.Errorf("invalid EXEC_MEMORY_MB: %w", err)
+    }
+    maxOutput, err := strconv.Atoi(platform.Env("EXEC_MAX_OUTPUT_BYTES", "1048576"))
+    if err != nil {
+        return executor.Limits{}, fmt.Errorf("invalid EXEC_MAX_OUTPUT_BYTES: %w", err)
+    }
+    denyNetwork, err := strconv.ParseBool(platform.Env("EXEC_DENY_NETWORK", "false"))
+    if err != nil {
+        return executor.Limits{}, fmt.Errorf("invalid EXEC_DENY_NETWORK: %w", err)
+    }
+    return executor.Limits{
+        Timeout:        timeout,
+        CPUTime:        cpu,
+        MemoryBytes:    memoryMB << 20,
+        MaxOutputBytes: maxOutput,
+        DenyNetwork:    denyNetwork,
+    }, nil
+}
+
+// queueConfig reads the execution queue's concurrency caps.
+func queueConfig() (jobs.Config, error) {
+    workers, err := strconv.Atoi(platform.Env("EXEC_WORKERS", "4"))
+    if err != nil {
+        return jobs.Config{}, fmt.Errorf("invalid EXEC_WORKERS: %w", err)
+    }
+    queueSize, err := strconv.Atoi(platform.Env("EXEC_QUEUE_SIZE", "100"))
+    if err != nil {
+        return jobs.Config{}, fmt.Errorf("invalid EXEC_QUEUE_SIZE: %w", err)
+    }
+    coalesce, err := strconv.ParseBool(platform.Env("EXEC_COALESCE", "true"))
+    if err != nil {
+        return jobs.Config{}, fmt.Errorf("invalid EXEC_COALESCE: %w", err)
+    }
+    return jobs.Config{Workers: workers, QueueSize: queueSize, Coalesce: coalesce}, nil
+}
+
+// socketTokens reads WS_TOKENS, a comma-separated list of user:token pairs for WebSocket and event
+// stream clients. Leaving it empty lets any client connect.
+func socketTokens() (map[string]string, error) {
+    raw := strings.TrimSpace(platform.Env("WS_TOKENS", ""))
+    if raw == "" {
+        return nil, nil
+    }
+    tokens := make(map[string]string)
+    for _, pair := range strings.Split(raw, ",") {
+        user, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+        if !ok || user == "" || token == "" {
+            return nil, fmt.Errorf("%q is not user:token", pair)
+        }
+        if _, dup := tokens[token]; dup {
+            return nil, fmt.Errorf("token for %s is already assigned", user)
+        }
+        tokens[token] = user
+    }
+    return tokens, nil
+}
+
+// openRepository selects the story backend from STORAGE_DRIVER ("memory", "sqlite" or "eventlog").
+func openRepository(ctx context.Context) (story.Repository, func(), error) {
+    switch driver := platform.Env("STORAGE_DRIVER", "memory"); driver {
+    case "memory":
+        return story.NewMemoryRepository(), func() {}, nil
+    case "sqlite":
+        db, err := story.OpenSQLite(platform.Env("SQLITE_PATH", "multistory.db"))
+        if err != nil {
+            return nil, nil, err
+        }
+        repo, err := story.NewSQLiteRepository(ctx, db)
+        if err != nil {
+            db.Close()
+            return nil, nil, err
+        }
+        return repo, func() { db.Close() }, nil
+    case "eventlog":
+        repo, err := story.NewEventLogRepository(platform.Env("EVENTLOG_DIR", "data"))
+        if err != nil {
+            return nil, nil, err
+        }
+        return repo, func() {}, nil
+    default:
+        return nil, nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+    }
+}
@@ -18,9 +18,14 @@ func NewStub() *Stub {
 	return &Stub{}
 }
 
-// Execute pretends to run the story and echoes deterministic output so the UI has data to render.
+// Execute pretends to run the targeted blocks and echoes deterministic output so the UI has data
+// to render. Blocks with cached outputs are reported from the cache.
 func (s *Stub) Execute(ctx context.Context, req story.ExecutionRequest) (story.ExecutionResult, error) {
 	started := time.Now().UTC()
+	selected, err := req.Target.Select(req.Story.Blocks, req.Story.Parameters)
+	if err != nil {
+		return story.ExecutionResult{}, err
+	}
 	select {
 	case <-ctx.Done():
 		return story.ExecutionResult{}, ctx.Err()
@@ -28,18 +33,48 @@ func (s *Stub) Execute(ctx context.Context, req story.ExecutionRequest) (story.E
 	}
 
 	blocks := make([]story.Block, len(req.Story.Blocks))
+	executed, reused := 0, 0
 	for i, block := range req.Story.Blocks {
-		b := block
-		b.Outputs = []story.Output{
-			{
-				Kind:     "text",
-				MimeType: "text/plain",
-				Data:     fmt.Sprintf("Simulated output for block %s", block.ID),
-			},
+		blocks[i] = block
+		if !selected[block.ID] {
+			continue
+		}
+		if outputs, ok := req.Cached[block.ID]; ok {
+			reportCached(req, block.ID, outputs)
+			blocks[i].Outputs = outputs
+			reused++
+			continue
 		}
+		executed++
+		blockStarted := time.Now().UTC()
+		req.Report(story.BlockEvent{BlockID: block.ID, Kind: story.BlockEventStarted})
+		output := story.Output{
+			Kind:     "text",
+			MimeType: "text/plain",
+			Data:     fmt.Sprintf("Simulated output for block %s", block.ID),
+		}
+		req.Report(story.BlockEvent{BlockID: block.ID, Kind: story.BlockEventStdout, Text: output.Data + "\n"})
+		req.Report(story.BlockEvent{BlockID: block.ID, Kind: story.BlockEventOutput, Output: &output})
+		b := block
+		b.Outputs = []story.Output{output}
 		blocks[i] = b
+		req.Report(story.BlockEvent{
+			BlockID:    block.ID,
+			Kind:       story.BlockEventFinished,
+			Status:     "completed",
+			DurationMs: time.Since(blockStarted).Milliseconds(),
+		})
 	}
 
+	logs := []string{
+		"Execution routed to stub runner",
+		fmt.Sprintf("Actor: %s", req.Actor),
+	}
+	if len(req.Parameters) > 0 {
+		logs = append(logs, fmt.Sprintf("Parameters: %s", describeParameters(req.Parameters)))
+	}
+	logs = append(logs, fmt.Sprintf("Blocks executed: %d", executed), fmt.Sprintf("Blocks reused from cache: %d", reused))
+
 	return story.ExecutionResult{
 		StoryID:    req.Story.ID,
 		Revision:   fmt.Sprintf("sim-%d", started.UnixNano()),
@@ -47,10 +82,15 @@ func (s *Stub) Execute(ctx context.Context, req story.ExecutionRequest) (story.E
 		FinishedAt: time.Now().UTC(),
 		Status:     "completed",
 		Blocks:     blocks,
-		Logs: []string{
-			"Execution routed to stub runner",
-			fmt.Sprintf("Actor: %s", req.Actor),
-			fmt.Sprintf("Blocks executed: %d", len(blocks)),
-		},
+		Logs:       logs,
 	}, nil
 }
+
+// reportCached replays a block's cached outputs as progress events.
+func reportCached(req story.ExecutionRequest, blockID string, outputs []story.Output) {
+	req.Report(story.BlockEvent{BlockID: blockID, Kind: story.BlockEventStarted})
+	for i := range outputs {
+		req.Report(story.BlockEvent{BlockID: blockID, Kind: story.BlockEventOutput, Output: &outputs[i]})
+	}
+	req.Report(story.BlockEvent{BlockID: blockID, Kind: story.BlockEventFinished, Status: "cached"})
+}
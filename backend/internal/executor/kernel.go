@@ -0,0 +1,505 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/multistory/pkg/id"
+)
+
+// ErrKernelExited is returned when the interpreter process dies while running a block.
+var ErrKernelExited = errors.New("executor: kernel exited")
+
+// cpuSampleInterval is how often a running block's CPU time is checked against its limit.
+const cpuSampleInterval = 100 * time.Millisecond
+
+// Kernels read the path of each script to run from a control pipe on fd 3, so user code that
+// reads stdin gets /dev/null rather than the driver's instructions. After each script they write
+// the token and exit status to stdout and the token to stderr, marking where its output ends.
+
+// pythonDriver keeps one namespace alive and executes each script in it.
+const pythonDriver = `import os, sys, traceback
+token = sys.argv[1]
+control = os.fdopen(3)
+namespace = {"__name__": "__main__"}
+for line in control:
+    path = line.rstrip("\n")
+    status = 0
+    try:
+        with open(path) as f:
+            code = f.read()
+        exec(compile(code, path, "exec"), namespace)
+    except SystemExit as e:
+        status = e.code if isinstance(e.code, int) else (0 if e.code is None else 1)
+    except BaseException:
+        traceback.print_exc()
+        status = 1
+    sys.stdout.flush()
+    sys.stderr.flush()
+    sys.stdout.write("\n%s %d\n" % (token, status))
+    sys.stdout.flush()
+    sys.stderr.write("\n%s\n" % token)
+    sys.stderr.flush()
+`
+
+// shellDriver sources each script into one shell. A script that calls exit ends the shell, so the
+// EXIT trap still reports its status, flagged so the session is discarded.
+const shellDriver = `__multistory_token=$1
+trap 'printf "\n%s %d exit\n" "$__multistory_token" "$?"; printf "\n%s\n" "$__multistory_token" >&2' EXIT
+while IFS= read -r __multistory_path <&3; do
+    . "$__multistory_path" </dev/null
+    printf '\n%s %d\n' "$__multistory_token" "$?"
+    printf '\n%s\n' "$__multistory_token" >&2
+done
+trap - EXIT
+`
+
+// kernelSpec describes how to start a persistent interpreter.
+type kernelSpec struct {
+	command func(token string) []string
+}
+
+func shellSpec(shell string) kernelSpec {
+	return kernelSpec{
+		command: func(token string) []string { return []string{shell, "-c", shellDriver, shell, token} },
+	}
+}
+
+var kernelSpecs = map[string]kernelSpec{
+	"python": {
+		command: func(token string) []string { return []string{"python3", "-u", "-c", pythonDriver, token} },
+	},
+	"sh":   shellSpec("sh"),
+	"bash": shellSpec("bash"),
+}
+
+// kernelLanguages maps Block.Language values onto the kernels that can hold their state.
+var kernelLanguages = map[string]string{
+	"python":  "python",
+	"python3": "python",
+	"py":      "python",
+	"sh":      "sh",
+	"shell":   "sh",
+	"bash":    "bash",
+}
+
+// KernelPool keeps one interpreter session per story and kernel language, closing idle ones.
+type KernelPool struct {
+	idle time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	closed   bool
+	done     chan struct{}
+}
+
+// NewKernelPool starts a pool whose sessions are closed after idle without use.
+func NewKernelPool(idle time.Duration) *KernelPool {
+	p := &KernelPool{
+		idle:     idle,
+		sessions: make(map[string]*Session),
+		done:     make(chan struct{}),
+	}
+	if idle > 0 {
+		go p.reap()
+	}
+	return p
+}
+
+// Supports reports whether language has a stateful kernel.
+func (p *KernelPool) Supports(language string) bool {
+	_, ok := kernelLanguages[strings.ToLower(language)]
+	return ok
+}
+
+// Acquire returns the live session for storyID and language, starting one if needed. New sessions
+// run under limits with env appended to the pool's own environment.
+func (p *KernelPool) Acquire(storyID, language string, limits Limits, env []string) (*Session, error) {
+	kernel, ok := kernelLanguages[strings.ToLower(language)]
+	if !ok {
+		return nil, fmt.Errorf("no kernel for language %q", language)
+	}
+	key := storyID + "/" + kernel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errors.New("executor: kernel pool closed")
+	}
+	if s, ok := p.sessions[key]; ok && s.alive() {
+		return s, nil
+	}
+	s, err := startSession(kernelSpecs[kernel], limits, env)
+	if err != nil {
+		return nil, err
+	}
+	p.sessions[key] = s
+	return s, nil
+}
+
+// Restart discards every session belonging to storyID so the next block starts from a clean state.
+func (p *KernelPool) Restart(storyID string) {
+	p.mu.Lock()
+	var stale []*Session
+	for key, s := range p.sessions {
+		if strings.HasPrefix(key, storyID+"/") {
+			stale = append(stale, s)
+			delete(p.sessions, key)
+		}
+	}
+	p.mu.Unlock()
+	for _, s := range stale {
+		s.Close()
+	}
+}
+
+// Close terminates all sessions and stops the idle reaper.
+func (p *KernelPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	sessions := p.sessions
+	p.sessions = make(map[string]*Session)
+	p.mu.Unlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+func (p *KernelPool) reap() {
+	ticker := time.NewTicker(p.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		var stale []*Session
+		for key, s := range p.sessions {
+			if !s.alive() || s.idleSince(p.idle) {
+				stale = append(stale, s)
+				delete(p.sessions, key)
+			}
+		}
+		p.mu.Unlock()
+		for _, s := range stale {
+			s.Close()
+		}
+	}
+}
+
+// Session is a long-lived interpreter process that runs scripts one at a time in shared state.
+type Session struct {
+	spec  kernelSpec
+	token string
+	dir   string
+	cmd   *exec.Cmd
+	// control feeds script paths to the driver on its fd 3.
+	control io.WriteCloser
+	stdout  chan []byte
+	stderr  chan []byte
+	exited  chan struct{}
+	// exit is the process's final state, set before exited is closed.
+	exit *os.ProcessState
+	// cpuLimit caps the CPU time of each block Run executes; zero means none.
+	cpuLimit time.Duration
+
+	closeOnce sync.Once
+	// run serialises blocks; state guards the bookkeeping fields below.
+	run      sync.Mutex
+	state    sync.Mutex
+	lastUsed time.Time
+	busy     bool
+	dead     bool
+	seq      int
+}
+
+func startSession(spec kernelSpec, limits Limits, env []string) (*Session, error) {
+	dir, err := os.MkdirTemp("", "multistory-kernel-")
+	if err != nil {
+		return nil, err
+	}
+	token := "__multistory_" + id.New() + "__"
+	// Run enforces the CPU limit block by block where it can; an rlimit would count every block.
+	processLimits := limits
+	if perBlockCPU {
+		processLimits.CPUTime = 0
+	}
+	argv := processLimits.wrap(spec.command(token))
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "TMPDIR="+dir), env...)
+	if err := sandbox(cmd, limits); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	controlR, controlW, err := os.Pipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd.ExtraFiles = []*os.File{controlR}
+	// Plain pipes rather than StdoutPipe so Wait never closes a stream before it has been drained.
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		controlR.Close()
+		controlW.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		controlR.Close()
+		controlW.Close()
+		stdoutR.Close()
+		stdoutW.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	err = cmd.Start()
+	controlR.Close()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		controlW.Close()
+		stdoutR.Close()
+		stderrR.Close()
+		os.RemoveAll(dir)
+		return nil, startError(err, limits)
+	}
+	s := &Session{
+		spec:     spec,
+		token:    token,
+		dir:      dir,
+		cmd:      cmd,
+		control:  controlW,
+		stdout:   pump(stdoutR),
+		stderr:   pump(stderrR),
+		exited:   make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	if perBlockCPU {
+		s.cpuLimit = limits.CPUTime
+	}
+	go func() {
+		_ = cmd.Wait()
+		s.state.Lock()
+		s.exit = cmd.ProcessState
+		s.dead = true
+		s.state.Unlock()
+		close(s.exited)
+	}()
+	return s, nil
+}
+
+// pump forwards everything read from r until EOF, then closes the channel and r.
+func pump(r io.ReadCloser) chan []byte {
+	ch := make(chan []byte, 64)
+	go func() {
+		defer close(ch)
+		defer r.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				ch <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Run executes source in the session, streaming output chunks as they arrive, and returns the
+// script's exit status. Cancelling ctx kills the session.
+func (s *Session) Run(ctx context.Context, source string, onStdout, onStderr func([]byte)) (int, error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+	s.state.Lock()
+	if s.dead {
+		s.state.Unlock()
+		return 0, ErrKernelExited
+	}
+	s.busy = true
+	s.seq++
+	path := fmt.Sprintf("%s/block-%d", s.dir, s.seq)
+	s.state.Unlock()
+	defer func() {
+		s.state.Lock()
+		s.busy = false
+		s.lastUsed = time.Now()
+		s.state.Unlock()
+	}()
+
+	if err := os.WriteFile(path, []byte(source), 0o600); err != nil {
+		return 0, err
+	}
+	defer os.Remove(path)
+
+	// The kernel's usage so far is the baseline; only what this block adds counts against the limit.
+	var cpuCheck <-chan time.Time
+	var cpuBase time.Duration
+	if s.cpuLimit > 0 {
+		if base, err := groupCPU(s.cmd.Process.Pid); err == nil {
+			cpuBase = base
+			ticker := time.NewTicker(cpuSampleInterval)
+			defer ticker.Stop()
+			cpuCheck = ticker.C
+		}
+	}
+	if _, err := io.WriteString(s.control, path+"\n"); err != nil {
+		s.Close()
+		return 0, ErrKernelExited
+	}
+
+	out := newMarkerScanner("\n" + s.token)
+	errs := newMarkerScanner("\n" + s.token)
+	stdout, stderr := s.stdout, s.stderr
+	for !(out.statusRead() && errs.statusRead()) {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return 0, ctx.Err()
+		case <-cpuCheck:
+			if used, err := groupCPU(s.cmd.Process.Pid); err == nil && used-cpuBase > s.cpuLimit {
+				s.Close()
+				return 0, &LimitError{Limit: "cpu", Detail: fmt.Sprintf("block exceeded its %s CPU time limit; its kernel was restarted", s.cpuLimit)}
+			}
+		case chunk, ok := <-stdout:
+			if !ok {
+				s.Close()
+				return 0, ErrKernelExited
+			}
+			if emit := out.feed(chunk); len(emit) > 0 {
+				onStdout(emit)
+			}
+			if out.statusRead() {
+				stdout = nil
+			}
+		case chunk, ok := <-stderr:
+			if !ok {
+				s.Close()
+				return 0, ErrKernelExited
+			}
+			if emit := errs.feed(chunk); len(emit) > 0 {
+				onStderr(emit)
+			}
+			if errs.statusRead() {
+				stderr = nil
+			}
+		}
+	}
+	fields := strings.Fields(string(out.tail))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("executor: malformed kernel status %q", out.tail)
+	}
+	status, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, fmt.Errorf("executor: malformed kernel status %q", out.tail)
+	}
+	if len(fields) > 1 && fields[1] == "exit" {
+		// The script ended the interpreter; the next block starts in a fresh session.
+		s.Close()
+		onStderr([]byte("\nkernel exited; its state was reset\n"))
+	}
+	return status, nil
+}
+
+// Close kills the interpreter and removes its scratch directory.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		s.state.Lock()
+		s.dead = true
+		s.state.Unlock()
+		_ = s.control.Close()
+		_ = killTree(s.cmd)
+		<-s.exited
+		// Drain whatever the process left behind so the pump goroutines can exit.
+		go func() {
+			for range s.stdout {
+			}
+		}()
+		go func() {
+			for range s.stderr {
+			}
+		}()
+		os.RemoveAll(s.dir)
+	})
+}
+
+// cpuExceeded reports whether the session was stopped by its CPU limit.
+func (s *Session) cpuExceeded() bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return cpuExceeded(s.exit)
+}
+
+func (s *Session) alive() bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return !s.dead
+}
+
+func (s *Session) idleSince(d time.Duration) bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return !s.busy && time.Since(s.lastUsed) > d
+}
+
+// markerScanner passes stream bytes through until the end-of-block marker, holding back any
+// suffix that could be the start of a marker split across reads.
+type markerScanner struct {
+	marker  []byte
+	pending []byte
+	done    bool
+	tail    []byte
+}
+
+func newMarkerScanner(marker string) *markerScanner {
+	return &markerScanner{marker: []byte(marker)}
+}
+
+func (m *markerScanner) feed(chunk []byte) []byte {
+	if m.done {
+		m.tail = append(m.tail, chunk...)
+		return nil
+	}
+	m.pending = append(m.pending, chunk...)
+	if i := bytes.Index(m.pending, m.marker); i >= 0 {
+		emit := m.pending[:i]
+		m.tail = append(m.tail, m.pending[i+len(m.marker):]...)
+		m.pending = nil
+		m.done = true
+		return emit
+	}
+	safe := len(m.pending) - (len(m.marker) - 1)
+	if safe <= 0 {
+		return nil
+	}
+	emit := append([]byte(nil), m.pending[:safe]...)
+	m.pending = append(m.pending[:0], m.pending[safe:]...)
+	return emit
+}
+
+// statusRead reports whether the marker and the status line that follows it have arrived.
+func (m *markerScanner) statusRead() bool {
+	return m.done && bytes.IndexByte(m.tail, '\n') >= 0
+}
@@ -0,0 +1,226 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requireCommand(t *testing.T, name string) {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s not installed", name)
+	}
+}
+
+func acquire(t *testing.T, pool *KernelPool, language string) *Session {
+	t.Helper()
+	s, err := pool.Acquire("story-1", language, Limits{}, nil)
+	if err != nil {
+		t.Fatalf("acquire %s kernel: %v", language, err)
+	}
+	return s
+}
+
+func runSource(t *testing.T, s *Session, source string) (string, string, int) {
+	t.Helper()
+	stdout, stderr, status, err := tryRunSource(s, source)
+	if err != nil {
+		t.Fatalf("run %q: %v", source, err)
+	}
+	return stdout, stderr, status
+}
+
+func tryRunSource(s *Session, source string) (string, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var stdout, stderr strings.Builder
+	status, err := s.Run(ctx, source,
+		func(b []byte) { stdout.Write(b) },
+		func(b []byte) { stderr.Write(b) })
+	return stdout.String(), stderr.String(), status, err
+}
+
+func TestPythonKernelKeepsState(t *testing.T) {
+	requireCommand(t, "python3")
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	s := acquire(t, pool, "python")
+	if _, _, status := runSource(t, s, "x = 41"); status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	stdout, _, _ := runSource(t, s, "print(x + 1)")
+	if stdout != "42\n" {
+		t.Fatalf("expected state to carry over, got %q", stdout)
+	}
+	if _, _, status := runSource(t, s, "raise SystemExit(4)"); status != 4 {
+		t.Fatalf("expected SystemExit status 4, got %d", status)
+	}
+	if stdout, _, _ := runSource(t, s, "print(x)"); stdout != "41\n" {
+		t.Fatalf("expected SystemExit to keep the kernel, got %q", stdout)
+	}
+}
+
+func TestPythonKernelInputSeesEOF(t *testing.T) {
+	requireCommand(t, "python3")
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	s := acquire(t, pool, "python")
+	stdout, _, status := runSource(t, s, "try:\n    input()\nexcept EOFError:\n    print('eof')\n")
+	if status != 0 || stdout != "eof\n" {
+		t.Fatalf("expected input() to hit EOF, got status %d and %q", status, stdout)
+	}
+	if stdout, _, _ := runSource(t, s, "print('next')"); stdout != "next\n" {
+		t.Fatalf("expected the following block to run, got %q", stdout)
+	}
+}
+
+func TestShellKernelReadSeesEOF(t *testing.T) {
+	requireCommand(t, "sh")
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	s := acquire(t, pool, "sh")
+	stdout, _, _ := runSource(t, s, "read line; echo \"read $?\"")
+	if stdout != "read 1\n" {
+		t.Fatalf("expected read to hit EOF, got %q", stdout)
+	}
+}
+
+func TestShellKernelExitRestartsKernel(t *testing.T) {
+	requireCommand(t, "sh")
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	s := acquire(t, pool, "sh")
+	runSource(t, s, "X=1")
+	if stdout, _, _ := runSource(t, s, "echo \"$X\""); stdout != "1\n" {
+		t.Fatalf("expected state to carry over, got %q", stdout)
+	}
+	stdout, stderr, status := runSource(t, s, "echo bye; exit 3")
+	if status != 3 || stdout != "bye\n" {
+		t.Fatalf("expected status 3 and output, got %d and %q", status, stdout)
+	}
+	if !strings.Contains(stderr, "kernel exited") {
+		t.Fatalf("expected a note that the kernel was reset, got %q", stderr)
+	}
+	if s.alive() {
+		t.Fatalf("expected the exited session to be closed")
+	}
+
+	next := acquire(t, pool, "sh")
+	if next == s {
+		t.Fatalf("expected a fresh session after exit")
+	}
+	if stdout, _, _ := runSource(t, next, "echo \"${X:-unset}\""); stdout != "unset\n" {
+		t.Fatalf("expected a clean shell, got %q", stdout)
+	}
+}
+
+func TestShellKernelSyntaxErrorRestartsKernel(t *testing.T) {
+	requireCommand(t, "sh")
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	s := acquire(t, pool, "sh")
+	if _, _, status := runSource(t, s, "if then"); status == 0 {
+		t.Fatalf("expected a syntax error to fail the block")
+	}
+	next := acquire(t, pool, "sh")
+	if stdout, _, _ := runSource(t, next, "echo ok"); stdout != "ok\n" {
+		t.Fatalf("expected the kernel to keep working, got %q", stdout)
+	}
+}
+
+// burnCPU spends roughly d of CPU time in the python kernel.
+func burnCPU(d time.Duration) string {
+	return "import time\nend = time.process_time() + " + strconv.FormatFloat(d.Seconds(), 'f', 2, 64) + "\nwhile time.process_time() < end:\n    pass\n"
+}
+
+func TestKernelCPULimitAppliesPerBlock(t *testing.T) {
+	requireCommand(t, "python3")
+	if !perBlockCPU {
+		t.Skip("kernels are not limited per block on this platform")
+	}
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	limits := Limits{CPUTime: time.Second}
+	s, err := pool.Acquire("story-1", "python", limits, nil)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	// Together these exceed the limit; each block alone does not.
+	for i := 0; i < 3; i++ {
+		if _, _, status := runSource(t, s, burnCPU(600*time.Millisecond)); status != 0 {
+			t.Fatalf("block %d: expected status 0, got %d", i, status)
+		}
+	}
+
+	_, _, _, err = tryRunSource(s, "while True:\n    pass\n")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "cpu" {
+		t.Fatalf("expected a cpu LimitError, got %v", err)
+	}
+	if s.alive() {
+		t.Fatalf("expected the kernel to be stopped")
+	}
+}
+
+func TestKernelCPULimitCountsChildren(t *testing.T) {
+	requireCommand(t, "sh")
+	requireCommand(t, "python3")
+	if !perBlockCPU {
+		t.Skip("kernels are not limited per block on this platform")
+	}
+	pool := NewKernelPool(0)
+	defer pool.Close()
+
+	s, err := pool.Acquire("story-1", "sh", Limits{CPUTime: time.Second}, nil)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	_, _, _, err = tryRunSource(s, "python3 -c 'while True: pass'")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "cpu" {
+		t.Fatalf("expected a cpu LimitError, got %v", err)
+	}
+}
+
+func TestMarkerScanner(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks []string
+		emit   string
+		tail   string
+	}{
+		{name: "single chunk", chunks: []string{"hello\n@@END 0\n"}, emit: "hello", tail: " 0\n"},
+		{name: "marker split", chunks: []string{"hello\n@@E", "ND 2 exit\n"}, emit: "hello", tail: " 2 exit\n"},
+		{name: "byte at a time", chunks: strings.Split("ab\n@@END 1\n", ""), emit: "ab", tail: " 1\n"},
+		{name: "partial match passes through", chunks: []string{"\n@@EN", "X\n", "\n@@END 0\n"}, emit: "\n@@ENX\n", tail: " 0\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMarkerScanner("\n@@END")
+			var emitted strings.Builder
+			for _, chunk := range tc.chunks {
+				emitted.Write(m.feed([]byte(chunk)))
+			}
+			if !m.statusRead() {
+				t.Fatalf("expected the status line to be read")
+			}
+			if emitted.String() != tc.emit {
+				t.Fatalf("expected output %q, got %q", tc.emit, emitted.String())
+			}
+			if string(m.tail) != tc.tail {
+				t.Fatalf("expected tail %q, got %q", tc.tail, m.tail)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits bounds what the processes behind a single execution may consume. Zero values mean no limit.
+type Limits struct {
+	// Timeout caps the wall-clock time of a whole execution.
+	Timeout time.Duration
+	// CPUTime caps processor time per block. On Linux a kernel session is held to it one block at
+	// a time; elsewhere its rlimit counts every block the session has run.
+	CPUTime time.Duration
+	// MemoryBytes caps each process's virtual address space.
+	MemoryBytes uint64
+	// MaxOutputBytes caps how much of each block's stdout and stderr is kept; the rest is dropped.
+	MaxOutputBytes int
+	// DenyNetwork starts processes in an empty network namespace. Blocks fail where that is
+	// unsupported; CheckIsolation tells in advance.
+	DenyNetwork bool
+}
+
+// LimitError reports that a block was stopped because it tripped one of the runner's Limits.
+type LimitError struct {
+	// Limit is "time", "cpu" or "memory".
+	Limit  string
+	Detail string
+}
+
+func (e *LimitError) Error() string {
+	return e.Detail
+}
+
+// String summarises the configured limits for execution logs.
+func (l Limits) String() string {
+	var parts []string
+	if l.Timeout > 0 {
+		parts = append(parts, fmt.Sprintf("timeout %s", l.Timeout))
+	}
+	if l.CPUTime > 0 {
+		parts = append(parts, fmt.Sprintf("cpu %s", l.CPUTime))
+	}
+	if l.MemoryBytes > 0 {
+		parts = append(parts, fmt.Sprintf("memory %d MiB", l.MemoryBytes>>20))
+	}
+	if l.MaxOutputBytes > 0 {
+		parts = append(parts, fmt.Sprintf("output %d bytes", l.MaxOutputBytes))
+	}
+	if l.DenyNetwork {
+		parts = append(parts, "network denied")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// wrap prefixes argv with a shell that applies the rlimits before exec'ing the real command, so
+// the limits are in place before any user code runs.
+func (l Limits) wrap(argv []string) []string {
+	var ulimits []string
+	if l.CPUTime > 0 {
+		seconds := int64((l.CPUTime + time.Second - 1) / time.Second)
+		// The soft limit raises SIGXCPU so the trip is recognisable; the hard limit backs it up.
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -S -t %d", seconds), fmt.Sprintf("ulimit -H -t %d", seconds+1))
+	}
+	if l.MemoryBytes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", l.MemoryBytes/1024))
+	}
+	if len(ulimits) == 0 {
+		return argv
+	}
+	script := strings.Join(ulimits, " && ") + ` && exec "$@"`
+	return append([]string{"sh", "-c", script, "sh"}, argv...)
+}
+
+// memoryExhausted guesses from stderr whether a failed block ran out of its address space.
+func memoryExhausted(stderr string) bool {
+	for _, marker := range []string{"MemoryError", "Cannot allocate memory", "out of memory", "std::bad_alloc", "Fatal process OOM"} {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
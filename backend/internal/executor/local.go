@@ -0,0 +1,368 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/multistory/internal/story"
+	"github.com/example/multistory/pkg/id"
+)
+
+// DefaultInterpreters maps Block.Language values to the command that runs a script file.
+var DefaultInterpreters = map[string][]string{
+	"python":     {"python3"},
+	"python3":    {"python3"},
+	"py":         {"python3"},
+	"sh":         {"sh"},
+	"shell":      {"sh"},
+	"bash":       {"bash"},
+	"javascript": {"node"},
+	"js":         {"node"},
+	"node":       {"node"},
+	"r":          {"Rscript"},
+}
+
+// LocalConfig controls how the Local runner starts interpreters.
+type LocalConfig struct {
+	// Interpreters overrides DefaultInterpreters when non-nil. Keys are lower-case languages.
+	Interpreters map[string][]string
+	// Env is appended to the runner's own environment for every block.
+	Env []string
+	// Kernels, when set, runs languages it supports in per-story sessions so blocks share state.
+	// Other languages still get a fresh process per block.
+	Kernels *KernelPool
+	// Limits applies to every process the runner starts, including kernel sessions.
+	Limits Limits
+}
+
+// Local is a Runner that executes code blocks as subprocesses on this machine.
+type Local struct {
+	cfg LocalConfig
+}
+
+var (
+	_ story.Runner           = (*Local)(nil)
+	_ story.SessionRestarter = (*Local)(nil)
+)
+
+// NewLocal creates a Runner that runs code blocks through local interpreters.
+func NewLocal(cfg LocalConfig) *Local {
+	if cfg.Interpreters == nil {
+		cfg.Interpreters = DefaultInterpreters
+	}
+	return &Local{cfg: cfg}
+}
+
+// Execute runs each targeted code block in position order and stops at the first failing block.
+// Other blocks, and blocks after a failure, keep their existing outputs. Cached outputs are reused
+// where planReuse allows. Kernel sessions are restarted before a full run that executes anything,
+// so it never depends on state left by an earlier one, while partial runs reuse that state. Blocks
+// run in a scratch directory that is removed afterwards, and tripped limits are reported in the logs.
+// Parameters are assigned on the first line of every block that runs in its own process, and of
+// the first block run in each kernel, so later blocks see whatever earlier ones did with them.
+func (l *Local) Execute(ctx context.Context, req story.ExecutionRequest) (story.ExecutionResult, error) {
+	started := time.Now().UTC()
+	selected, err := req.Target.Select(req.Story.Blocks, req.Story.Parameters)
+	if err != nil {
+		return story.ExecutionResult{}, err
+	}
+	blocks := append([]story.Block(nil), req.Story.Blocks...)
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].Position < blocks[j].Position })
+	reuse := l.planReuse(blocks, selected, req.Cached)
+	if l.cfg.Kernels != nil && req.Target.All() && len(reuse) < countCode(blocks, selected) {
+		l.cfg.Kernels.Restart(req.Story.ID)
+	}
+	workDir, err := os.MkdirTemp("", "multistory-run-")
+	if err != nil {
+		return story.ExecutionResult{}, err
+	}
+	defer os.RemoveAll(workDir)
+	runCtx := ctx
+	if l.cfg.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, l.cfg.Limits.Timeout)
+		defer cancel()
+	}
+
+	status := "completed"
+	logs := []string{
+		"Execution routed to local runner",
+		fmt.Sprintf("Actor: %s", req.Actor),
+		fmt.Sprintf("Limits: %s", l.cfg.Limits),
+	}
+	if len(req.Parameters) > 0 {
+		logs = append(logs, fmt.Sprintf("Parameters: %s", describeParameters(req.Parameters)))
+	}
+	// primed records the kernels that already received the parameters during this run.
+	primed := make(map[string]bool)
+	executed := 0
+	for i, block := range blocks {
+		if block.Type != story.BlockCode || !selected[block.ID] {
+			continue
+		}
+		if reuse[block.ID] {
+			blocks[i].Outputs = req.Cached[block.ID]
+			reportCached(req, block.ID, blocks[i].Outputs)
+			continue
+		}
+		outputs, notes, err := l.runBlock(runCtx, req, workDir, l.withParameters(block, req.Parameters, primed))
+		if ctx.Err() != nil {
+			return story.ExecutionResult{}, ctx.Err()
+		}
+		blocks[i].Outputs = outputs
+		executed++
+		logs = append(logs, notes...)
+		if err != nil {
+			status = "failed"
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) {
+				logs = append(logs, fmt.Sprintf("Limit exceeded (%s) in block %s: %s", limitErr.Limit, block.ID, limitErr.Detail))
+			}
+			logs = append(logs, fmt.Sprintf("Block %s failed: %v", block.ID, err))
+			break
+		}
+	}
+	logs = append(logs, fmt.Sprintf("Blocks executed: %d", executed), fmt.Sprintf("Blocks reused from cache: %d", len(reuse)))
+
+	return story.ExecutionResult{
+		StoryID:    req.Story.ID,
+		Revision:   id.New(),
+		StartedAt:  started,
+		FinishedAt: time.Now().UTC(),
+		Status:     status,
+		Blocks:     blocks,
+		Logs:       logs,
+	}, nil
+}
+
+// planReuse picks the targeted code blocks whose cached outputs can stand in for running them.
+// Blocks sharing a kernel rely on the state earlier blocks leave behind, so a kernel block is only
+// reused when no block after it has to run.
+func (l *Local) planReuse(blocks []story.Block, selected map[string]bool, cached map[string][]story.Output) map[string]bool {
+	reuse := make(map[string]bool)
+	lastRun := -1
+	for i, block := range blocks {
+		if block.Type != story.BlockCode || !selected[block.ID] {
+			continue
+		}
+		if _, ok := cached[block.ID]; ok {
+			reuse[block.ID] = true
+		} else {
+			lastRun = i
+		}
+	}
+	if l.cfg.Kernels == nil {
+		return reuse
+	}
+	for i := 0; i < lastRun; i++ {
+		if l.cfg.Kernels.Supports(blocks[i].Language) {
+			delete(reuse, blocks[i].ID)
+		}
+	}
+	return reuse
+}
+
+// withParameters returns block with the parameter preamble prepended when it needs one.
+func (l *Local) withParameters(block story.Block, params map[string]interface{}, primed map[string]bool) story.Block {
+	preamble := parameterPreamble(block.Language, params)
+	if preamble == "" {
+		return block
+	}
+	if l.cfg.Kernels != nil && l.cfg.Kernels.Supports(block.Language) {
+		kernel := kernelLanguages[strings.ToLower(block.Language)]
+		if primed[kernel] {
+			return block
+		}
+		primed[kernel] = true
+	}
+	block.Source = preamble + "\n" + block.Source
+	return block
+}
+
+func countCode(blocks []story.Block, selected map[string]bool) int {
+	n := 0
+	for _, block := range blocks {
+		if block.Type == story.BlockCode && selected[block.ID] {
+			n++
+		}
+	}
+	return n
+}
+
+// runBlock runs one block and reports its events. Alongside the outputs it returns log notes about
+// output that was dropped, and a LimitError when a limit stopped the block.
+func (l *Local) runBlock(ctx context.Context, req story.ExecutionRequest, workDir string, block story.Block) ([]story.Output, []string, error) {
+	blockStarted := time.Now()
+	req.Report(story.BlockEvent{BlockID: block.ID, Kind: story.BlockEventStarted})
+	stdout := l.newStream(req, block.ID, story.BlockEventStdout)
+	stderr := l.newStream(req, block.ID, story.BlockEventStderr)
+	var err error
+	if l.cfg.Kernels != nil && l.cfg.Kernels.Supports(block.Language) {
+		err = l.runInKernel(ctx, req, block, stdout, stderr)
+	} else {
+		err = l.run(ctx, workDir, block, stdout, stderr)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = &LimitError{Limit: "time", Detail: fmt.Sprintf("execution exceeded its %s time limit", l.cfg.Limits.Timeout)}
+	} else if err != nil && l.cfg.Limits.MemoryBytes > 0 && memoryExhausted(stderr.String()+string(stderr.tail)) {
+		err = &LimitError{Limit: "memory", Detail: fmt.Sprintf("%v (memory limit %d MiB)", err, l.cfg.Limits.MemoryBytes>>20)}
+	}
+
+	var outputs []story.Output
+	var notes []string
+	for _, stream := range []*streamWriter{stdout, stderr} {
+		out := stream.String()
+		if stream.dropped > 0 {
+			out += fmt.Sprintf("\n[output truncated: %d bytes dropped]\n", stream.dropped)
+			notes = append(notes, fmt.Sprintf("Limit exceeded (output) in block %s: dropped %d bytes of %s", block.ID, stream.dropped, stream.name()))
+		}
+		if out != "" {
+			outputs = append(outputs, story.Output{Kind: stream.name(), MimeType: "text/plain", Data: out})
+		}
+	}
+	if err != nil {
+		outputs = append(outputs, errorOutput(err))
+	}
+	for i := range outputs {
+		req.Report(story.BlockEvent{BlockID: block.ID, Kind: story.BlockEventOutput, Output: &outputs[i]})
+	}
+	status := "completed"
+	if err != nil {
+		status = "failed"
+	}
+	req.Report(story.BlockEvent{
+		BlockID:    block.ID,
+		Kind:       story.BlockEventFinished,
+		Status:     status,
+		DurationMs: time.Since(blockStarted).Milliseconds(),
+	})
+	return outputs, notes, err
+}
+
+// RestartSessions discards the story's kernel sessions, clearing any variables they hold.
+func (l *Local) RestartSessions(_ context.Context, storyID string) error {
+	if l.cfg.Kernels == nil {
+		return story.ErrNoSessions
+	}
+	l.cfg.Kernels.Restart(storyID)
+	return nil
+}
+
+func (l *Local) run(ctx context.Context, workDir string, block story.Block, stdout, stderr *streamWriter) error {
+	interpreter, ok := l.cfg.Interpreters[strings.ToLower(block.Language)]
+	if !ok || len(interpreter) == 0 {
+		return fmt.Errorf("no interpreter configured for language %q", block.Language)
+	}
+	script := filepath.Join(workDir, block.ID)
+	if err := os.WriteFile(script, []byte(block.Source), 0o600); err != nil {
+		return err
+	}
+
+	argv := l.cfg.Limits.wrap(append(append([]string(nil), interpreter...), script))
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = workDir
+	cmd.Env = append(append(os.Environ(), "TMPDIR="+workDir), l.cfg.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := sandbox(cmd, l.cfg.Limits); err != nil {
+		return err
+	}
+	cmd.Cancel = func() error { return killTree(cmd) }
+	// Background children may hold the pipes open after the block itself exits.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("start %s: %w", interpreter[0], startError(err, l.cfg.Limits))
+	}
+	if cpuExceeded(exitErr.ProcessState) {
+		return &LimitError{Limit: "cpu", Detail: fmt.Sprintf("block exceeded its %s CPU time limit", l.cfg.Limits.CPUTime)}
+	}
+	return err
+}
+
+func (l *Local) runInKernel(ctx context.Context, req story.ExecutionRequest, block story.Block, stdout, stderr *streamWriter) error {
+	session, err := l.cfg.Kernels.Acquire(req.Story.ID, block.Language, l.cfg.Limits, l.cfg.Env)
+	if err != nil {
+		return err
+	}
+	status, err := session.Run(ctx, block.Source, func(p []byte) { stdout.Write(p) }, func(p []byte) { stderr.Write(p) })
+	if errors.Is(err, ErrKernelExited) && session.cpuExceeded() {
+		return &LimitError{Limit: "cpu", Detail: fmt.Sprintf("kernel exceeded its %s CPU time limit", l.cfg.Limits.CPUTime)}
+	}
+	if err == nil && status != 0 {
+		err = fmt.Errorf("exit status %d", status)
+	}
+	return err
+}
+
+func errorOutput(err error) story.Output {
+	return story.Output{Kind: "error", MimeType: "text/plain", Data: err.Error()}
+}
+
+func (l *Local) newStream(req story.ExecutionRequest, blockID string, kind story.BlockEventKind) *streamWriter {
+	return &streamWriter{req: req, blockID: blockID, kind: kind, limit: l.cfg.Limits.MaxOutputBytes}
+}
+
+// streamWriter buffers a process stream while forwarding each chunk as a block event. Past limit
+// bytes it keeps accepting writes so the process never blocks, but only counts what it drops.
+type streamWriter struct {
+	req     story.ExecutionRequest
+	blockID string
+	kind    story.BlockEventKind
+	limit   int
+
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	dropped int
+	// tail keeps the last dropped bytes, where errors usually end up, for diagnosing the failure.
+	tail []byte
+}
+
+const streamTailBytes = 1024
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	w.mu.Lock()
+	if w.limit > 0 && w.buf.Len()+len(p) > w.limit {
+		keep := w.limit - w.buf.Len()
+		w.dropped += len(p) - keep
+		w.tail = append(w.tail, p[keep:]...)
+		if len(w.tail) > streamTailBytes {
+			w.tail = w.tail[len(w.tail)-streamTailBytes:]
+		}
+		p = p[:keep]
+	}
+	w.buf.Write(p)
+	w.mu.Unlock()
+	if len(p) > 0 {
+		w.req.Report(story.BlockEvent{BlockID: w.blockID, Kind: w.kind, Text: string(p)})
+	}
+	return n, nil
+}
+
+// name is the Output kind for the stream.
+func (w *streamWriter) name() string {
+	if w.kind == story.BlockEventStderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+func (w *streamWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
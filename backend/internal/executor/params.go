@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parameterPreamble returns one line of code in the block's language that assigns every parameter
+// to a variable of the same name, or "" when there is nothing to inject or the language is not
+// known. Keeping it to one line shifts the block's own line numbers by at most one.
+func parameterPreamble(language string, params map[string]interface{}) string {
+	if len(params) == 0 {
+		return ""
+	}
+	names := sortedNames(params)
+	var assign func(name string, value interface{}) string
+	switch strings.ToLower(language) {
+	case "python", "python3", "py":
+		assign = func(name string, value interface{}) string { return name + " = " + literal(value) }
+	case "javascript", "js", "node":
+		assign = func(name string, value interface{}) string { return "var " + name + " = " + literal(value) }
+	case "r":
+		assign = func(name string, value interface{}) string { return name + " <- " + literal(value) }
+	case "sh", "shell", "bash":
+		assign = func(name string, value interface{}) string { return name + "=" + shellQuote(value) }
+	default:
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = assign(name, params[name]) + ";"
+	}
+	return strings.Join(parts, " ")
+}
+
+// literal renders a parameter value as a JSON literal, which Python, JavaScript and R all read as
+// the same number or string.
+func literal(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "null"
+	}
+	return string(encoded)
+}
+
+func shellQuote(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// describeParameters summarises parameter values for execution logs.
+func describeParameters(params map[string]interface{}) string {
+	names := sortedNames(params)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + literal(params[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedNames(params map[string]interface{}) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// perBlockCPU reports that kernels can be held to the CPU limit one block at a time, by sampling
+// their process group with groupCPU, rather than by an rlimit that counts every block they run.
+const perBlockCPU = true
+
+// userHZ is the unit of the CPU times in /proc/<pid>/stat.
+const userHZ = 100
+
+// sandbox puts cmd in its own process group so the whole tree can be killed, and optionally in
+// fresh user and network namespaces that leave it with only a loopback interface.
+func sandbox(cmd *exec.Cmd, limits Limits) error {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if limits.DenyNetwork {
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+	cmd.SysProcAttr = attr
+	return nil
+}
+
+// killTree kills cmd and anything it spawned.
+func killTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// cpuExceeded reports whether the process was stopped by its CPU rlimit.
+func cpuExceeded(state *os.ProcessState) bool {
+	if state == nil {
+		return false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
+
+// startError explains a failure to start cmd under limits. Network isolation needs unprivileged
+// user namespaces, which many hosts disable.
+func startError(err error, limits Limits) error {
+	if !limits.DenyNetwork {
+		return err
+	}
+	for _, errno := range []error{syscall.EPERM, syscall.EACCES, syscall.EINVAL, syscall.ENOSPC} {
+		if errors.Is(err, errno) {
+			return fmt.Errorf("executor: network isolation needs unprivileged user namespaces, which this host does not allow (see the user.max_user_namespaces and kernel.unprivileged_userns_clone sysctls): %w", err)
+		}
+	}
+	return err
+}
+
+// CheckIsolation starts a trivial process with network isolation and returns startError's
+// explanation if that fails, so a host without support is caught before any block runs.
+func CheckIsolation() error {
+	cmd := exec.Command("sh", "-c", ":")
+	limits := Limits{DenyNetwork: true}
+	if err := sandbox(cmd, limits); err != nil {
+		return err
+	}
+	if err := cmd.Run(); err != nil {
+		return startError(err, limits)
+	}
+	return nil
+}
+
+// groupCPU returns the CPU time used so far by the processes in group pgid, including children
+// they have already reaped.
+func groupCPU(pgid int) (time.Duration, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	group := strconv.Itoa(pgid)
+	var ticks uint64
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			// The process exited since the directory was read.
+			continue
+		}
+		// The command name may contain spaces, so count fields from after its closing parenthesis:
+		// the state is field 3, the process group field 5 and utime, stime, cutime and cstime 14-17.
+		end := bytes.LastIndexByte(data, ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data[end+1:]))
+		if len(fields) < 15 || fields[2] != group {
+			continue
+		}
+		for _, field := range fields[11:15] {
+			n, _ := strconv.ParseUint(field, 10, 64)
+			ticks += n
+		}
+	}
+	return time.Duration(ticks) * time.Second / userHZ, nil
+}
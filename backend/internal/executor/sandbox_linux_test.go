@@ -0,0 +1,26 @@
+//go:build linux
+
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStartErrorExplainsIsolation(t *testing.T) {
+	err := fmt.Errorf("fork/exec /usr/bin/python3: %w", syscall.EPERM)
+
+	explained := startError(err, Limits{DenyNetwork: true})
+	if !strings.Contains(explained.Error(), "user namespaces") {
+		t.Fatalf("expected the error to name user namespaces, got %v", explained)
+	}
+	if !errors.Is(explained, syscall.EPERM) {
+		t.Fatalf("expected the cause to be kept, got %v", explained)
+	}
+	if got := startError(err, Limits{}); got != err {
+		t.Fatalf("expected errors without isolation to pass through, got %v", got)
+	}
+}
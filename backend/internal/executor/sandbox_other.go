@@ -0,0 +1,50 @@
+//go:build !linux
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// perBlockCPU is false here: kernels run under the CPU rlimit, which counts every block a session
+// has run.
+const perBlockCPU = false
+
+// sandbox only supports network isolation on Linux; elsewhere it refuses rather than run unconfined.
+func sandbox(_ *exec.Cmd, limits Limits) error {
+	if limits.DenyNetwork {
+		return errors.New("executor: network isolation is only supported on linux")
+	}
+	return nil
+}
+
+// killTree kills cmd. Children it spawned are not tracked on this platform.
+func killTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// cpuExceeded cannot tell a CPU rlimit from other signals on this platform.
+func cpuExceeded(*os.ProcessState) bool {
+	return false
+}
+
+// startError returns err unchanged; network isolation never gets as far as starting a process here.
+func startError(err error, _ Limits) error {
+	return err
+}
+
+// CheckIsolation reports that network isolation is unsupported on this platform.
+func CheckIsolation() error {
+	return sandbox(nil, Limits{DenyNetwork: true})
+}
+
+// groupCPU is not available on this platform.
+func groupCPU(int) (time.Duration, error) {
+	return 0, errors.New("executor: per-block CPU accounting is only supported on linux")
+}
@@ -0,0 +1,424 @@
+// Package jobs runs story executions in the background so HTTP handlers can return immediately.
+// Jobs wait in a FIFO queue until a worker is free and no other job of their story is running.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/example/multistory/internal/realtime"
+	"github.com/example/multistory/internal/story"
+	"github.com/example/multistory/pkg/id"
+)
+
+var (
+	// ErrNotFound is returned when a job ID is unknown or has been pruned.
+	ErrNotFound = errors.New("jobs: not found")
+	// ErrFinished is returned when cancelling a job that already reached a terminal state.
+	ErrFinished = errors.New("jobs: already finished")
+	// ErrClosed is returned when submitting after Shutdown has begun.
+	ErrClosed = errors.New("jobs: manager closed")
+	// ErrQueueFull is returned when the queue already holds Config.QueueSize jobs.
+	ErrQueueFull = errors.New("jobs: queue full")
+)
+
+// Config bounds how much execution work runs at once. Zero values select the defaults.
+type Config struct {
+	// Workers caps executions running across all stories. Defaults to 4. A story runs one job at
+	// a time whatever the setting, since its runs share kernels and commit outputs to the story.
+	Workers int
+	// QueueSize caps jobs waiting for a worker. Defaults to 100.
+	QueueSize int
+	// Coalesce makes a submission for a story that already has a job waiting return that job
+	// instead of queueing another run; the waiting job will pick up the latest story anyway.
+	Coalesce bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 100
+	}
+	return c
+}
+
+// Stats is a snapshot of the queue.
+type Stats struct {
+	Workers int `json:"workers"`
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+}
+
+// Status tracks a job through its lifecycle.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Terminal reports whether the status is final.
+func (s Status) Terminal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// Job is a snapshot of an asynchronous story execution. While it waits, QueuePosition is its
+// 1-based place in the queue.
+type Job struct {
+	ID            string                 `json:"id"`
+	StoryID       string                 `json:"storyId"`
+	Actor         string                 `json:"actor"`
+	Target        story.ExecutionTarget  `json:"target"`
+	Force         bool                   `json:"force,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Status        Status                 `json:"status"`
+	QueuePosition int                    `json:"queuePosition,omitempty"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	StartedAt     *time.Time             `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time             `json:"finishedAt,omitempty"`
+	Result        *story.ExecutionResult `json:"result,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+}
+
+// retention is how long finished jobs stay queryable.
+const retention = time.Hour
+
+type entry struct {
+	job    Job
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Manager owns background executions and publishes their state changes through the hub.
+type Manager struct {
+	stories story.Service
+	hub     *realtime.Hub
+	cfg     Config
+	now     func() time.Time
+
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	jobs map[string]*entry
+	// queue holds jobs waiting for a worker in submission order.
+	queue   []*entry
+	running int
+	// runningStories holds the stories that have a job running.
+	runningStories map[string]bool
+	closed         bool
+}
+
+// NewManager returns a Manager that executes stories through svc within the limits of cfg.
+func NewManager(svc story.Service, hub *realtime.Hub, cfg Config) *Manager {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Manager{
+		stories:        svc,
+		hub:            hub,
+		cfg:            cfg.withDefaults(),
+		now:            func() time.Time { return time.Now().UTC() },
+		ctx:            ctx,
+		stop:           stop,
+		jobs:           make(map[string]*entry),
+		runningStories: make(map[string]bool),
+	}
+}
+
+// Submit validates the story, target and parameters and queues the execution, returning the queued
+// job. With Config.Coalesce set, a job already waiting to run the same target with the same
+// parameters is returned instead.
+func (m *Manager) Submit(ctx context.Context, storyID string, opts story.ExecuteOptions) (Job, error) {
+	current, err := m.stories.GetStory(ctx, storyID)
+	if err != nil {
+		return Job{}, err
+	}
+	if _, err := opts.Target.Select(current.Blocks, current.Parameters); err != nil {
+		return Job{}, err
+	}
+	if _, err := story.ResolveParameters(current.Parameters, opts.Parameters); err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return Job{}, ErrClosed
+	}
+	m.pruneLocked()
+	if m.cfg.Coalesce {
+		for _, queued := range m.queue {
+			if queued.job.StoryID == storyID && queued.job.Target == opts.Target && queued.job.Force == opts.Force &&
+				sameValues(queued.job.Parameters, opts.Parameters) {
+				job := m.snapshotLocked(queued)
+				m.mu.Unlock()
+				return job, nil
+			}
+		}
+	}
+	if len(m.queue) >= m.cfg.QueueSize {
+		m.mu.Unlock()
+		return Job{}, ErrQueueFull
+	}
+	jobCtx, cancel := context.WithCancel(m.ctx)
+	e := &entry{
+		job: Job{
+			ID:         id.New(),
+			StoryID:    storyID,
+			Actor:      opts.Actor,
+			Target:     opts.Target,
+			Force:      opts.Force,
+			Parameters: opts.Parameters,
+			Status:     StatusQueued,
+			CreatedAt:  m.now(),
+		},
+		ctx:    jobCtx,
+		cancel: cancel,
+	}
+	m.jobs[e.job.ID] = e
+	m.queue = append(m.queue, e)
+	started, moved := m.dispatchLocked()
+	job := m.snapshotLocked(e)
+	m.mu.Unlock()
+
+	m.publish(job)
+	m.launch(started, moved)
+	return job, nil
+}
+
+// Get returns the current state of a job.
+func (m *Manager) Get(jobID string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[jobID]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return m.snapshotLocked(e), nil
+}
+
+// Stats reports how many jobs are running and waiting.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Stats{Workers: m.cfg.Workers, Running: m.running, Queued: len(m.queue)}
+}
+
+// Cancel aborts a job. Queued jobs leave the queue at once; running jobs are stopped through their
+// context.
+func (m *Manager) Cancel(jobID string) (Job, error) {
+	m.mu.Lock()
+	e, ok := m.jobs[jobID]
+	if !ok {
+		m.mu.Unlock()
+		return Job{}, ErrNotFound
+	}
+	if e.job.Status.Terminal() {
+		job := e.job
+		m.mu.Unlock()
+		return job, ErrFinished
+	}
+	if i := m.queueIndexLocked(e); i >= 0 {
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+		moved := m.queuedFromLocked(i)
+		m.mu.Unlock()
+		e.cancel()
+		job := m.finish(e, nil, context.Canceled)
+		m.launch(nil, moved)
+		return job, nil
+	}
+	job := e.job
+	m.mu.Unlock()
+	e.cancel()
+	return job, nil
+}
+
+// Shutdown stops accepting work, cancels queued and in-flight jobs and waits for them or ctx to finish.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	queued := m.queue
+	m.queue = nil
+	m.mu.Unlock()
+	m.stop()
+	for _, e := range queued {
+		m.finish(e, nil, context.Canceled)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dispatchLocked moves queued jobs onto free workers in FIFO order, skipping jobs whose story
+// already has one running. It returns the jobs to start and the snapshots of jobs whose queue position changed.
+func (m *Manager) dispatchLocked() (started []*entry, moved []Job) {
+	if m.closed {
+		return nil, nil
+	}
+	first := -1
+	waiting := m.queue[:0]
+	for i, e := range m.queue {
+		if m.running < m.cfg.Workers && !m.runningStories[e.job.StoryID] {
+			m.running++
+			m.runningStories[e.job.StoryID] = true
+			m.wg.Add(1)
+			started = append(started, e)
+			if first < 0 {
+				first = i
+			}
+			continue
+		}
+		waiting = append(waiting, e)
+	}
+	for i := len(waiting); i < len(m.queue); i++ {
+		m.queue[i] = nil
+	}
+	m.queue = waiting
+	if first >= 0 {
+		moved = m.queuedFromLocked(first)
+	}
+	return started, moved
+}
+
+// queuedFromLocked snapshots the queued jobs from index i onwards.
+func (m *Manager) queuedFromLocked(i int) []Job {
+	var jobs []Job
+	for _, e := range m.queue[i:] {
+		jobs = append(jobs, m.snapshotLocked(e))
+	}
+	return jobs
+}
+
+func (m *Manager) queueIndexLocked(e *entry) int {
+	for i, queued := range m.queue {
+		if queued == e {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshotLocked copies a job and fills in its current queue position.
+func (m *Manager) snapshotLocked(e *entry) Job {
+	job := e.job
+	if job.Status == StatusQueued {
+		job.QueuePosition = m.queueIndexLocked(e) + 1
+	}
+	return job
+}
+
+// launch starts dispatched jobs and tells clients about queue positions that moved.
+func (m *Manager) launch(started []*entry, moved []Job) {
+	for _, job := range moved {
+		m.publish(job)
+	}
+	for _, e := range started {
+		go m.run(e)
+	}
+}
+
+// release frees the job's worker slot and hands it to the next eligible queued job.
+func (m *Manager) release(e *entry) {
+	m.mu.Lock()
+	m.running--
+	delete(m.runningStories, e.job.StoryID)
+	started, moved := m.dispatchLocked()
+	m.mu.Unlock()
+	m.launch(started, moved)
+}
+
+func (m *Manager) run(e *entry) {
+	defer m.wg.Done()
+	defer m.release(e)
+	defer e.cancel()
+
+	ctx := e.ctx
+	if ctx.Err() != nil {
+		m.finish(e, nil, ctx.Err())
+		return
+	}
+	started := m.now()
+	m.update(e, func(job *Job) {
+		job.Status = StatusRunning
+		job.StartedAt = &started
+	})
+
+	result, err := m.stories.ExecuteStory(ctx, e.job.StoryID, story.ExecuteOptions{
+		Actor:      e.job.Actor,
+		Target:     e.job.Target,
+		Force:      e.job.Force,
+		Parameters: e.job.Parameters,
+	})
+	if err != nil {
+		m.finish(e, nil, err)
+		return
+	}
+	m.finish(e, &result, nil)
+}
+
+func (m *Manager) finish(e *entry, result *story.ExecutionResult, err error) Job {
+	finished := m.now()
+	return m.update(e, func(job *Job) {
+		job.FinishedAt = &finished
+		job.Result = result
+		switch {
+		case err == nil && result.Status == "failed":
+			job.Status = StatusFailed
+			job.Error = "one or more blocks failed"
+		case err == nil:
+			job.Status = StatusCompleted
+		case errors.Is(err, context.Canceled):
+			job.Status = StatusCancelled
+			job.Error = err.Error()
+		default:
+			job.Status = StatusFailed
+			job.Error = err.Error()
+		}
+	})
+}
+
+func (m *Manager) update(e *entry, fn func(job *Job)) Job {
+	m.mu.Lock()
+	fn(&e.job)
+	job := m.snapshotLocked(e)
+	m.mu.Unlock()
+	m.publish(job)
+	return job
+}
+
+func (m *Manager) publish(job Job) {
+	m.hub.Publish(realtime.Event{StoryID: job.StoryID, Type: "execution." + string(job.Status), Payload: job})
+}
+
+// pruneLocked drops finished jobs older than the retention window. Callers hold m.mu.
+func (m *Manager) pruneLocked() {
+	cutoff := m.now().Add(-retention)
+	for jobID, e := range m.jobs {
+		if e.job.FinishedAt != nil && e.job.FinishedAt.Before(cutoff) {
+			delete(m.jobs, jobID)
+		}
+	}
+}
+
+// sameValues reports whether two sets of submitted parameter values are equal; nil and empty match.
+func sameValues(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,271 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/example/multistory/internal/jobs"
+	"github.com/example/multistory/internal/realtime"
+	"github.com/example/multistory/internal/story"
+)
+
+// gatedRunner reports each run as it starts and holds it until released or cancelled.
+type gatedRunner struct {
+	started chan string
+	release chan struct{}
+}
+
+func (r *gatedRunner) Execute(ctx context.Context, req story.ExecutionRequest) (story.ExecutionResult, error) {
+	r.started <- req.Story.ID
+	select {
+	case <-r.release:
+		return story.ExecutionResult{StoryID: req.Story.ID, Status: "completed"}, nil
+	case <-ctx.Done():
+		return story.ExecutionResult{}, ctx.Err()
+	}
+}
+
+type fixture struct {
+	manager *jobs.Manager
+	stories story.Service
+	runner  *gatedRunner
+}
+
+func newFixture(t *testing.T, cfg jobs.Config) *fixture {
+	t.Helper()
+	runner := &gatedRunner{started: make(chan string, 16), release: make(chan struct{})}
+	hub := realtime.NewHub()
+	svc := story.NewService(story.NewMemoryRepository(), runner, hub, nil)
+	f := &fixture{manager: jobs.NewManager(svc, hub, cfg), stories: svc, runner: runner}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		f.manager.Shutdown(ctx)
+	})
+	return f
+}
+
+func (f *fixture) createStory(t *testing.T, title string) string {
+	t.Helper()
+	created, err := f.stories.CreateStory(context.Background(), story.CreateStoryInput{
+		Title:  title,
+		Blocks: []story.BlockInput{{Type: story.BlockCode, Language: "python", Source: "print(1)"}},
+	})
+	if err != nil {
+		t.Fatalf("create story: %v", err)
+	}
+	return created.ID
+}
+
+func (f *fixture) submit(t *testing.T, storyID string, opts story.ExecuteOptions) jobs.Job {
+	t.Helper()
+	job, err := f.manager.Submit(context.Background(), storyID, opts)
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	return job
+}
+
+// expectStart waits for the runner to begin a run of storyID.
+func (f *fixture) expectStart(t *testing.T, storyID string) {
+	t.Helper()
+	select {
+	case got := <-f.runner.started:
+		if got != storyID {
+			t.Fatalf("expected a run of %s to start, got %s", storyID, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a run of %s", storyID)
+	}
+}
+
+// expectNoStart checks that no further run begins for a while.
+func (f *fixture) expectNoStart(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-f.runner.started:
+		t.Fatalf("expected no run to start, got one of %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (f *fixture) waitStatus(t *testing.T, jobID string, want jobs.Status) jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := f.manager.Get(jobID)
+		if err != nil {
+			t.Fatalf("get job: %v", err)
+		}
+		if job.Status == want {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job %s to be %s, got %s", jobID, want, job.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *fixture) queuePosition(t *testing.T, jobID string) int {
+	t.Helper()
+	job, err := f.manager.Get(jobID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	return job.QueuePosition
+}
+
+func TestManagerRunsJobsInOrder(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 1})
+	a, b, c := f.createStory(t, "a"), f.createStory(t, "b"), f.createStory(t, "c")
+
+	first := f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	second := f.submit(t, b, story.ExecuteOptions{})
+	third := f.submit(t, c, story.ExecuteOptions{})
+	if second.QueuePosition != 1 || third.QueuePosition != 2 {
+		t.Fatalf("expected queue positions 1 and 2, got %d and %d", second.QueuePosition, third.QueuePosition)
+	}
+	if stats := f.manager.Stats(); stats.Running != 1 || stats.Queued != 2 {
+		t.Fatalf("expected 1 running and 2 queued, got %+v", stats)
+	}
+
+	f.runner.release <- struct{}{}
+	f.waitStatus(t, first.ID, jobs.StatusCompleted)
+	f.expectStart(t, b)
+	if pos := f.queuePosition(t, third.ID); pos != 1 {
+		t.Fatalf("expected the last job to move up to position 1, got %d", pos)
+	}
+
+	f.runner.release <- struct{}{}
+	f.expectStart(t, c)
+	f.runner.release <- struct{}{}
+	f.waitStatus(t, third.ID, jobs.StatusCompleted)
+}
+
+func TestManagerRunsOneJobPerStory(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 4})
+	a, b := f.createStory(t, "a"), f.createStory(t, "b")
+
+	f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	waiting := f.submit(t, a, story.ExecuteOptions{Force: true})
+	f.submit(t, b, story.ExecuteOptions{})
+	f.expectStart(t, b)
+	f.expectNoStart(t)
+	if pos := f.queuePosition(t, waiting.ID); pos != 1 {
+		t.Fatalf("expected the second run of the story to wait at position 1, got %d", pos)
+	}
+
+	f.runner.release <- struct{}{}
+	f.runner.release <- struct{}{}
+	f.expectStart(t, a)
+	f.runner.release <- struct{}{}
+	f.waitStatus(t, waiting.ID, jobs.StatusCompleted)
+}
+
+func TestManagerCoalescesWaitingJobs(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 1, Coalesce: true})
+	a, b := f.createStory(t, "a"), f.createStory(t, "b")
+
+	running := f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	if again := f.submit(t, a, story.ExecuteOptions{}); again.ID == running.ID {
+		t.Fatalf("expected a running job not to absorb new submissions")
+	}
+
+	queued := f.submit(t, b, story.ExecuteOptions{})
+	if again := f.submit(t, b, story.ExecuteOptions{Actor: "someone else"}); again.ID != queued.ID {
+		t.Fatalf("expected a submission matching a waiting job to return it")
+	}
+	if forced := f.submit(t, b, story.ExecuteOptions{Force: true}); forced.ID == queued.ID {
+		t.Fatalf("expected a forced run not to coalesce with an unforced one")
+	}
+	if stats := f.manager.Stats(); stats.Queued != 3 {
+		t.Fatalf("expected 3 queued jobs, got %+v", stats)
+	}
+}
+
+func TestManagerCoalescingIsOptional(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 1})
+	a, b := f.createStory(t, "a"), f.createStory(t, "b")
+
+	f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	first := f.submit(t, b, story.ExecuteOptions{})
+	if second := f.submit(t, b, story.ExecuteOptions{}); second.ID == first.ID {
+		t.Fatalf("expected separate jobs without coalescing")
+	}
+}
+
+func TestManagerRejectsWhenQueueFull(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 1, QueueSize: 1})
+	a, b := f.createStory(t, "a"), f.createStory(t, "b")
+
+	f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	f.submit(t, b, story.ExecuteOptions{})
+	if _, err := f.manager.Submit(context.Background(), b, story.ExecuteOptions{Force: true}); !errors.Is(err, jobs.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 1})
+	a, b, c := f.createStory(t, "a"), f.createStory(t, "b"), f.createStory(t, "c")
+
+	running := f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	queued := f.submit(t, b, story.ExecuteOptions{})
+	last := f.submit(t, c, story.ExecuteOptions{})
+
+	cancelled, err := f.manager.Cancel(queued.ID)
+	if err != nil {
+		t.Fatalf("cancel queued job: %v", err)
+	}
+	if cancelled.Status != jobs.StatusCancelled {
+		t.Fatalf("expected a queued job to be cancelled at once, got %s", cancelled.Status)
+	}
+	if pos := f.queuePosition(t, last.ID); pos != 1 {
+		t.Fatalf("expected the last job to move up to position 1, got %d", pos)
+	}
+	if _, err := f.manager.Cancel(queued.ID); !errors.Is(err, jobs.ErrFinished) {
+		t.Fatalf("expected ErrFinished, got %v", err)
+	}
+	if _, err := f.manager.Cancel("missing"); !errors.Is(err, jobs.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := f.manager.Cancel(running.ID); err != nil {
+		t.Fatalf("cancel running job: %v", err)
+	}
+	f.waitStatus(t, running.ID, jobs.StatusCancelled)
+	f.expectStart(t, c)
+}
+
+func TestManagerShutdown(t *testing.T) {
+	f := newFixture(t, jobs.Config{Workers: 1})
+	a, b := f.createStory(t, "a"), f.createStory(t, "b")
+
+	running := f.submit(t, a, story.ExecuteOptions{})
+	f.expectStart(t, a)
+	queued := f.submit(t, b, story.ExecuteOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := f.manager.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	for _, jobID := range []string{running.ID, queued.ID} {
+		if job, _ := f.manager.Get(jobID); job.Status != jobs.StatusCancelled {
+			t.Fatalf("expected job %s to be cancelled, got %s", jobID, job.Status)
+		}
+	}
+	f.expectNoStart(t)
+	if _, err := f.manager.Submit(context.Background(), a, story.ExecuteOptions{}); !errors.Is(err, jobs.ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -2,63 +2,223 @@ package realtime
 
 import (
     "encoding/json"
+    "strconv"
+    "strings"
     "sync"
+    "time"
 )
 
 // Event represents a message delivered to listeners on a story channel.
 type Event struct {
+    // ID numbers the story's events in publish order. Publish assigns it.
+    ID      uint64      `json:"id,omitempty"`
     StoryID string      `json:"storyId"`
     Type    string      `json:"type"`
     Payload interface{} `json:"payload"`
 }
 
+// subscriberBuffer sizes each listener's queue; per-block execution events arrive in bursts.
+const subscriberBuffer = 64
+
+// historySize bounds how many recent events each story keeps for subscribers that resume.
+const historySize = 256
+
+// channelIdle is how long a story nobody subscribes to keeps its history before the hub forgets
+// it.
+const channelIdle = 10 * time.Minute
+
 // Hub fan-outs events to interested subscribers per story.
 type Hub struct {
-    mu           sync.RWMutex
-    subscribers  map[string]map[chan Event]struct{}
+    mu      sync.Mutex
+    stories map[string]*channel
+    // epoch tells IDs issued by this hub apart from those of an earlier run, whose sequence
+    // numbers restarted.
+    epoch string
+    now   func() time.Time
+    // floor is the highest sequence number of any evicted channel. A story's channel starts
+    // counting from it, so IDs issued before an eviction never match later events.
+    floor uint64
+    swept time.Time
+}
+
+// channel is the state the hub keeps per story. Its mutex numbers the story's events, and tail
+// chains their delivery so every subscriber sees them in order. Delivery happens outside the
+// mutex: a blocking subscriber holds up later publishers to the same story, but not subscribers
+// coming or going.
+type channel struct {
+    mu          sync.Mutex
+    seq         uint64
+    subscribers map[*Subscription]struct{}
+    // tail is closed once the latest event has been queued for every subscriber.
+    tail chan struct{}
+    // history is a ring of the latest events; next is where the following one goes.
+    history []Event
+    next    int
+    // lastUsed is when the channel last saw an event or a subscriber come or go; evicted is set
+    // once the hub has dropped it.
+    lastUsed time.Time
+    evicted  bool
+}
+
+// Replay reports what Resume found in the story's recent history.
+type Replay struct {
+    // Events lists the events published after the requested ID, oldest first.
+    Events []Event
+    // Complete is false when some of those events are no longer buffered, or the ID was issued
+    // before the server restarted or the story's history was dropped for being idle. The client
+    // has to refetch the story.
+    Complete bool
+    // LastID is the ID of the newest event published before the subscription started.
+    LastID uint64
 }
 
 // NewHub constructs an empty broadcaster.
 func NewHub() *Hub {
-    return &Hub{subscribers: make(map[string]map[chan Event]struct{})}
+    return &Hub{
+        stories: make(map[string]*channel),
+        epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+        now:     time.Now,
+    }
 }
 
-// Publish sends the event to all subscribers, dropping messages on slow listeners.
+// Publish numbers the event, records it in the story's history and queues it for every
+// subscriber, applying each one's overflow policy when its queue is full.
 func (h *Hub) Publish(event Event) {
-    h.mu.RLock()
-    defer h.mu.RUnlock()
-    subs := h.subscribers[event.StoryID]
-    for ch := range subs {
-        select {
-        case ch <- event:
-        default:
-            // Drop the message if the receiver is not keeping up to keep the hub snappy.
+    c := h.lock(event.StoryID)
+    c.lastUsed = h.now()
+    c.seq++
+    event.ID = c.seq
+    if len(c.history) < historySize {
+        c.history = append(c.history, event)
+    } else {
+        c.history[c.next] = event
+    }
+    c.next = (c.next + 1) % historySize
+    subscribers := make([]*Subscription, 0, len(c.subscribers))
+    for sub := range c.subscribers {
+        subscribers = append(subscribers, sub)
+    }
+    prev, done := c.tail, make(chan struct{})
+    c.tail = done
+    c.mu.Unlock()
+
+    if prev != nil {
+        <-prev
+    }
+    for _, sub := range subscribers {
+        sub.push(event)
+    }
+    close(done)
+}
+
+// Subscribe attaches a new listener to the story ID, returning a cancel func to release resources.
+func (h *Hub) Subscribe(storyID string, opts SubscribeOptions) (*Subscription, func()) {
+    sub, _, cancel := h.Resume(storyID, "", opts)
+    return sub, cancel
+}
+
+// Resume subscribes like Subscribe and also returns the events published after lastEventID, a
+// value previously produced by StreamID. An empty lastEventID replays nothing.
+func (h *Hub) Resume(storyID string, lastEventID string, opts SubscribeOptions) (*Subscription, Replay, func()) {
+    sub := newSubscription(storyID, opts)
+    c := h.lock(storyID)
+    defer c.mu.Unlock()
+    c.lastUsed = h.now()
+    c.subscribers[sub] = struct{}{}
+
+    replay := Replay{Complete: true, LastID: c.seq}
+    if lastEventID != "" {
+        last, ok := h.parseStreamID(lastEventID)
+        switch {
+        case !ok || last > c.seq:
+            replay.Complete = false
+        case last < c.seq:
+            events := c.ordered()
+            if len(events) == 0 || events[0].ID > last+1 {
+                replay.Complete = false
+            } else {
+                replay.Events = append([]Event(nil), events[last+1-events[0].ID:]...)
+            }
         }
     }
+
+    cancel := func() {
+        // Close first so a Publish blocked on this subscriber gives up at once.
+        sub.close()
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        delete(c.subscribers, sub)
+        c.lastUsed = h.now()
+    }
+    return sub, replay, cancel
+}
+
+// StreamID renders an event ID for an SSE id: field. Resume accepts it back.
+func (h *Hub) StreamID(id uint64) string {
+    return h.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+func (h *Hub) parseStreamID(s string) (uint64, bool) {
+    epoch, seq, ok := strings.Cut(s, "-")
+    if !ok || epoch != h.epoch {
+        return 0, false
+    }
+    id, err := strconv.ParseUint(seq, 10, 64)
+    return id, err == nil
 }
 
-// Subscribe attaches a new channel to the story ID, returning a cancel func to release resources.
-func (h *Hub) Subscribe(storyID string) (<-chan Event, func()) {
-    ch := make(chan Event, 8)
+// lock returns the story's state with its mutex held, creating it on first use.
+func (h *Hub) lock(storyID string) *channel {
+    for {
+        c := h.channel(storyID)
+        c.mu.Lock()
+        if !c.evicted {
+            return c
+        }
+        // Evicted between the lookup and the lock; the next lookup creates a fresh one.
+        c.mu.Unlock()
+    }
+}
+
+// channel returns the story's state, creating it on first use. It also evicts, at most once per
+// channelIdle, every channel that has had no subscribers for that long.
+func (h *Hub) channel(storyID string) *channel {
     h.mu.Lock()
     defer h.mu.Unlock()
-    if _, ok := h.subscribers[storyID]; !ok {
-        h.subscribers[storyID] = make(map[chan Event]struct{})
+    if now := h.now(); now.Sub(h.swept) >= channelIdle {
+        h.sweep(now)
+    }
+    c, ok := h.stories[storyID]
+    if !ok {
+        c = &channel{seq: h.floor, subscribers: make(map[*Subscription]struct{}), lastUsed: h.now()}
+        h.stories[storyID] = c
     }
-    h.subscribers[storyID][ch] = struct{}{}
+    return c
+}
 
-    cancel := func() {
-        h.mu.Lock()
-        defer h.mu.Unlock()
-        if subs, ok := h.subscribers[storyID]; ok {
-            delete(subs, ch)
-            if len(subs) == 0 {
-                delete(h.subscribers, storyID)
-            }
+// sweep drops idle channels. h.mu must be held. A channel whose mutex is taken is in use and
+// stays.
+func (h *Hub) sweep(now time.Time) {
+    h.swept = now
+    for storyID, c := range h.stories {
+        if !c.mu.TryLock() {
+            continue
         }
-        close(ch)
+        if len(c.subscribers) == 0 && now.Sub(c.lastUsed) >= channelIdle {
+            c.evicted = true
+            h.floor = max(h.floor, c.seq)
+            delete(h.stories, storyID)
+        }
+        c.mu.Unlock()
+    }
+}
+
+// ordered returns the buffered events oldest first.
+func (c *channel) ordered() []Event {
+    if len(c.history) < historySize {
+        return c.history
     }
-    return ch, cancel
+    return append(append([]Event(nil), c.history[c.next:]...), c.history[:c.next]...)
 }
 
 // Marshal prepares the event payload for SSE delivery.
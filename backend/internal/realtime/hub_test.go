@@ -0,0 +1,147 @@
+package realtime
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func publishN(h *Hub, storyID string, n int) {
+	for i := 0; i < n; i++ {
+		h.Publish(Event{StoryID: storyID, Type: "test"})
+	}
+}
+
+func eventIDs(events []Event) []uint64 {
+	ids := make([]uint64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestPublishNumbersEventsPerStory(t *testing.T) {
+	h := NewHub()
+	a, cancelA := h.Subscribe("a", SubscribeOptions{})
+	defer cancelA()
+	b, cancelB := h.Subscribe("b", SubscribeOptions{})
+	defer cancelB()
+
+	publishN(h, "a", 3)
+	publishN(h, "b", 2)
+	events, _ := a.Drain()
+	if got := eventIDs(events); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("expected story a to number its events 1 to 3, got %v", got)
+	}
+	events, _ = b.Drain()
+	if got := eventIDs(events); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("expected story b to number its events 1 to 2, got %v", got)
+	}
+}
+
+func TestStreamIDCarriesEpoch(t *testing.T) {
+	h := NewHub()
+	id := h.StreamID(42)
+	if !strings.HasPrefix(id, h.epoch+"-") {
+		t.Fatalf("expected %q to start with the hub epoch %q", id, h.epoch)
+	}
+	if seq, ok := h.parseStreamID(id); !ok || seq != 42 {
+		t.Fatalf("expected %q to parse back to 42, got %d, %v", id, seq, ok)
+	}
+	if _, ok := h.parseStreamID("other" + id); ok {
+		t.Fatalf("expected an ID from another epoch to be rejected")
+	}
+}
+
+func TestResume(t *testing.T) {
+	h := NewHub()
+	publishN(h, "s", historySize+10)
+	latest := uint64(historySize + 10)
+
+	cases := []struct {
+		name        string
+		lastEventID string
+		complete    bool
+		// first is the ID of the first replayed event, 0 when nothing is replayed.
+		first uint64
+	}{
+		{name: "no ID", lastEventID: "", complete: true},
+		{name: "up to date", lastEventID: h.StreamID(latest), complete: true},
+		{name: "oldest buffered", lastEventID: h.StreamID(10), complete: true, first: 11},
+		{name: "recent", lastEventID: h.StreamID(latest - 2), complete: true, first: latest - 1},
+		{name: "rolled out of history", lastEventID: h.StreamID(9), complete: false},
+		{name: "from the future", lastEventID: h.StreamID(latest + 1), complete: false},
+		{name: "earlier epoch", lastEventID: "old-5", complete: false},
+		{name: "malformed", lastEventID: "garbage", complete: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, replay, cancel := h.Resume("s", tc.lastEventID, SubscribeOptions{})
+			defer cancel()
+			if replay.Complete != tc.complete {
+				t.Fatalf("expected complete %v, got %v", tc.complete, replay.Complete)
+			}
+			if replay.LastID != latest {
+				t.Fatalf("expected last ID %d, got %d", latest, replay.LastID)
+			}
+			ids := eventIDs(replay.Events)
+			if tc.first == 0 {
+				if len(ids) != 0 {
+					t.Fatalf("expected no replay, got %v", ids)
+				}
+				return
+			}
+			if len(ids) == 0 || ids[0] != tc.first || ids[len(ids)-1] != latest {
+				t.Fatalf("expected events %d to %d, got %v", tc.first, latest, ids)
+			}
+			for i := 1; i < len(ids); i++ {
+				if ids[i] != ids[i-1]+1 {
+					t.Fatalf("expected consecutive events, got %v", ids)
+				}
+			}
+		})
+	}
+}
+
+func TestHubEvictsIdleChannels(t *testing.T) {
+	h := NewHub()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	publishN(h, "idle", 3)
+	_, cancel := h.Subscribe("watched", SubscribeOptions{})
+	defer cancel()
+	publishN(h, "watched", 1)
+
+	now = now.Add(channelIdle)
+	publishN(h, "other", 1)
+	h.mu.Lock()
+	_, idle := h.stories["idle"]
+	_, watched := h.stories["watched"]
+	h.mu.Unlock()
+	if idle {
+		t.Fatalf("expected the idle story to be evicted")
+	}
+	if !watched {
+		t.Fatalf("expected a story with subscribers to stay")
+	}
+
+	// IDs issued before the eviction must not be taken for later events.
+	_, replay, cancelStale := h.Resume("idle", h.StreamID(2), SubscribeOptions{})
+	cancelStale()
+	if replay.Complete {
+		t.Fatalf("expected a resume from before the eviction to need a resync")
+	}
+	_, replay, cancelLatest := h.Resume("idle", h.StreamID(3), SubscribeOptions{})
+	cancelLatest()
+	if !replay.Complete || len(replay.Events) != 0 {
+		t.Fatalf("expected a client that saw every event to resume cleanly, got %+v", replay)
+	}
+	sub, cancelNext := h.Subscribe("idle", SubscribeOptions{})
+	defer cancelNext()
+	publishN(h, "idle", 1)
+	events, _ := sub.Drain()
+	if ids := eventIDs(events); len(ids) != 1 || ids[0] <= 3 {
+		t.Fatalf("expected numbering to continue past 3, got %v", ids)
+	}
+}
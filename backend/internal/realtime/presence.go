@@ -0,0 +1,223 @@
+package realtime
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/example/multistory/pkg/id"
+)
+
+// PresenceEntry describes one connection to a story: who is on it, the block they are focused
+// on and when the connection last showed signs of life.
+type PresenceEntry struct {
+    SessionID string    `json:"sessionId"`
+    StoryID   string    `json:"storyId"`
+    User      string    `json:"user,omitempty"`
+    BlockID   string    `json:"blockId,omitempty"`
+    JoinedAt  time.Time `json:"joinedAt"`
+    LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Presence tracks the connections open on each story and announces changes through the hub as
+// "presence.joined", "presence.updated" and "presence.left" events.
+type Presence struct {
+    hub *Hub
+    ttl time.Duration
+    now func() time.Time
+
+    mu      sync.Mutex
+    stories map[string]map[string]*PresenceSession
+}
+
+// PresenceSession is one connection's entry. Touch it whenever the connection proves alive;
+// entries not touched within the TTL are dropped.
+type PresenceSession struct {
+    p *Presence
+    // The fields are guarded by p.mu. active is false while the session is not listed, after it
+    // expired or left; left is set once the connection has closed.
+    entry  PresenceEntry
+    active bool
+    left   bool
+}
+
+// defaultPresenceTTL applies when NewPresence is given no TTL.
+const defaultPresenceTTL = 45 * time.Second
+
+// NewPresence tracks presence for the stories of hub. Entries expire ttl after they were last
+// touched; zero means 45 seconds.
+func NewPresence(hub *Hub, ttl time.Duration) *Presence {
+    if ttl <= 0 {
+        ttl = defaultPresenceTTL
+    }
+    return &Presence{
+        hub:     hub,
+        ttl:     ttl,
+        now:     time.Now,
+        stories: make(map[string]map[string]*PresenceSession),
+    }
+}
+
+// Join records a new connection by user to the story and returns its session with a func that
+// removes it. Join takes both as given, so callers check that the story exists and authenticate
+// the user first.
+func (p *Presence) Join(storyID, user string) (*PresenceSession, func()) {
+    now := p.now().UTC()
+    s := &PresenceSession{
+        p: p,
+        entry: PresenceEntry{
+            SessionID: id.New(),
+            StoryID:   storyID,
+            User:      user,
+            JoinedAt:  now,
+            LastSeen:  now,
+        },
+    }
+    p.mu.Lock()
+    entry := p.add(s)
+    p.mu.Unlock()
+    p.publish("presence.joined", entry)
+
+    var once sync.Once
+    return s, func() { once.Do(s.leave) }
+}
+
+// List returns the story's current entries, oldest first.
+func (p *Presence) List(storyID string) []PresenceEntry {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    entries := make([]PresenceEntry, 0, len(p.stories[storyID]))
+    for _, s := range p.stories[storyID] {
+        entries = append(entries, s.entry)
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        if !entries[i].JoinedAt.Equal(entries[j].JoinedAt) {
+            return entries[i].JoinedAt.Before(entries[j].JoinedAt)
+        }
+        return entries[i].SessionID < entries[j].SessionID
+    })
+    return entries
+}
+
+// Run drops expired entries until ctx ends.
+func (p *Presence) Run(ctx context.Context) {
+    ticker := time.NewTicker(p.ttl / 2)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            p.expire()
+        }
+    }
+}
+
+// expire removes the entries last touched more than the TTL ago.
+func (p *Presence) expire() {
+    cutoff := p.now().Add(-p.ttl)
+    var expired []PresenceEntry
+    p.mu.Lock()
+    for _, sessions := range p.stories {
+        for _, s := range sessions {
+            if s.entry.LastSeen.Before(cutoff) {
+                expired = append(expired, p.remove(s))
+            }
+        }
+    }
+    p.mu.Unlock()
+    for _, entry := range expired {
+        p.publish("presence.left", entry)
+    }
+}
+
+// add and remove update the index; the caller holds mu.
+func (p *Presence) add(s *PresenceSession) PresenceEntry {
+    sessions, ok := p.stories[s.entry.StoryID]
+    if !ok {
+        sessions = make(map[string]*PresenceSession)
+        p.stories[s.entry.StoryID] = sessions
+    }
+    sessions[s.entry.SessionID] = s
+    s.active = true
+    return s.entry
+}
+
+func (p *Presence) remove(s *PresenceSession) PresenceEntry {
+    sessions := p.stories[s.entry.StoryID]
+    delete(sessions, s.entry.SessionID)
+    if len(sessions) == 0 {
+        delete(p.stories, s.entry.StoryID)
+    }
+    s.active = false
+    return s.entry
+}
+
+func (p *Presence) publish(eventType string, entry PresenceEntry) {
+    p.hub.Publish(Event{StoryID: entry.StoryID, Type: eventType, Payload: entry})
+}
+
+// Entry returns the session's current state.
+func (s *PresenceSession) Entry() PresenceEntry {
+    s.p.mu.Lock()
+    defer s.p.mu.Unlock()
+    return s.entry
+}
+
+// Touch marks the connection as alive. A session that had expired in the meantime joins again.
+func (s *PresenceSession) Touch() {
+    p := s.p
+    p.mu.Lock()
+    if s.left {
+        p.mu.Unlock()
+        return
+    }
+    s.entry.LastSeen = p.now().UTC()
+    rejoined := !s.active
+    entry := s.entry
+    if rejoined {
+        entry = p.add(s)
+    }
+    p.mu.Unlock()
+    if rejoined {
+        p.publish("presence.joined", entry)
+    }
+}
+
+// Focus records the block the user is on, "" for none, and announces it.
+func (s *PresenceSession) Focus(blockID string) PresenceEntry {
+    p := s.p
+    p.mu.Lock()
+    if s.left {
+        entry := s.entry
+        p.mu.Unlock()
+        return entry
+    }
+    s.entry.BlockID = blockID
+    s.entry.LastSeen = p.now().UTC()
+    eventType := "presence.updated"
+    if !s.active {
+        p.add(s)
+        eventType = "presence.joined"
+    }
+    entry := s.entry
+    p.mu.Unlock()
+    p.publish(eventType, entry)
+    return entry
+}
+
+func (s *PresenceSession) leave() {
+    p := s.p
+    p.mu.Lock()
+    s.left = true
+    wasActive := s.active
+    entry := s.entry
+    if wasActive {
+        entry = p.remove(s)
+    }
+    p.mu.Unlock()
+    if wasActive {
+        p.publish("presence.left", entry)
+    }
+}
@@ -0,0 +1,147 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+)
+
+type presenceFixture struct {
+	presence *Presence
+	events   *Subscription
+	now      time.Time
+}
+
+func newPresenceFixture(t *testing.T) *presenceFixture {
+	t.Helper()
+	hub := NewHub()
+	f := &presenceFixture{
+		presence: NewPresence(hub, time.Minute),
+		now:      time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.presence.now = func() time.Time { return f.now }
+	sub, cancel := hub.Subscribe("s", SubscribeOptions{})
+	t.Cleanup(cancel)
+	f.events = sub
+	return f
+}
+
+// announced returns the types and entries of the presence events published since the last call.
+func (f *presenceFixture) announced() ([]string, []PresenceEntry) {
+	events, _ := f.events.Drain()
+	var (
+		types   []string
+		entries []PresenceEntry
+	)
+	for _, event := range events {
+		types = append(types, event.Type)
+		entries = append(entries, event.Payload.(PresenceEntry))
+	}
+	return types, entries
+}
+
+func (f *presenceFixture) expectAnnounced(t *testing.T, want ...string) []PresenceEntry {
+	t.Helper()
+	types, entries := f.announced()
+	if len(types) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("expected events %v, got %v", want, types)
+		}
+	}
+	return entries
+}
+
+func TestPresenceJoinAndLeave(t *testing.T) {
+	f := newPresenceFixture(t)
+	first, leaveFirst := f.presence.Join("s", "ada")
+	f.now = f.now.Add(time.Second)
+	_, leaveSecond := f.presence.Join("s", "grace")
+	defer leaveSecond()
+
+	entries := f.expectAnnounced(t, "presence.joined", "presence.joined")
+	if entries[0].User != "ada" || entries[0].SessionID != first.Entry().SessionID {
+		t.Fatalf("expected ada's entry first, got %+v", entries[0])
+	}
+	list := f.presence.List("s")
+	if len(list) != 2 || list[0].User != "ada" || list[1].User != "grace" {
+		t.Fatalf("expected ada then grace, got %+v", list)
+	}
+	if other := f.presence.List("other"); len(other) != 0 {
+		t.Fatalf("expected no entries on another story, got %+v", other)
+	}
+
+	// The leave func runs when the connection closes, however often it is called.
+	leaveFirst()
+	leaveFirst()
+	entries = f.expectAnnounced(t, "presence.left")
+	if entries[0].User != "ada" {
+		t.Fatalf("expected ada to leave, got %+v", entries[0])
+	}
+	first.Touch()
+	first.Focus("block-1")
+	f.expectAnnounced(t)
+	if list := f.presence.List("s"); len(list) != 1 || list[0].User != "grace" {
+		t.Fatalf("expected only grace to remain, got %+v", list)
+	}
+}
+
+func TestPresenceFocus(t *testing.T) {
+	f := newPresenceFixture(t)
+	session, leave := f.presence.Join("s", "ada")
+	defer leave()
+	f.announced()
+
+	f.now = f.now.Add(10 * time.Second)
+	entry := session.Focus("block-1")
+	if entry.BlockID != "block-1" || !entry.LastSeen.Equal(f.now) {
+		t.Fatalf("expected the focus to be recorded and refresh the entry, got %+v", entry)
+	}
+	entries := f.expectAnnounced(t, "presence.updated")
+	if entries[0].BlockID != "block-1" {
+		t.Fatalf("expected the update to carry the block, got %+v", entries[0])
+	}
+
+	session.Focus("")
+	if entries := f.expectAnnounced(t, "presence.updated"); entries[0].BlockID != "" {
+		t.Fatalf("expected the focus to be cleared, got %+v", entries[0])
+	}
+}
+
+func TestPresenceExpiry(t *testing.T) {
+	f := newPresenceFixture(t)
+	stale, leaveStale := f.presence.Join("s", "ada")
+	defer leaveStale()
+	fresh, leaveFresh := f.presence.Join("s", "grace")
+	defer leaveFresh()
+	f.announced()
+
+	f.now = f.now.Add(40 * time.Second)
+	fresh.Touch()
+	f.now = f.now.Add(30 * time.Second)
+	f.presence.expire()
+	entries := f.expectAnnounced(t, "presence.left")
+	if entries[0].User != "ada" {
+		t.Fatalf("expected the untouched entry to expire, got %+v", entries[0])
+	}
+	if list := f.presence.List("s"); len(list) != 1 || list[0].User != "grace" {
+		t.Fatalf("expected the touched entry to stay, got %+v", list)
+	}
+
+	// A connection that turns out to be alive after all joins again.
+	stale.Touch()
+	entries = f.expectAnnounced(t, "presence.joined")
+	if entries[0].SessionID != stale.Entry().SessionID {
+		t.Fatalf("expected the expired session to rejoin, got %+v", entries[0])
+	}
+
+	// Focusing also brings an expired session back.
+	f.now = f.now.Add(2 * time.Minute)
+	f.presence.expire()
+	f.expectAnnounced(t, "presence.left", "presence.left")
+	fresh.Focus("block-2")
+	if entries := f.expectAnnounced(t, "presence.joined"); entries[0].BlockID != "block-2" {
+		t.Fatalf("expected the rejoined entry to carry its focus, got %+v", entries[0])
+	}
+}
@@ -0,0 +1,191 @@
+package realtime
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+// OverflowPolicy says what happens when a subscriber's queue is full and another event arrives.
+type OverflowPolicy string
+
+const (
+    // OverflowDropOldest discards the oldest queued event to make room.
+    OverflowDropOldest OverflowPolicy = "drop-oldest"
+    // OverflowDisconnect ends the subscription. A client that resumes from its last event ID
+    // gets the rest from the story's history.
+    OverflowDisconnect OverflowPolicy = "disconnect"
+    // OverflowBlock makes the publisher wait up to SubscribeOptions.BlockTimeout for room, then
+    // falls back to dropping the oldest event. Since it slows down every publisher to the story,
+    // only the server configuration may select it.
+    OverflowBlock OverflowPolicy = "block"
+)
+
+// defaultBlockTimeout applies to OverflowBlock subscribers that do not set one.
+const defaultBlockTimeout = time.Second
+
+// ParseOverflowPolicy validates a policy name; "" selects OverflowDropOldest.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+    switch policy := OverflowPolicy(s); policy {
+    case "":
+        return OverflowDropOldest, nil
+    case OverflowDropOldest, OverflowDisconnect, OverflowBlock:
+        return policy, nil
+    }
+    return "", fmt.Errorf("realtime: overflow policy must be %s, %s or %s", OverflowDropOldest, OverflowDisconnect, OverflowBlock)
+}
+
+// SubscribeOptions tune how a subscriber copes with falling behind.
+type SubscribeOptions struct {
+    Overflow OverflowPolicy
+    // BlockTimeout bounds how long Publish waits on an OverflowBlock subscriber. Zero means one
+    // second.
+    BlockTimeout time.Duration
+}
+
+// Gap is the payload of the "stream.gap" event that takes the place of dropped events. A client
+// receiving it has missed updates and should refetch the story.
+type Gap struct {
+    // Dropped counts the events missed at this point in the stream.
+    Dropped uint64 `json:"dropped"`
+    // Total counts every event this subscriber has missed.
+    Total uint64 `json:"total"`
+    // Disconnected is set when the subscription ended because it fell behind.
+    Disconnected bool `json:"disconnected,omitempty"`
+}
+
+// Subscription is one listener's queue of a story's events.
+type Subscription struct {
+    storyID string
+    policy  OverflowPolicy
+    timeout time.Duration
+    // ready is signalled when events are queued or the subscription closes; space when the
+    // reader drains the queue.
+    ready chan struct{}
+    space chan struct{}
+
+    mu    sync.Mutex
+    queue []Event
+    // gap counts events dropped since the reader was last told, total all of them.
+    gap, total uint64
+    closed     bool
+    overflowed bool
+}
+
+func newSubscription(storyID string, opts SubscribeOptions) *Subscription {
+    policy := opts.Overflow
+    if policy == "" {
+        policy = OverflowDropOldest
+    }
+    timeout := opts.BlockTimeout
+    if timeout <= 0 {
+        timeout = defaultBlockTimeout
+    }
+    return &Subscription{
+        storyID: storyID,
+        policy:  policy,
+        timeout: timeout,
+        ready:   make(chan struct{}, 1),
+        space:   make(chan struct{}, 1),
+        queue:   make([]Event, 0, subscriberBuffer),
+    }
+}
+
+// Ready is signalled whenever Drain has something to return.
+func (s *Subscription) Ready() <-chan struct{} {
+    return s.ready
+}
+
+// Drain returns the queued events with a "stream.gap" event where any were dropped since the last
+// call: before the queue, whose oldest events made way, or after it when the subscription was
+// disconnected. It reports false once the subscription has ended; the events returned with false
+// are the last ones.
+func (s *Subscription) Drain() ([]Event, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    events := make([]Event, 0, len(s.queue)+1)
+    gap := Event{
+        StoryID: s.storyID,
+        Type:    "stream.gap",
+        Payload: Gap{Dropped: s.gap, Total: s.total, Disconnected: s.overflowed},
+    }
+    if s.gap > 0 && !s.overflowed {
+        events = append(events, gap)
+    }
+    events = append(events, s.queue...)
+    if s.gap > 0 && s.overflowed {
+        events = append(events, gap)
+    }
+    s.gap = 0
+    s.queue = s.queue[:0]
+    signal(s.space)
+    return events, !s.closed
+}
+
+// Dropped returns how many events the subscriber has missed.
+func (s *Subscription) Dropped() uint64 {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.total
+}
+
+// push queues event, applying the overflow policy when the queue is full.
+func (s *Subscription) push(event Event) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.policy == OverflowBlock {
+        s.waitForSpace()
+    }
+    if s.closed {
+        return
+    }
+    if len(s.queue) >= subscriberBuffer {
+        s.gap++
+        s.total++
+        if s.policy == OverflowDisconnect {
+            s.closed = true
+            s.overflowed = true
+            signal(s.ready)
+            return
+        }
+        s.queue = append(s.queue[:0], s.queue[1:]...)
+    }
+    s.queue = append(s.queue, event)
+    signal(s.ready)
+}
+
+// waitForSpace releases mu until the reader drains the queue, the subscription closes or the
+// block timeout passes.
+func (s *Subscription) waitForSpace() {
+    if s.closed || len(s.queue) < subscriberBuffer {
+        return
+    }
+    deadline := time.NewTimer(s.timeout)
+    defer deadline.Stop()
+    for !s.closed && len(s.queue) >= subscriberBuffer {
+        s.mu.Unlock()
+        select {
+        case <-s.space:
+        case <-deadline.C:
+            s.mu.Lock()
+            return
+        }
+        s.mu.Lock()
+    }
+}
+
+func (s *Subscription) close() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.closed = true
+    signal(s.ready)
+    signal(s.space)
+}
+
+// signal wakes a waiter on ch without blocking when one is already pending.
+func signal(ch chan struct{}) {
+    select {
+    case ch <- struct{}{}:
+    default:
+    }
+}
@@ -0,0 +1,230 @@
+package realtime
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fill queues n events on sub, numbered from 1.
+func fill(sub *Subscription, n int) {
+	for i := 1; i <= n; i++ {
+		sub.push(Event{ID: uint64(i), StoryID: sub.storyID, Type: "test"})
+	}
+}
+
+func gapOf(t *testing.T, event Event) Gap {
+	t.Helper()
+	if event.Type != "stream.gap" {
+		t.Fatalf("expected a stream.gap event, got %s", event.Type)
+	}
+	gap, ok := event.Payload.(Gap)
+	if !ok {
+		t.Fatalf("expected a Gap payload, got %T", event.Payload)
+	}
+	return gap
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	cases := map[string]OverflowPolicy{
+		"":            OverflowDropOldest,
+		"drop-oldest": OverflowDropOldest,
+		"disconnect":  OverflowDisconnect,
+		"block":       OverflowBlock,
+	}
+	for in, want := range cases {
+		if got, err := ParseOverflowPolicy(in); err != nil || got != want {
+			t.Fatalf("expected %q to parse as %s, got %s, %v", in, want, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop-newest"); err == nil {
+		t.Fatalf("expected an unknown policy to be rejected")
+	}
+}
+
+func TestDropOldestReportsGap(t *testing.T) {
+	sub := newSubscription("s", SubscribeOptions{Overflow: OverflowDropOldest})
+	fill(sub, subscriberBuffer+3)
+
+	events, open := sub.Drain()
+	if !open {
+		t.Fatalf("expected the subscription to stay open")
+	}
+	if len(events) != subscriberBuffer+1 {
+		t.Fatalf("expected a gap and %d events, got %d events", subscriberBuffer, len(events))
+	}
+	if gap := gapOf(t, events[0]); gap.Dropped != 3 || gap.Total != 3 || gap.Disconnected {
+		t.Fatalf("expected a gap of 3, got %+v", gap)
+	}
+	if events[1].ID != 4 || events[len(events)-1].ID != subscriberBuffer+3 {
+		t.Fatalf("expected events 4 to %d, got %d to %d", subscriberBuffer+3, events[1].ID, events[len(events)-1].ID)
+	}
+
+	// The next overflow reports its own count and the running total.
+	fill(sub, subscriberBuffer+2)
+	events, _ = sub.Drain()
+	if gap := gapOf(t, events[0]); gap.Dropped != 2 || gap.Total != 5 {
+		t.Fatalf("expected a gap of 2 out of 5, got %+v", gap)
+	}
+	if sub.Dropped() != 5 {
+		t.Fatalf("expected 5 dropped events, got %d", sub.Dropped())
+	}
+
+	fill(sub, 1)
+	events, _ = sub.Drain()
+	if len(events) != 1 || events[0].Type == "stream.gap" {
+		t.Fatalf("expected no gap once the subscriber keeps up, got %+v", events)
+	}
+}
+
+func TestDisconnectEndsSubscription(t *testing.T) {
+	sub := newSubscription("s", SubscribeOptions{Overflow: OverflowDisconnect})
+	fill(sub, subscriberBuffer+1)
+	fill(sub, 1)
+
+	events, open := sub.Drain()
+	if open {
+		t.Fatalf("expected the subscription to end")
+	}
+	if len(events) != subscriberBuffer+1 {
+		t.Fatalf("expected %d events and a gap, got %d events", subscriberBuffer, len(events))
+	}
+	if events[subscriberBuffer-1].ID != subscriberBuffer {
+		t.Fatalf("expected the queued events to be kept, got last ID %d", events[subscriberBuffer-1].ID)
+	}
+	if gap := gapOf(t, events[subscriberBuffer]); gap.Dropped != 1 || gap.Total != 1 || !gap.Disconnected {
+		t.Fatalf("expected a trailing disconnect gap of 1, got %+v", gap)
+	}
+	if sub.Dropped() != 1 {
+		t.Fatalf("expected events after the disconnect not to count, got %d dropped", sub.Dropped())
+	}
+}
+
+func TestBlockWaitsForReader(t *testing.T) {
+	sub := newSubscription("s", SubscribeOptions{Overflow: OverflowBlock, BlockTimeout: 5 * time.Second})
+	fill(sub, subscriberBuffer)
+
+	pushed := make(chan struct{})
+	go func() {
+		sub.push(Event{ID: subscriberBuffer + 1, Type: "test"})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatalf("expected push to wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if events, _ := sub.Drain(); len(events) != subscriberBuffer {
+		t.Fatalf("expected %d events, got %d", subscriberBuffer, len(events))
+	}
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected push to finish once the reader drained")
+	}
+	events, _ := sub.Drain()
+	if len(events) != 1 || events[0].ID != subscriberBuffer+1 {
+		t.Fatalf("expected the waiting event without a gap, got %+v", events)
+	}
+}
+
+func TestBlockFallsBackToDropping(t *testing.T) {
+	sub := newSubscription("s", SubscribeOptions{Overflow: OverflowBlock, BlockTimeout: 20 * time.Millisecond})
+	fill(sub, subscriberBuffer+1)
+
+	events, open := sub.Drain()
+	if !open {
+		t.Fatalf("expected the subscription to stay open")
+	}
+	if gap := gapOf(t, events[0]); gap.Dropped != 1 || gap.Total != 1 {
+		t.Fatalf("expected a gap of 1 after the timeout, got %+v", gap)
+	}
+}
+
+func TestCloseReleasesBlockedPublisher(t *testing.T) {
+	h := NewHub()
+	sub, cancel := h.Subscribe("s", SubscribeOptions{Overflow: OverflowBlock, BlockTimeout: time.Minute})
+	publishN(h, "s", subscriberBuffer)
+
+	published := make(chan struct{})
+	go func() {
+		publishN(h, "s", 1)
+		close(published)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected cancel to release the blocked publisher")
+	}
+	if _, open := sub.Drain(); open {
+		t.Fatalf("expected the subscription to be closed")
+	}
+}
+
+func TestBlockedPublisherDoesNotHoldSubscribers(t *testing.T) {
+	h := NewHub()
+	slow, cancelSlow := h.Subscribe("s", SubscribeOptions{Overflow: OverflowBlock, BlockTimeout: time.Minute})
+	defer cancelSlow()
+	publishN(h, "s", subscriberBuffer)
+
+	go publishN(h, "s", 1)
+	time.Sleep(20 * time.Millisecond)
+
+	joined := make(chan struct{})
+	go func() {
+		_, replay, cancel := h.Resume("s", "", SubscribeOptions{})
+		cancel()
+		if replay.LastID != subscriberBuffer+1 {
+			t.Errorf("expected the blocked event to be numbered already, got last ID %d", replay.LastID)
+		}
+		close(joined)
+	}()
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected subscribers to come and go while a publisher waits")
+	}
+	slow.Drain()
+}
+
+func TestConcurrentPublishersKeepOrder(t *testing.T) {
+	h := NewHub()
+	sub, cancel := h.Subscribe("s", SubscribeOptions{Overflow: OverflowBlock, BlockTimeout: time.Minute})
+	defer cancel()
+
+	const publishers, each = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publishN(h, "s", each)
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	var last uint64
+	for received := 0; received < publishers*each; {
+		select {
+		case <-sub.Ready():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d events", received)
+		}
+		events, _ := sub.Drain()
+		for _, event := range events {
+			if event.ID != last+1 {
+				t.Fatalf("expected event %d, got %d", last+1, event.ID)
+			}
+			last = event.ID
+			received++
+		}
+	}
+	<-finished
+}
@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expression is a parsed five-field cron expression: minute, hour, day of month, month and day of
+// week. Each field is a bitset of the values it matches.
+type Expression struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record a field starting with "*". As in classic cron, when both day
+	// fields are restricted a day matching either of them is enough.
+	domStar, dowStar bool
+	// hourStar records an hour field starting with "*"; such expressions also match in the hour
+	// that repeats when clocks go back.
+	hourStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week accepts 7 as a second spelling of Sunday.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a cron expression. Fields accept "*", single values, ranges ("1-5"), lists
+// ("1,15"), steps ("*/10", "8-18/2") and three-letter month and weekday names. The macros
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are also understood.
+func Parse(spec string) (*Expression, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := macros[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown macro %q", spec)
+		}
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d", len(fields))
+	}
+	var (
+		expr Expression
+		err  error
+	)
+	if expr.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if expr.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if expr.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if expr.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if expr.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if expr.dow&(1<<7) != 0 {
+		expr.dow |= 1
+	}
+	expr.hourStar = strings.HasPrefix(fields[1], "*")
+	expr.domStar = strings.HasPrefix(fields[2], "*")
+	expr.dowStar = strings.HasPrefix(fields[4], "*")
+	return &expr, nil
+}
+
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		lo, hi, step := f.min, f.max, 1
+		rangePart := part
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: invalid step %q in %s field", part[i+1:], f.name)
+			}
+			step = n
+			rangePart = part[:i]
+		}
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("cron: range %q in %s field runs backwards", rangePart, f.name)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			// "5/15" means every 15 starting at 5; a bare value matches only itself.
+			if step == 1 {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q in %s field", s, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("cron: value %d out of range %d-%d in %s field", v, f.min, f.max, f.name)
+	}
+	return v, nil
+}
+
+// searchYears bounds Next so expressions that can never match, such as "0 0 30 2 *", terminate.
+const searchYears = 5
+
+// Next returns the first time strictly after after that matches the expression, evaluated in
+// after's location, or the zero time if nothing matches within the next few years. Times skipped
+// when clocks go forward are made up as soon as the clock resumes. Times repeated when clocks go
+// back match only the first time round, unless the hour field starts with "*", so a daily
+// expression matches once while an hourly one keeps matching every hour.
+func (e *Expression) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Year() + searchYears
+	for t.Year() <= limit {
+		if e.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !e.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if e.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			// time.Date resolves an hour missing to DST to one before the gap; step by absolute
+			// time instead.
+			if !next.After(t) {
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			if next.Day() == t.Day() && e.skippedHour(t.Hour()+1, next.Hour()) {
+				// The gap swallowed a matching hour; run as soon as the clock resumes.
+				return next
+			}
+			t = next
+			continue
+		}
+		if e.minute&(1<<uint(t.Minute())) == 0 || (!e.hourStar && repeatedWallTime(t)) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// repeatedWallTime reports whether the wall-clock time of t already occurred earlier that day,
+// because the clocks went back in between.
+func repeatedWallTime(t time.Time) bool {
+	_, offset := t.Zone()
+	// Compare with the offset before any clock change of up to three hours.
+	_, before := t.Add(-3 * time.Hour).Zone()
+	if before <= offset {
+		return false
+	}
+	_, earlier := t.Add(-time.Duration(before-offset) * time.Second).Zone()
+	return earlier == before
+}
+
+// skippedHour reports whether any hour in [from, to) matches.
+func (e *Expression) skippedHour(from, to int) bool {
+	for h := from; h < to; h++ {
+		if e.hour&(1<<uint(h)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Expression) dayMatches(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+	if e.domStar || e.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func bits(values ...int) uint64 {
+	var b uint64
+	for _, v := range values {
+		b |= 1 << uint(v)
+	}
+	return b
+}
+
+func span(lo, hi, step int) uint64 {
+	var b uint64
+	for v := lo; v <= hi; v += step {
+		b |= 1 << uint(v)
+	}
+	return b
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		spec string
+		want Expression
+	}{
+		{
+			spec: "* * * * *",
+			want: Expression{minute: span(0, 59, 1), hour: span(0, 23, 1), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), hourStar: true, domStar: true, dowStar: true},
+		},
+		{
+			spec: "*/15 8-18/2 1,15 * *",
+			want: Expression{minute: bits(0, 15, 30, 45), hour: bits(8, 10, 12, 14, 16, 18), dom: bits(1, 15), month: span(1, 12, 1), dow: span(0, 7, 1), dowStar: true},
+		},
+		{
+			spec: "5/20 0 * * *",
+			want: Expression{minute: bits(5, 25, 45), hour: bits(0), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), domStar: true, dowStar: true},
+		},
+		{
+			spec: "0 9 * JAN-mar mon-FRI",
+			want: Expression{minute: bits(0), hour: bits(9), dom: span(1, 31, 1), month: bits(1, 2, 3), dow: bits(1, 2, 3, 4, 5), domStar: true},
+		},
+		{
+			spec: "0 0 * * 7",
+			want: Expression{minute: bits(0), hour: bits(0), dom: span(1, 31, 1), month: span(1, 12, 1), dow: bits(0, 7), domStar: true},
+		},
+		{
+			spec: "0 0 * * sun,sat",
+			want: Expression{minute: bits(0), hour: bits(0), dom: span(1, 31, 1), month: span(1, 12, 1), dow: bits(0, 6), domStar: true},
+		},
+		{
+			spec: "  @Hourly ",
+			want: Expression{minute: bits(0), hour: span(0, 23, 1), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), hourStar: true, domStar: true, dowStar: true},
+		},
+		{
+			spec: "@weekly",
+			want: Expression{minute: bits(0), hour: bits(0), dom: span(1, 31, 1), month: span(1, 12, 1), dow: bits(0), domStar: true},
+		},
+		{
+			spec: "@yearly",
+			want: Expression{minute: bits(0), hour: bits(0), dom: bits(1), month: bits(1), dow: span(0, 7, 1), dowStar: true},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			got, err := Parse(tc.spec)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if *got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, *got)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"1,,2 * * * *",
+		"@fortnightly",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := Parse(spec); err == nil {
+				t.Fatalf("expected %q to be rejected", spec)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		return v
+	}
+	cases := []struct {
+		name  string
+		spec  string
+		loc   *time.Location
+		after string
+		// want is empty when nothing should match.
+		want string
+	}{
+		{name: "next minute", spec: "* * * * *", loc: time.UTC, after: "2024-01-01T10:00:30Z", want: "2024-01-01T10:01:00Z"},
+		{name: "strictly after", spec: "0 0 * * *", loc: time.UTC, after: "2024-01-01T00:00:00Z", want: "2024-01-02T00:00:00Z"},
+		{name: "month rollover", spec: "0 0 1 * *", loc: time.UTC, after: "2024-12-15T00:00:00Z", want: "2025-01-01T00:00:00Z"},
+		{name: "names", spec: "0 9 * jan-mar mon-fri", loc: time.UTC, after: "2024-03-29T10:00:00Z", want: "2025-01-01T09:00:00Z"},
+		{name: "weekday only", spec: "0 0 * * 1", loc: time.UTC, after: "2024-09-01T00:00:00Z", want: "2024-09-02T00:00:00Z"},
+		{name: "day of month only", spec: "0 0 13 * *", loc: time.UTC, after: "2024-10-01T00:00:00Z", want: "2024-10-13T00:00:00Z"},
+		{name: "either day field picks weekday", spec: "0 0 13 * 5", loc: time.UTC, after: "2024-10-05T00:00:00Z", want: "2024-10-11T00:00:00Z"},
+		{name: "either day field picks date", spec: "0 0 13 * 5", loc: time.UTC, after: "2024-10-12T00:00:00Z", want: "2024-10-13T00:00:00Z"},
+		{name: "seven is sunday", spec: "0 0 * * 7", loc: time.UTC, after: "2024-09-02T00:00:00Z", want: "2024-09-08T00:00:00Z"},
+		{name: "leap day", spec: "0 0 29 2 *", loc: time.UTC, after: "2024-03-01T00:00:00Z", want: "2028-02-29T00:00:00Z"},
+		{name: "never matches", spec: "0 0 30 2 *", loc: time.UTC, after: "2024-01-01T00:00:00Z"},
+		{name: "local midnight", spec: "@daily", loc: newYork, after: "2024-07-01T12:00:00Z", want: "2024-07-02T04:00:00Z"},
+
+		// Clocks in New York go from 02:00 EST to 03:00 EDT on 2024-03-10.
+		{name: "spring forward skipped time runs at resume", spec: "30 2 * * *", loc: newYork, after: "2024-03-10T05:00:00Z", want: "2024-03-10T07:00:00Z"},
+		{name: "spring forward next day", spec: "30 2 * * *", loc: newYork, after: "2024-03-10T07:00:00Z", want: "2024-03-11T06:30:00Z"},
+		{name: "spring forward hourly", spec: "0 * * * *", loc: newYork, after: "2024-03-10T06:00:00Z", want: "2024-03-10T07:00:00Z"},
+		{name: "spring forward interval skips the gap", spec: "30 * * * *", loc: newYork, after: "2024-03-10T06:30:00Z", want: "2024-03-10T07:30:00Z"},
+
+		// Clocks in New York go from 02:00 EDT back to 01:00 EST on 2024-11-03.
+		{name: "fall back first occurrence", spec: "30 1 * * *", loc: newYork, after: "2024-11-03T04:00:00Z", want: "2024-11-03T05:30:00Z"},
+		{name: "fall back daily fires once", spec: "30 1 * * *", loc: newYork, after: "2024-11-03T05:30:00Z", want: "2024-11-04T06:30:00Z"},
+		{name: "fall back hour list fires once", spec: "0 1,3 * * *", loc: newYork, after: "2024-11-03T05:00:00Z", want: "2024-11-03T08:00:00Z"},
+		{name: "fall back hourly keeps every hour", spec: "0 * * * *", loc: newYork, after: "2024-11-03T05:00:00Z", want: "2024-11-03T06:00:00Z"},
+		{name: "fall back interval keeps running", spec: "*/30 * * * *", loc: newYork, after: "2024-11-03T05:30:00Z", want: "2024-11-03T06:00:00Z"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := Parse(tc.spec)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got := expr.Next(utc(tc.after).In(tc.loc))
+			if tc.want == "" {
+				if !got.IsZero() {
+					t.Fatalf("expected no match, got %s", got)
+				}
+				return
+			}
+			if want := utc(tc.want); !got.Equal(want) {
+				t.Fatalf("expected %s, got %s", want.In(tc.loc), got)
+			}
+		})
+	}
+}
+
+func TestNextFallBackDailyFiresOncePerDay(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	expr, err := Parse("15 1 * * *")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	runs := 0
+	for at := time.Date(2024, 11, 2, 12, 0, 0, 0, newYork); ; runs++ {
+		at = expr.Next(at)
+		if at.After(time.Date(2024, 11, 4, 12, 0, 0, 0, newYork)) {
+			break
+		}
+	}
+	if runs != 2 {
+		t.Fatalf("expected one run on each of two days, got %d", runs)
+	}
+}
@@ -0,0 +1,348 @@
+// Package scheduler runs stories on cron schedules. Schedules live in the story repository so they
+// survive restarts; a single loop sleeps until the earliest one is due and queues its execution.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/example/multistory/internal/jobs"
+	"github.com/example/multistory/internal/realtime"
+	"github.com/example/multistory/internal/story"
+	"github.com/example/multistory/pkg/id"
+)
+
+// Actor is recorded as the author of executions the scheduler starts.
+const Actor = "system:scheduler"
+
+// ErrInvalidSchedule is returned when a cron expression or timezone cannot be parsed.
+var ErrInvalidSchedule = errors.New("scheduler: invalid schedule")
+
+// CreateInput describes a new schedule. Enabled defaults to true.
+type CreateInput struct {
+	Cron     string
+	Timezone string
+	Enabled  *bool
+	Actor    string
+}
+
+// UpdateInput changes the fields that are set and leaves the rest alone.
+type UpdateInput struct {
+	Cron     *string
+	Timezone *string
+	Enabled  *bool
+}
+
+// Trigger is published as a "schedule.triggered" event each time a schedule fires.
+type Trigger struct {
+	Schedule story.Schedule `json:"schedule"`
+	// Job is the queued execution; it is nil when the execution could not be queued.
+	Job   *jobs.Job `json:"job,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Scheduler owns the schedule CRUD operations and the loop that fires them.
+type Scheduler struct {
+	repo story.Repository
+	jobs *jobs.Manager
+	hub  *realtime.Hub
+	now  func() time.Time
+	// wake nudges the loop to recompute its timer after a schedule changes.
+	wake chan struct{}
+	// mu serialises schedule writes so the loop and API calls do not overwrite each other.
+	mu sync.Mutex
+	// unsaved holds, by ID, schedules the loop changed but could not store. They stand in for the
+	// stored copy until a save succeeds, so a schedule that already fired is not fired again.
+	unsaved map[string]story.Schedule
+}
+
+// saveRetry is how soon the loop retries storing schedules after a failed save.
+const saveRetry = time.Minute
+
+// New returns a Scheduler that stores schedules in repo and queues their runs on executions.
+func New(repo story.Repository, executions *jobs.Manager, hub *realtime.Hub) *Scheduler {
+	return &Scheduler{
+		repo:    repo,
+		jobs:    executions,
+		hub:     hub,
+		now:     func() time.Time { return time.Now().UTC() },
+		wake:    make(chan struct{}, 1),
+		unsaved: make(map[string]story.Schedule),
+	}
+}
+
+// Run fires due schedules until ctx is cancelled. A schedule whose run was missed while the
+// server was down fires once on startup rather than once per missed slot.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next, err := s.fireDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("scheduler: %v", err)
+			// Retry shortly rather than sleeping until a wake that may never come.
+			next = s.now().Add(time.Minute)
+		}
+		var (
+			timer *time.Timer
+			fired <-chan time.Time
+		)
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(s.now()))
+			fired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-fired:
+		case <-s.wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// fireDue triggers every enabled schedule whose next run has passed and returns when the earliest
+// remaining one is due, or the zero time if none is. Schedules that cannot be stored are kept in
+// memory and retried within saveRetry.
+func (s *Scheduler) fireDue(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules, err := s.repo.ListSchedules(ctx, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+	var earliest time.Time
+	listed := make(map[string]bool, len(schedules))
+	for _, schedule := range schedules {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		listed[schedule.ID] = true
+		now := s.now()
+		changed := false
+		if pending, ok := s.unsaved[schedule.ID]; ok {
+			schedule = pending
+			changed = true
+		}
+		if !schedule.Enabled {
+			continue
+		}
+		if schedule.NextRunAt != nil && !schedule.NextRunAt.After(now) {
+			s.fire(ctx, &schedule, now)
+			changed = true
+		} else if schedule.NextRunAt == nil {
+			if err := s.plan(&schedule, now); err != nil {
+				log.Printf("scheduler: schedule %s: %v", schedule.ID, err)
+				continue
+			}
+			changed = schedule.NextRunAt != nil
+		}
+		next := schedule.NextRunAt
+		if changed {
+			if err := s.repo.SaveSchedule(ctx, schedule); err != nil {
+				log.Printf("scheduler: save schedule %s: %v", schedule.ID, err)
+				s.unsaved[schedule.ID] = schedule
+				retry := now.Add(saveRetry)
+				if next == nil || retry.Before(*next) {
+					next = &retry
+				}
+			} else {
+				delete(s.unsaved, schedule.ID)
+			}
+		}
+		if next != nil && (earliest.IsZero() || next.Before(earliest)) {
+			earliest = *next
+		}
+	}
+	// Forget schedules deleted along with their story.
+	for scheduleID := range s.unsaved {
+		if !listed[scheduleID] {
+			delete(s.unsaved, scheduleID)
+		}
+	}
+	return earliest, nil
+}
+
+// fire queues an execution for the schedule and records the outcome on it. A run that cannot be
+// queued is still written to the story's execution history as failed.
+func (s *Scheduler) fire(ctx context.Context, schedule *story.Schedule, now time.Time) {
+	trigger := Trigger{}
+	job, err := s.jobs.Submit(ctx, schedule.StoryID, story.ExecuteOptions{Actor: Actor})
+	if err != nil {
+		if errors.Is(err, jobs.ErrClosed) || ctx.Err() != nil {
+			return
+		}
+		trigger.Error = err.Error()
+		record := story.Execution{
+			ID:         id.New(),
+			StoryID:    schedule.StoryID,
+			Actor:      Actor,
+			Status:     "failed",
+			StartedAt:  now,
+			FinishedAt: now,
+			Error:      fmt.Sprintf("scheduled run could not be queued: %v", err),
+		}
+		if err := s.repo.AppendExecution(ctx, record); err != nil {
+			log.Printf("scheduler: record failed run of schedule %s: %v", schedule.ID, err)
+		}
+	} else {
+		trigger.Job = &job
+		schedule.LastJobID = job.ID
+	}
+	schedule.LastRunAt = &now
+	schedule.NextRunAt = nil
+	if err := s.plan(schedule, now); err != nil {
+		log.Printf("scheduler: schedule %s: %v", schedule.ID, err)
+	}
+	trigger.Schedule = *schedule
+	s.hub.Publish(realtime.Event{StoryID: schedule.StoryID, Type: "schedule.triggered", Payload: trigger})
+}
+
+// plan sets NextRunAt to the first match after now, or clears it for a disabled schedule.
+func (s *Scheduler) plan(schedule *story.Schedule, now time.Time) error {
+	schedule.NextRunAt = nil
+	if !schedule.Enabled {
+		return nil
+	}
+	expr, loc, err := parse(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		return err
+	}
+	next := expr.Next(now.In(loc))
+	if next.IsZero() {
+		return nil
+	}
+	next = next.UTC()
+	schedule.NextRunAt = &next
+	return nil
+}
+
+func parse(cron, timezone string) (*Expression, *time.Location, error) {
+	expr, err := Parse(cron)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	loc := time.UTC
+	if timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, timezone)
+		}
+	}
+	return expr, loc, nil
+}
+
+// List returns the story's schedules, oldest first.
+func (s *Scheduler) List(ctx context.Context, storyID string) ([]story.Schedule, error) {
+	if _, err := s.repo.Get(ctx, storyID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListSchedules(ctx, storyID)
+}
+
+// Get returns a single schedule.
+func (s *Scheduler) Get(ctx context.Context, scheduleID string) (story.Schedule, error) {
+	return s.repo.GetSchedule(ctx, scheduleID)
+}
+
+// Create validates and stores a new schedule for the story.
+func (s *Scheduler) Create(ctx context.Context, storyID string, input CreateInput) (story.Schedule, error) {
+	now := s.now()
+	schedule := story.Schedule{
+		ID:        id.New(),
+		StoryID:   storyID,
+		Cron:      input.Cron,
+		Timezone:  input.Timezone,
+		Enabled:   input.Enabled == nil || *input.Enabled,
+		CreatedBy: orDefault(input.Actor, "anonymous"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(ctx, &schedule, now); err != nil {
+		return story.Schedule{}, err
+	}
+	s.hub.Publish(realtime.Event{StoryID: storyID, Type: "schedule.created", Payload: schedule})
+	return schedule, nil
+}
+
+// Update applies input to an existing schedule and recomputes its next run.
+func (s *Scheduler) Update(ctx context.Context, scheduleID string, input UpdateInput) (story.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule, err := s.repo.GetSchedule(ctx, scheduleID)
+	if err != nil {
+		return story.Schedule{}, err
+	}
+	if pending, ok := s.unsaved[scheduleID]; ok {
+		schedule = pending
+	}
+	if input.Cron != nil {
+		schedule.Cron = *input.Cron
+	}
+	if input.Timezone != nil {
+		schedule.Timezone = *input.Timezone
+	}
+	if input.Enabled != nil {
+		schedule.Enabled = *input.Enabled
+	}
+	now := s.now()
+	schedule.UpdatedAt = now
+	if err := s.save(ctx, &schedule, now); err != nil {
+		return story.Schedule{}, err
+	}
+	delete(s.unsaved, scheduleID)
+	s.hub.Publish(realtime.Event{StoryID: schedule.StoryID, Type: "schedule.updated", Payload: schedule})
+	return schedule, nil
+}
+
+// Delete removes a schedule; runs it already queued are unaffected.
+func (s *Scheduler) Delete(ctx context.Context, scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule, err := s.repo.GetSchedule(ctx, scheduleID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteSchedule(ctx, scheduleID); err != nil {
+		return err
+	}
+	delete(s.unsaved, scheduleID)
+	s.hub.Publish(realtime.Event{StoryID: schedule.StoryID, Type: "schedule.deleted", Payload: schedule})
+	return nil
+}
+
+// save validates the schedule, plans its next run, stores it and wakes the loop. Callers hold mu.
+func (s *Scheduler) save(ctx context.Context, schedule *story.Schedule, now time.Time) error {
+	expr, loc, err := parse(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		return err
+	}
+	if expr.Next(now.In(loc)).IsZero() {
+		return fmt.Errorf("%w: %q never matches", ErrInvalidSchedule, schedule.Cron)
+	}
+	if err := s.plan(schedule, now); err != nil {
+		return err
+	}
+	if err := s.repo.SaveSchedule(ctx, *schedule); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
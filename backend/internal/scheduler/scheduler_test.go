@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/multistory/internal/jobs"
+	"github.com/example/multistory/internal/realtime"
+	"github.com/example/multistory/internal/story"
+)
+
+type noopRunner struct{}
+
+func (noopRunner) Execute(_ context.Context, req story.ExecutionRequest) (story.ExecutionResult, error) {
+	return story.ExecutionResult{StoryID: req.Story.ID, Status: "completed"}, nil
+}
+
+// flakyRepository fails SaveSchedule while failSaves is set.
+type flakyRepository struct {
+	story.Repository
+
+	mu        sync.Mutex
+	failSaves bool
+}
+
+func (r *flakyRepository) SaveSchedule(ctx context.Context, schedule story.Schedule) error {
+	r.mu.Lock()
+	fail := r.failSaves
+	r.mu.Unlock()
+	if fail {
+		return errors.New("disk full")
+	}
+	return r.Repository.SaveSchedule(ctx, schedule)
+}
+
+func (r *flakyRepository) setFailSaves(fail bool) {
+	r.mu.Lock()
+	r.failSaves = fail
+	r.mu.Unlock()
+}
+
+type fixture struct {
+	scheduler *Scheduler
+	repo      *flakyRepository
+	storyID   string
+	triggers  *realtime.Subscription
+	now       time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	repo := &flakyRepository{Repository: story.NewMemoryRepository()}
+	hub := realtime.NewHub()
+	svc := story.NewService(repo, noopRunner{}, hub, nil)
+	manager := jobs.NewManager(svc, hub, jobs.Config{})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		manager.Shutdown(ctx)
+	})
+	created, err := svc.CreateStory(context.Background(), story.CreateStoryInput{Title: "scheduled"})
+	if err != nil {
+		t.Fatalf("create story: %v", err)
+	}
+	sub, unsubscribe := hub.Subscribe(created.ID, realtime.SubscribeOptions{})
+	t.Cleanup(unsubscribe)
+
+	f := &fixture{
+		scheduler: New(repo, manager, hub),
+		repo:      repo,
+		storyID:   created.ID,
+		triggers:  sub,
+		now:       time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.scheduler.now = func() time.Time { return f.now }
+	return f
+}
+
+// addSchedule stores an hourly schedule whose next run was due at nextRun.
+func (f *fixture) addSchedule(t *testing.T, nextRun time.Time) story.Schedule {
+	t.Helper()
+	schedule := story.Schedule{
+		ID:        "schedule-1",
+		StoryID:   f.storyID,
+		Cron:      "0 * * * *",
+		Enabled:   true,
+		CreatedAt: nextRun,
+		UpdatedAt: nextRun,
+		NextRunAt: &nextRun,
+	}
+	if err := f.repo.SaveSchedule(context.Background(), schedule); err != nil {
+		t.Fatalf("save schedule: %v", err)
+	}
+	return schedule
+}
+
+func (f *fixture) fireDue(t *testing.T) time.Time {
+	t.Helper()
+	next, err := f.scheduler.fireDue(context.Background())
+	if err != nil {
+		t.Fatalf("fireDue: %v", err)
+	}
+	return next
+}
+
+// triggered counts the schedule.triggered events published since the last call.
+func (f *fixture) triggered() int {
+	events, _ := f.triggers.Drain()
+	n := 0
+	for _, event := range events {
+		if event.Type == "schedule.triggered" {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fixture) stored(t *testing.T, scheduleID string) story.Schedule {
+	t.Helper()
+	schedule, err := f.repo.GetSchedule(context.Background(), scheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	return schedule
+}
+
+func TestFireDueFiresMissedRunsOnce(t *testing.T) {
+	f := newFixture(t)
+	schedule := f.addSchedule(t, f.now.Add(-5*time.Hour))
+
+	next := f.fireDue(t)
+	if n := f.triggered(); n != 1 {
+		t.Fatalf("expected one trigger for five missed runs, got %d", n)
+	}
+	want := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)
+	if !next.Equal(want) {
+		t.Fatalf("expected the loop to wake at %s, got %s", want, next)
+	}
+	stored := f.stored(t, schedule.ID)
+	if stored.NextRunAt == nil || !stored.NextRunAt.Equal(want) {
+		t.Fatalf("expected the next run to be stored as %s, got %v", want, stored.NextRunAt)
+	}
+	if stored.LastRunAt == nil || !stored.LastRunAt.Equal(f.now) || stored.LastJobID == "" {
+		t.Fatalf("expected the trigger to be recorded, got %+v", stored)
+	}
+
+	f.fireDue(t)
+	if n := f.triggered(); n != 0 {
+		t.Fatalf("expected no further triggers, got %d", n)
+	}
+}
+
+func TestFireDuePlansNewSchedules(t *testing.T) {
+	f := newFixture(t)
+	schedule := f.addSchedule(t, f.now)
+	schedule.NextRunAt = nil
+	if err := f.repo.SaveSchedule(context.Background(), schedule); err != nil {
+		t.Fatalf("save schedule: %v", err)
+	}
+
+	next := f.fireDue(t)
+	if n := f.triggered(); n != 0 {
+		t.Fatalf("expected an unplanned schedule not to fire, got %d triggers", n)
+	}
+	if want := f.now.Add(time.Hour); !next.Equal(want) {
+		t.Fatalf("expected the loop to wake at %s, got %s", want, next)
+	}
+}
+
+func TestFireDueSkipsDisabledSchedules(t *testing.T) {
+	f := newFixture(t)
+	schedule := f.addSchedule(t, f.now.Add(-time.Minute))
+	schedule.Enabled = false
+	if err := f.repo.SaveSchedule(context.Background(), schedule); err != nil {
+		t.Fatalf("save schedule: %v", err)
+	}
+
+	if next := f.fireDue(t); !next.IsZero() {
+		t.Fatalf("expected nothing to wait for, got %s", next)
+	}
+	if n := f.triggered(); n != 0 {
+		t.Fatalf("expected a disabled schedule not to fire, got %d triggers", n)
+	}
+}
+
+func TestFireDueDoesNotRefireWhenSaveFails(t *testing.T) {
+	f := newFixture(t)
+	schedule := f.addSchedule(t, f.now.Add(-time.Minute))
+	f.repo.setFailSaves(true)
+
+	next := f.fireDue(t)
+	if n := f.triggered(); n != 1 {
+		t.Fatalf("expected one trigger, got %d", n)
+	}
+	if !next.After(f.now) || next.After(f.now.Add(saveRetry)) {
+		t.Fatalf("expected a retry within %s, got %s", saveRetry, next)
+	}
+
+	// The stored copy is still due, but the schedule already fired.
+	f.now = f.now.Add(saveRetry)
+	f.fireDue(t)
+	if n := f.triggered(); n != 0 {
+		t.Fatalf("expected no second trigger while saves fail, got %d", n)
+	}
+
+	f.repo.setFailSaves(false)
+	f.now = f.now.Add(saveRetry)
+	next = f.fireDue(t)
+	if n := f.triggered(); n != 0 {
+		t.Fatalf("expected the retry only to save, got %d triggers", n)
+	}
+	want := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)
+	if !next.Equal(want) {
+		t.Fatalf("expected the loop to wake at %s, got %s", want, next)
+	}
+	stored := f.stored(t, schedule.ID)
+	if stored.NextRunAt == nil || !stored.NextRunAt.Equal(want) || stored.LastJobID == "" {
+		t.Fatalf("expected the fired schedule to be stored, got %+v", stored)
+	}
+}
@@ -1,9 +1,29 @@
 package server
 
+import (
+    "time"
+
+    realtimepkg "github.com/example/multistory/internal/realtime"
+)
+
 // Config collects runtime settings for the HTTP server.
 type Config struct {
     Addr           string
     AllowedOrigins []string
+    // HeartbeatInterval spaces the comments written to idle event streams so proxies keep them
+    // open. Zero means 15 seconds.
+    HeartbeatInterval time.Duration
+    // RetryInterval is the reconnect delay event streams suggest to clients. Zero means 3 seconds.
+    RetryInterval time.Duration
+    // Overflow is the default policy for event stream subscribers that fall behind. Clients may
+    // switch to drop-oldest or disconnect with the overflow query parameter, but not to block.
+    Overflow realtimepkg.OverflowPolicy
+    // BlockTimeout bounds how long publishers wait on subscribers using realtime.OverflowBlock.
+    BlockTimeout time.Duration
+    // SocketTokens maps access tokens to the users they authenticate on event streams and
+    // WebSocket connections, either directly or through a stream ticket. When empty, streams are
+    // open and name their user with the actor query parameter.
+    SocketTokens map[string]string
 }
 
 func (c Config) httpAddr() string {
@@ -15,3 +35,17 @@ func (c Config) httpAddr() string {
     }
     return ":" + c.Addr
 }
+
+func (c Config) heartbeatInterval() time.Duration {
+    if c.HeartbeatInterval <= 0 {
+        return 15 * time.Second
+    }
+    return c.HeartbeatInterval
+}
+
+func (c Config) retryInterval() time.Duration {
+    if c.RetryInterval <= 0 {
+        return 3 * time.Second
+    }
+    return c.RetryInterval
+}
@@ -3,26 +3,66 @@ package server
 import (
     "context"
     "encoding/json"
+    "errors"
+    "fmt"
+    "io"
     "log"
     "net/http"
+    "strconv"
     "strings"
     "time"
 
+    jobspkg "github.com/example/multistory/internal/jobs"
     realtimepkg "github.com/example/multistory/internal/realtime"
+    schedulerpkg "github.com/example/multistory/internal/scheduler"
     storypkg "github.com/example/multistory/internal/story"
 )
 
 type handler struct {
-    stories storypkg.Service
-    hub     *realtimepkg.Hub
+    stories   storypkg.Service
+    hub       *realtimepkg.Hub
+    presence  *realtimepkg.Presence
+    jobs      *jobspkg.Manager
+    schedules *schedulerpkg.Scheduler
+    // shutdown is closed when the server starts shutting down, ending open event streams.
+    shutdown  <-chan struct{}
+    heartbeat time.Duration
+    retry     time.Duration
+    subscribe realtimepkg.SubscribeOptions
+    // origins guards WebSocket connections, and tokens and tickets both kinds of stream; see
+    // Config.
+    origins map[string]bool
+    tokens  map[string]string
+    tickets *ticketStore
+    sockets *connTracker
 }
 
-func newRouter(cfg Config, svc storypkg.Service, hub *realtimepkg.Hub) http.Handler {
-    h := handler{stories: svc, hub: hub}
+func newRouter(cfg Config, svc storypkg.Service, hub *realtimepkg.Hub, presence *realtimepkg.Presence, jobs *jobspkg.Manager, schedules *schedulerpkg.Scheduler, shutdown <-chan struct{}, sockets *connTracker) http.Handler {
+    h := handler{
+        stories:   svc,
+        hub:       hub,
+        presence:  presence,
+        jobs:      jobs,
+        schedules: schedules,
+        shutdown:  shutdown,
+        heartbeat: cfg.heartbeatInterval(),
+        retry:     cfg.retryInterval(),
+        subscribe: realtimepkg.SubscribeOptions{Overflow: cfg.Overflow, BlockTimeout: cfg.BlockTimeout},
+        origins:   make(map[string]bool, len(cfg.AllowedOrigins)),
+        tokens:    cfg.SocketTokens,
+        tickets:   newTicketStore(),
+        sockets:   sockets,
+    }
+    for _, origin := range cfg.AllowedOrigins {
+        h.origins[origin] = true
+    }
     mux := http.NewServeMux()
     mux.HandleFunc("/healthz", h.health)
     mux.HandleFunc("/api/stories", h.handleStories)
     mux.HandleFunc("/api/stories/", h.handleStoryByID)
+    mux.HandleFunc("/api/executions", h.executionStats)
+    mux.HandleFunc("/api/executions/", h.handleExecution)
+    mux.HandleFunc("/api/schedules/", h.handleSchedule)
 
     return withLogging(withCORS(cfg.AllowedOrigins, mux))
 }
@@ -51,6 +91,50 @@ func (h handler) handleStoryByID(w http.ResponseWriter, r *http.Request) {
     }
     id := strings.TrimPrefix(r.URL.Path, "/api/stories/")
     switch {
+    case strings.Contains(id, "/revisions/"):
+        storyID, revisionID, _ := strings.Cut(id, "/revisions/")
+        if fromID, toID, ok := strings.Cut(revisionID, "/diff/"); ok && !strings.Contains(storyID, "/") {
+            if fromID == "" || toID == "" || strings.Contains(fromID, "/") || strings.Contains(toID, "/") {
+                writeError(w, http.StatusNotFound, "invalid path")
+                return
+            }
+            h.diffRevisions(w, r, storyID, fromID, toID)
+            return
+        }
+        if revID, ok := strings.CutSuffix(revisionID, "/restore"); ok && revID != "" && !strings.Contains(revID, "/") && !strings.Contains(storyID, "/") {
+            h.restoreRevision(w, r, storyID, revID)
+            return
+        }
+        if strings.Contains(storyID, "/") || strings.Contains(revisionID, "/") || revisionID == "" {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.getRevision(w, r, storyID, revisionID)
+        return
+    case strings.HasSuffix(id, "/revisions"):
+        storyID := strings.TrimSuffix(id, "/revisions")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.listRevisions(w, r, storyID)
+        return
+    case strings.Contains(id, "/blocks/"):
+        storyID, rest, _ := strings.Cut(id, "/blocks/")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        if blockID, ok := strings.CutSuffix(rest, "/move"); ok && !strings.Contains(blockID, "/") {
+            h.moveBlock(w, r, storyID, blockID)
+            return
+        }
+        if idx := strings.Index(rest, "/"); idx != -1 || rest == "" {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.handleBlock(w, r, storyID, rest)
+        return
     case strings.HasSuffix(id, "/blocks"):
         storyID := strings.TrimSuffix(id, "/blocks")
         if idx := strings.Index(storyID, "/"); idx != -1 {
@@ -75,6 +159,46 @@ func (h handler) handleStoryByID(w http.ResponseWriter, r *http.Request) {
         }
         h.executeStory(w, r, storyID)
         return
+    case strings.HasSuffix(id, "/executions"):
+        storyID := strings.TrimSuffix(id, "/executions")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.listExecutions(w, r, storyID)
+        return
+    case strings.HasSuffix(id, "/kernel/restart"):
+        storyID := strings.TrimSuffix(id, "/kernel/restart")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.restartKernel(w, r, storyID)
+        return
+    case strings.HasSuffix(id, "/parameters"):
+        storyID := strings.TrimSuffix(id, "/parameters")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.updateParameters(w, r, storyID)
+        return
+    case strings.HasSuffix(id, "/graph"):
+        storyID := strings.TrimSuffix(id, "/graph")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.storyGraph(w, r, storyID)
+        return
+    case strings.HasSuffix(id, "/schedules"):
+        storyID := strings.TrimSuffix(id, "/schedules")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.handleStorySchedules(w, r, storyID)
+        return
     case strings.HasSuffix(id, "/events"):
         storyID := strings.TrimSuffix(id, "/events")
         if idx := strings.Index(storyID, "/"); idx != -1 {
@@ -83,6 +207,30 @@ func (h handler) handleStoryByID(w http.ResponseWriter, r *http.Request) {
         }
         h.streamEvents(w, r, storyID)
         return
+    case strings.HasSuffix(id, "/presence"):
+        storyID := strings.TrimSuffix(id, "/presence")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.listPresence(w, r, storyID)
+        return
+    case strings.HasSuffix(id, "/ws"):
+        storyID := strings.TrimSuffix(id, "/ws")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.streamSocket(w, r, storyID)
+        return
+    case strings.HasSuffix(id, "/stream-ticket"):
+        storyID := strings.TrimSuffix(id, "/stream-ticket")
+        if idx := strings.Index(storyID, "/"); idx != -1 {
+            writeError(w, http.StatusNotFound, "invalid path")
+            return
+        }
+        h.issueStreamTicket(w, r, storyID)
+        return
     }
 
     switch r.Method {
@@ -117,6 +265,7 @@ func (h handler) createStory(w http.ResponseWriter, r *http.Request) {
         Visibility  storypkg.Visibility      `json:"visibility"`
         Tags        []string                 `json:"tags"`
         Blocks      []storypkg.BlockInput    `json:"blocks"`
+        Parameters  []storypkg.Parameter     `json:"parameters"`
     }
     if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
         writeError(w, http.StatusBadRequest, "invalid json payload")
@@ -129,8 +278,13 @@ func (h handler) createStory(w http.ResponseWriter, r *http.Request) {
         Visibility:  payload.Visibility,
         Tags:        payload.Tags,
         Blocks:      payload.Blocks,
+        Parameters:  payload.Parameters,
     })
     if err != nil {
+        if errors.Is(err, storypkg.ErrInvalidParameter) {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
         writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
@@ -147,6 +301,7 @@ func (h handler) getStory(w http.ResponseWriter, r *http.Request, id string) {
         writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
+    w.Header().Set("ETag", `"`+story.RevisionID+`"`)
     writeJSON(w, http.StatusOK, story)
 }
 
@@ -164,8 +319,12 @@ func (h handler) appendBlock(w http.ResponseWriter, r *http.Request, id string)
         writeError(w, http.StatusBadRequest, "invalid json payload")
         return
     }
+    payload.ExpectedRevision = expectedRevision(r, payload.ExpectedRevision)
     updated, err := h.stories.AppendBlock(r.Context(), id, payload)
     if err != nil {
+        if writeConflict(w, err) {
+            return
+        }
         if err == storypkg.ErrNotFound {
             writeError(w, http.StatusNotFound, "story not found")
             return
@@ -176,6 +335,250 @@ func (h handler) appendBlock(w http.ResponseWriter, r *http.Request, id string)
     writeJSON(w, http.StatusOK, updated)
 }
 
+func (h handler) listRevisions(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    revisions, err := h.stories.ListRevisions(r.Context(), id)
+    if err != nil {
+        if err == storypkg.ErrNotFound {
+            writeError(w, http.StatusNotFound, "story not found")
+            return
+        }
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, revisions)
+}
+
+func (h handler) getRevision(w http.ResponseWriter, r *http.Request, id, revisionID string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    revision, err := h.stories.GetRevision(r.Context(), id, revisionID)
+    if err != nil {
+        writeRevisionError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, revision)
+}
+
+func (h handler) diffRevisions(w http.ResponseWriter, r *http.Request, id, fromID, toID string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    diff, err := h.stories.DiffRevisions(r.Context(), id, fromID, toID)
+    if err != nil {
+        writeRevisionError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, diff)
+}
+
+func (h handler) restoreRevision(w http.ResponseWriter, r *http.Request, id, revisionID string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    var payload struct {
+        Actor            string `json:"actor"`
+        ExpectedRevision string `json:"expectedRevision"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid json payload")
+        return
+    }
+    restored, err := h.stories.RestoreRevision(r.Context(), id, revisionID, storypkg.WriteOptions{
+        Actor:            payload.Actor,
+        ExpectedRevision: expectedRevision(r, payload.ExpectedRevision),
+    })
+    if err != nil {
+        writeRevisionError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, restored)
+}
+
+func writeRevisionError(w http.ResponseWriter, err error) {
+    if writeConflict(w, err) {
+        return
+    }
+    switch err {
+    case storypkg.ErrNotFound:
+        writeError(w, http.StatusNotFound, "story not found")
+    case storypkg.ErrRevisionNotFound:
+        writeError(w, http.StatusNotFound, "revision not found")
+    default:
+        writeError(w, http.StatusInternalServerError, err.Error())
+    }
+}
+
+func (h handler) handleBlock(w http.ResponseWriter, r *http.Request, id, blockID string) {
+    switch r.Method {
+    case http.MethodPatch:
+        h.updateBlock(w, r, id, blockID)
+    case http.MethodDelete:
+        h.deleteBlock(w, r, id, blockID)
+    case http.MethodOptions:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
+
+func (h handler) updateBlock(w http.ResponseWriter, r *http.Request, id, blockID string) {
+    var payload struct {
+        Type             *storypkg.BlockType `json:"type"`
+        Language         *string             `json:"language"`
+        Source           *string             `json:"source"`
+        DependsOn        *[]string           `json:"dependsOn"`
+        Author           string              `json:"author"`
+        Message          string              `json:"message"`
+        ExpectedRevision string              `json:"expectedRevision"`
+        Run              bool                `json:"run"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid json payload")
+        return
+    }
+    updated, err := h.stories.UpdateBlock(r.Context(), id, blockID, storypkg.BlockPatch{
+        Type:             payload.Type,
+        Language:         payload.Language,
+        Source:           payload.Source,
+        DependsOn:        payload.DependsOn,
+        Author:           payload.Author,
+        Message:          payload.Message,
+        ExpectedRevision: expectedRevision(r, payload.ExpectedRevision),
+    })
+    if err != nil {
+        writeBlockError(w, err)
+        return
+    }
+    if payload.Run {
+        // The edit is saved either way; a run that cannot be queued only loses the Location header.
+        if jobID := h.runDownstream(r.Context(), id, blockID, payload.Author); jobID != "" {
+            w.Header().Set("Location", "/api/executions/"+jobID)
+        }
+    }
+    writeJSON(w, http.StatusOK, updated)
+}
+
+// runDownstream queues a run of the block and whatever depends on it, returning the job ID or ""
+// when the run could not be queued.
+func (h handler) runDownstream(ctx context.Context, id, blockID, actor string) string {
+    job, err := h.jobs.Submit(ctx, id, storypkg.ExecuteOptions{
+        Actor:  actor,
+        Target: storypkg.ExecutionTarget{Scope: storypkg.ScopeDownstream, BlockID: blockID},
+    })
+    if err != nil {
+        log.Printf("run block %s of story %s: %v", blockID, id, err)
+        return ""
+    }
+    return job.ID
+}
+
+func (h handler) deleteBlock(w http.ResponseWriter, r *http.Request, id, blockID string) {
+    updated, err := h.stories.DeleteBlock(r.Context(), id, blockID, storypkg.WriteOptions{
+        Actor:            r.URL.Query().Get("actor"),
+        ExpectedRevision: expectedRevision(r, r.URL.Query().Get("expectedRevision")),
+    })
+    if err != nil {
+        writeBlockError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, updated)
+}
+
+func (h handler) moveBlock(w http.ResponseWriter, r *http.Request, id, blockID string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    var payload struct {
+        Position         *int   `json:"position"`
+        Actor            string `json:"actor"`
+        ExpectedRevision string `json:"expectedRevision"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid json payload")
+        return
+    }
+    if payload.Position == nil {
+        writeError(w, http.StatusBadRequest, "position is required")
+        return
+    }
+    updated, err := h.stories.MoveBlock(r.Context(), id, blockID, *payload.Position, storypkg.WriteOptions{
+        Actor:            payload.Actor,
+        ExpectedRevision: expectedRevision(r, payload.ExpectedRevision),
+    })
+    if err != nil {
+        writeBlockError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, updated)
+}
+
+func writeBlockError(w http.ResponseWriter, err error) {
+    if writeConflict(w, err) {
+        return
+    }
+    switch err {
+    case storypkg.ErrNotFound:
+        writeError(w, http.StatusNotFound, "story not found")
+    case storypkg.ErrBlockNotFound:
+        writeError(w, http.StatusNotFound, "block not found")
+    default:
+        writeError(w, http.StatusInternalServerError, err.Error())
+    }
+}
+
+// expectedRevision prefers the If-Match header over a revision supplied in the request body.
+func expectedRevision(r *http.Request, fallback string) string {
+    match := strings.TrimSpace(r.Header.Get("If-Match"))
+    if match == "" || match == "*" {
+        return fallback
+    }
+    return strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+}
+
+// writeConflict answers 409 with the current story when err is a stale write.
+func writeConflict(w http.ResponseWriter, err error) bool {
+    var conflict *storypkg.ConflictError
+    if !errors.As(err, &conflict) {
+        return false
+    }
+    w.Header().Set("ETag", `"`+conflict.Current.RevisionID+`"`)
+    writeJSON(w, http.StatusConflict, map[string]interface{}{
+        "error":           "revision conflict",
+        "currentRevision": conflict.Current.RevisionID,
+        "story":           conflict.Current,
+    })
+    return true
+}
+
 func (h handler) createComment(w http.ResponseWriter, r *http.Request, id string) {
     if r.Method == http.MethodOptions {
         w.WriteHeader(http.StatusNoContent)
@@ -210,6 +613,86 @@ func (h handler) createComment(w http.ResponseWriter, r *http.Request, id string
     writeJSON(w, http.StatusOK, updated)
 }
 
+func (h handler) updateParameters(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodPut {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    var payload struct {
+        Parameters       []storypkg.Parameter `json:"parameters"`
+        Actor            string               `json:"actor"`
+        ExpectedRevision string               `json:"expectedRevision"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid json payload")
+        return
+    }
+    updated, err := h.stories.UpdateParameters(r.Context(), id, payload.Parameters, storypkg.WriteOptions{
+        Actor:            payload.Actor,
+        ExpectedRevision: expectedRevision(r, payload.ExpectedRevision),
+    })
+    if err != nil {
+        if writeConflict(w, err) {
+            return
+        }
+        switch {
+        case err == storypkg.ErrNotFound:
+            writeError(w, http.StatusNotFound, "story not found")
+        case errors.Is(err, storypkg.ErrInvalidParameter):
+            writeError(w, http.StatusBadRequest, err.Error())
+        default:
+            writeError(w, http.StatusInternalServerError, err.Error())
+        }
+        return
+    }
+    writeJSON(w, http.StatusOK, updated)
+}
+
+func (h handler) storyGraph(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    graph, err := h.stories.StoryGraph(r.Context(), id)
+    if err != nil {
+        if err == storypkg.ErrNotFound {
+            writeError(w, http.StatusNotFound, "story not found")
+            return
+        }
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, graph)
+}
+
+func (h handler) listPresence(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    if _, err := h.stories.GetStory(r.Context(), id); err != nil {
+        if err == storypkg.ErrNotFound {
+            writeError(w, http.StatusNotFound, "story not found")
+            return
+        }
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, h.presence.List(id))
+}
+
 func (h handler) executeStory(w http.ResponseWriter, r *http.Request, id string) {
     if r.Method == http.MethodOptions {
         w.WriteHeader(http.StatusNoContent)
@@ -220,13 +703,70 @@ func (h handler) executeStory(w http.ResponseWriter, r *http.Request, id string)
         return
     }
     var payload struct {
-        Actor string `json:"actor"`
+        Actor      string                  `json:"actor"`
+        BlockID    string                  `json:"blockId"`
+        Scope      storypkg.ExecutionScope `json:"scope"`
+        Force      bool                    `json:"force"`
+        Parameters map[string]interface{}  `json:"parameters"`
     }
     if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
         writeError(w, http.StatusBadRequest, "invalid json payload")
         return
     }
-    result, err := h.stories.ExecuteStory(r.Context(), id, payload.Actor)
+    job, err := h.jobs.Submit(r.Context(), id, storypkg.ExecuteOptions{
+        Actor:      payload.Actor,
+        Target:     storypkg.ExecutionTarget{Scope: payload.Scope, BlockID: payload.BlockID},
+        Force:      payload.Force,
+        Parameters: payload.Parameters,
+    })
+    if err != nil {
+        switch {
+        case err == storypkg.ErrNotFound:
+            writeError(w, http.StatusNotFound, "story not found")
+        case err == storypkg.ErrBlockNotFound:
+            writeError(w, http.StatusNotFound, "block not found")
+        case err == storypkg.ErrInvalidTarget:
+            writeError(w, http.StatusBadRequest, "scope must be all, block, from, upTo or downstream, with blockId unless all")
+        case errors.Is(err, storypkg.ErrCycle):
+            writeError(w, http.StatusConflict, err.Error())
+        case errors.Is(err, storypkg.ErrInvalidParameter):
+            writeError(w, http.StatusBadRequest, err.Error())
+        case err == jobspkg.ErrClosed:
+            writeError(w, http.StatusServiceUnavailable, "server shutting down")
+        case err == jobspkg.ErrQueueFull:
+            writeError(w, http.StatusServiceUnavailable, "execution queue is full")
+        default:
+            writeError(w, http.StatusInternalServerError, err.Error())
+        }
+        return
+    }
+    w.Header().Set("Location", "/api/executions/"+job.ID)
+    writeJSON(w, http.StatusAccepted, job)
+}
+
+func (h handler) listExecutions(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    filter := storypkg.ExecutionFilter{Status: r.URL.Query().Get("status")}
+    for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+        value := r.URL.Query().Get(name)
+        if value == "" {
+            continue
+        }
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 0 {
+            writeError(w, http.StatusBadRequest, "invalid "+name)
+            return
+        }
+        *dst = n
+    }
+    page, err := h.stories.ListExecutions(r.Context(), id, filter)
     if err != nil {
         if err == storypkg.ErrNotFound {
             writeError(w, http.StatusNotFound, "story not found")
@@ -235,7 +775,182 @@ func (h handler) executeStory(w http.ResponseWriter, r *http.Request, id string)
         writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
-    writeJSON(w, http.StatusOK, result)
+    writeJSON(w, http.StatusOK, page)
+}
+
+func (h handler) restartKernel(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    var payload struct {
+        Actor string `json:"actor"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid json payload")
+        return
+    }
+    if err := h.stories.RestartKernel(r.Context(), id, payload.Actor); err != nil {
+        switch err {
+        case storypkg.ErrNotFound:
+            writeError(w, http.StatusNotFound, "story not found")
+        case storypkg.ErrNoSessions:
+            writeError(w, http.StatusConflict, "runner has no kernel sessions")
+        default:
+            writeError(w, http.StatusInternalServerError, err.Error())
+        }
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) executionStats(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, http.StatusOK, h.jobs.Stats())
+    case http.MethodOptions:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
+
+func (h handler) handleExecution(w http.ResponseWriter, r *http.Request) {
+    jobID := strings.TrimPrefix(r.URL.Path, "/api/executions/")
+    if jobID == "" || strings.Contains(jobID, "/") {
+        writeError(w, http.StatusNotFound, "invalid path")
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        job, err := h.jobs.Get(jobID)
+        if err != nil {
+            writeError(w, http.StatusNotFound, "execution not found")
+            return
+        }
+        writeJSON(w, http.StatusOK, job)
+    case http.MethodDelete:
+        job, err := h.jobs.Cancel(jobID)
+        if err != nil {
+            switch err {
+            case jobspkg.ErrNotFound:
+                writeError(w, http.StatusNotFound, "execution not found")
+            case jobspkg.ErrFinished:
+                writeJSON(w, http.StatusConflict, map[string]interface{}{"error": "execution already finished", "job": job})
+            default:
+                writeError(w, http.StatusInternalServerError, err.Error())
+            }
+            return
+        }
+        writeJSON(w, http.StatusAccepted, job)
+    case http.MethodOptions:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
+
+func (h handler) handleStorySchedules(w http.ResponseWriter, r *http.Request, id string) {
+    switch r.Method {
+    case http.MethodGet:
+        schedules, err := h.schedules.List(r.Context(), id)
+        if err != nil {
+            writeScheduleError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, schedules)
+    case http.MethodPost:
+        var payload struct {
+            Cron     string `json:"cron"`
+            Timezone string `json:"timezone"`
+            Enabled  *bool  `json:"enabled"`
+            Actor    string `json:"actor"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+            writeError(w, http.StatusBadRequest, "invalid json payload")
+            return
+        }
+        schedule, err := h.schedules.Create(r.Context(), id, schedulerpkg.CreateInput{
+            Cron:     payload.Cron,
+            Timezone: payload.Timezone,
+            Enabled:  payload.Enabled,
+            Actor:    payload.Actor,
+        })
+        if err != nil {
+            writeScheduleError(w, err)
+            return
+        }
+        w.Header().Set("Location", "/api/schedules/"+schedule.ID)
+        writeJSON(w, http.StatusCreated, schedule)
+    case http.MethodOptions:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
+
+func (h handler) handleSchedule(w http.ResponseWriter, r *http.Request) {
+    scheduleID := strings.TrimPrefix(r.URL.Path, "/api/schedules/")
+    if scheduleID == "" || strings.Contains(scheduleID, "/") {
+        writeError(w, http.StatusNotFound, "invalid path")
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        schedule, err := h.schedules.Get(r.Context(), scheduleID)
+        if err != nil {
+            writeScheduleError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, schedule)
+    case http.MethodPatch:
+        var payload struct {
+            Cron     *string `json:"cron"`
+            Timezone *string `json:"timezone"`
+            Enabled  *bool   `json:"enabled"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+            writeError(w, http.StatusBadRequest, "invalid json payload")
+            return
+        }
+        schedule, err := h.schedules.Update(r.Context(), scheduleID, schedulerpkg.UpdateInput{
+            Cron:     payload.Cron,
+            Timezone: payload.Timezone,
+            Enabled:  payload.Enabled,
+        })
+        if err != nil {
+            writeScheduleError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, schedule)
+    case http.MethodDelete:
+        if err := h.schedules.Delete(r.Context(), scheduleID); err != nil {
+            writeScheduleError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case http.MethodOptions:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    }
+}
+
+func writeScheduleError(w http.ResponseWriter, err error) {
+    switch {
+    case err == storypkg.ErrNotFound:
+        writeError(w, http.StatusNotFound, "story not found")
+    case err == storypkg.ErrScheduleNotFound:
+        writeError(w, http.StatusNotFound, "schedule not found")
+    case errors.Is(err, schedulerpkg.ErrInvalidSchedule):
+        writeError(w, http.StatusBadRequest, err.Error())
+    default:
+        writeError(w, http.StatusInternalServerError, err.Error())
+    }
 }
 
 func (h handler) streamEvents(w http.ResponseWriter, r *http.Request, id string) {
@@ -252,15 +967,74 @@ func (h handler) streamEvents(w http.ResponseWriter, r *http.Request, id string)
         writeError(w, http.StatusInternalServerError, "streaming unsupported")
         return
     }
+    user, ok := h.streamUser(w, r, id)
+    if !ok {
+        return
+    }
     ctx, cancel := context.WithCancel(r.Context())
     defer cancel()
-    ch, unsubscribe := h.hub.Subscribe(id)
+    // Browsers send Last-Event-ID when they reconnect; the query parameter lets a client resume
+    // a stream it opens itself.
+    lastEventID := r.Header.Get("Last-Event-ID")
+    if lastEventID == "" {
+        lastEventID = r.URL.Query().Get("lastEventId")
+    }
+    opts, err := h.subscribeOptions(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    sub, replay, unsubscribe := h.hub.Resume(id, lastEventID, opts)
     defer unsubscribe()
+    session, leave := h.presence.Join(id, user)
+    defer leave()
+
+    // The server's read and write timeouts suit ordinary requests; a stream stays open until the
+    // client leaves or the server shuts down.
+    rc := http.NewResponseController(w)
+    if err := rc.SetReadDeadline(time.Time{}); err != nil {
+        log.Printf("sse read deadline: %v", err)
+    }
+    if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+        log.Printf("sse write deadline: %v", err)
+    }
 
     w.Header().Set("Content-Type", "text/event-stream")
     w.Header().Set("Cache-Control", "no-cache")
     w.Header().Set("Connection", "keep-alive")
+    // Proxies such as nginx buffer responses unless told otherwise.
+    w.Header().Set("X-Accel-Buffering", "no")
+
+    if _, err := fmt.Fprintf(w, "retry: %d\n\n", h.retry.Milliseconds()); err != nil {
+        return
+    }
 
+    if !replay.Complete {
+        // The missed events are gone; point the client at the latest ID so it resumes from here
+        // once it has refetched the story.
+        resync := realtimepkg.Event{
+            ID:      replay.LastID,
+            StoryID: id,
+            Type:    "stream.resync",
+            Payload: map[string]string{"lastEventId": lastEventID},
+        }
+        if err := h.writeEvent(w, resync); err != nil {
+            return
+        }
+    }
+    for _, event := range replay.Events {
+        if err := h.writeEvent(w, event); err != nil {
+            return
+        }
+    }
+    // Tell the client which presence entry is its own.
+    if err := h.writeEvent(w, realtimepkg.Event{StoryID: id, Type: "presence.session", Payload: session.Entry()}); err != nil {
+        return
+    }
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(h.heartbeat)
+    defer heartbeat.Stop()
     notify := r.Context().Done()
     for {
         select {
@@ -268,21 +1042,63 @@ func (h handler) streamEvents(w http.ResponseWriter, r *http.Request, id string)
             return
         case <-notify:
             return
-        case event := <-ch:
-            payload, err := event.Marshal()
-            if err != nil {
-                log.Printf("sse marshal error: %v", err)
-                continue
-            }
-            if _, err := w.Write([]byte("event: " + event.Type + "\n")); err != nil {
+        case <-h.shutdown:
+            // No id: the client should resume from the last story event it saw.
+            h.writeEvent(w, realtimepkg.Event{StoryID: id, Type: "server.shutdown"})
+            flusher.Flush()
+            return
+        case <-heartbeat.C:
+            if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
                 return
             }
-            if _, err := w.Write([]byte("data: " + string(payload) + "\n\n")); err != nil {
-                return
+            flusher.Flush()
+            session.Touch()
+        case <-sub.Ready():
+            events, open := sub.Drain()
+            for _, event := range events {
+                if err := h.writeEvent(w, event); err != nil {
+                    return
+                }
             }
             flusher.Flush()
+            if !open {
+                log.Printf("sse: dropped subscriber to story %s after it missed %d events", id, sub.Dropped())
+                return
+            }
+        }
+    }
+}
+
+// subscribeOptions applies the ?overflow= choice of a stream client to the configured defaults.
+// Clients may pick drop-oldest or disconnect; block holds up every publisher to the story, so
+// only the server configuration can select it.
+func (h handler) subscribeOptions(r *http.Request) (realtimepkg.SubscribeOptions, error) {
+    opts := h.subscribe
+    switch policy := realtimepkg.OverflowPolicy(r.URL.Query().Get("overflow")); policy {
+    case "":
+    case realtimepkg.OverflowDropOldest, realtimepkg.OverflowDisconnect:
+        opts.Overflow = policy
+    default:
+        return opts, fmt.Errorf("overflow must be %s or %s", realtimepkg.OverflowDropOldest, realtimepkg.OverflowDisconnect)
+    }
+    return opts, nil
+}
+
+// writeEvent writes one SSE message, with an id: line unless the event is not numbered. Events
+// that cannot be encoded are logged and skipped.
+func (h handler) writeEvent(w http.ResponseWriter, event realtimepkg.Event) error {
+    payload, err := event.Marshal()
+    if err != nil {
+        log.Printf("sse marshal error: %v", err)
+        return nil
+    }
+    if event.ID != 0 {
+        if _, err := fmt.Fprintf(w, "id: %s\n", h.hub.StreamID(event.ID)); err != nil {
+            return err
         }
     }
+    _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+    return err
 }
 
 func withLogging(next http.Handler) http.Handler {
@@ -308,8 +1124,9 @@ func withCORS(allowed []string, next http.Handler) http.Handler {
             }
             w.Header().Set("Vary", "Origin")
         }
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-        w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+        w.Header().Set("Access-Control-Expose-Headers", "ETag, Location")
+        w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
         if r.Method == http.MethodOptions {
             w.WriteHeader(http.StatusNoContent)
             return
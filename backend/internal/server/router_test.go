@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	realtimepkg "github.com/example/multistory/internal/realtime"
+	storypkg "github.com/example/multistory/internal/story"
+)
+
+func TestRestoreRevisionRejectsStaleWrites(t *testing.T) {
+	svc := storypkg.NewService(storypkg.NewMemoryRepository(), nil, realtimepkg.NewHub(), nil)
+	router := newRouter(Config{}, svc, realtimepkg.NewHub(), nil, nil, nil, nil, &connTracker{})
+	ctx := context.Background()
+	created, err := svc.CreateStory(ctx, storypkg.CreateStoryInput{
+		Title:  "restore",
+		Blocks: []storypkg.BlockInput{{Type: storypkg.BlockMarkdown, Source: "first"}},
+	})
+	if err != nil {
+		t.Fatalf("create story: %v", err)
+	}
+	edited, err := svc.UpdateBlock(ctx, created.ID, created.Blocks[0].ID, storypkg.BlockPatch{Source: ptr("second")})
+	if err != nil {
+		t.Fatalf("update block: %v", err)
+	}
+
+	restore := func(ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/stories/"+created.ID+"/revisions/"+created.RevisionID+"/restore", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	stale := []struct {
+		name, ifMatch, body string
+	}{
+		{name: "if-match", ifMatch: `"` + created.RevisionID + `"`, body: `{}`},
+		{name: "body", body: `{"expectedRevision":"` + created.RevisionID + `"}`},
+	}
+	for _, tc := range stale {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := restore(tc.ifMatch, tc.body)
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body)
+			}
+			var conflict struct {
+				Story storypkg.Story `json:"story"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&conflict); err != nil {
+				t.Fatalf("decode conflict: %v", err)
+			}
+			if conflict.Story.RevisionID != edited.RevisionID || conflict.Story.Blocks[0].Source != "second" {
+				t.Fatalf("expected the current story in the conflict, got %+v", conflict.Story)
+			}
+		})
+	}
+
+	rec := restore(`"`+edited.RevisionID+`"`, `{"actor":"ada"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var restored storypkg.Story
+	if err := json.NewDecoder(rec.Body).Decode(&restored); err != nil {
+		t.Fatalf("decode story: %v", err)
+	}
+	if restored.RevisionID == edited.RevisionID || restored.Blocks[0].Source != "first" {
+		t.Fatalf("expected the first revision to be restored, got %+v", restored)
+	}
+}
+
+func ptr(s string) *string { return &s }
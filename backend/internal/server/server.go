@@ -1,17 +1,32 @@
 package server
 
 import (
+    "context"
     "net/http"
+    "sync"
     "time"
 
+    jobspkg "github.com/example/multistory/internal/jobs"
     realtimepkg "github.com/example/multistory/internal/realtime"
+    schedulerpkg "github.com/example/multistory/internal/scheduler"
     storypkg "github.com/example/multistory/internal/story"
 )
 
-// New constructs an *http.Server configured with sensible defaults ready to serve requests.
-func New(cfg Config, svc storypkg.Service, hub *realtimepkg.Hub) *http.Server {
-    handler := newRouter(cfg, svc, hub)
-    return &http.Server{
+// Server is the HTTP server. Its Shutdown also waits for WebSocket connections, which net/http
+// stops tracking once they are taken over.
+type Server struct {
+    *http.Server
+    sockets *connTracker
+}
+
+// New constructs a *Server configured with sensible defaults ready to serve requests. Event
+// streams and WebSocket connections lift the read and write timeouts for themselves, and end with
+// a server.shutdown event once Shutdown is called.
+func New(cfg Config, svc storypkg.Service, hub *realtimepkg.Hub, presence *realtimepkg.Presence, jobs *jobspkg.Manager, schedules *schedulerpkg.Scheduler) *Server {
+    shutdown := make(chan struct{})
+    sockets := &connTracker{}
+    handler := newRouter(cfg, svc, hub, presence, jobs, schedules, shutdown, sockets)
+    srv := &http.Server{
         Addr:              cfg.httpAddr(),
         Handler:           handler,
         ReadHeaderTimeout: 5 * time.Second,
@@ -19,4 +34,61 @@ func New(cfg Config, svc storypkg.Service, hub *realtimepkg.Hub) *http.Server {
         WriteTimeout:      10 * time.Second,
         IdleTimeout:       60 * time.Second,
     }
+    var once sync.Once
+    srv.RegisterOnShutdown(func() {
+        once.Do(func() { close(shutdown) })
+    })
+    return &Server{Server: srv, sockets: sockets}
+}
+
+// Shutdown stops the server gracefully like http.Server.Shutdown, then waits for open WebSocket
+// connections to close or ctx to end.
+func (s *Server) Shutdown(ctx context.Context) error {
+    if err := s.Server.Shutdown(ctx); err != nil {
+        return err
+    }
+    return s.sockets.wait(ctx)
+}
+
+// connTracker counts open WebSocket connections.
+type connTracker struct {
+    mu   sync.Mutex
+    open int
+    // idle is closed when the last connection closes; wait creates it.
+    idle chan struct{}
+}
+
+func (t *connTracker) add() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.open++
+}
+
+func (t *connTracker) done() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.open--
+    if t.open == 0 && t.idle != nil {
+        close(t.idle)
+        t.idle = nil
+    }
+}
+
+func (t *connTracker) wait(ctx context.Context) error {
+    t.mu.Lock()
+    if t.open == 0 {
+        t.mu.Unlock()
+        return nil
+    }
+    if t.idle == nil {
+        t.idle = make(chan struct{})
+    }
+    idle := t.idle
+    t.mu.Unlock()
+    select {
+    case <-idle:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
 }
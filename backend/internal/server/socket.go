@@ -0,0 +1,397 @@
+package server
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    realtimepkg "github.com/example/multistory/internal/realtime"
+    storypkg "github.com/example/multistory/internal/story"
+    websocketpkg "github.com/example/multistory/internal/websocket"
+)
+
+// socketWriteWait bounds each write to a WebSocket client, so one that stops reading is dropped
+// rather than holding the connection open.
+const socketWriteWait = 10 * time.Second
+
+// socketEvent is how story events travel over a WebSocket. StreamID is the value to pass back as
+// lastEventId when reconnecting.
+type socketEvent struct {
+    realtimepkg.Event
+    StreamID string `json:"streamId,omitempty"`
+}
+
+// socketRequest is a message from a WebSocket client. ID is echoed in the reply.
+type socketRequest struct {
+    ID      string          `json:"id"`
+    Type    string          `json:"type"`
+    Payload json.RawMessage `json:"payload"`
+}
+
+// socketReply answers one socketRequest. Status follows the HTTP status the matching REST call
+// would have returned.
+type socketReply struct {
+    Type        string      `json:"type"`
+    ReplyTo     string      `json:"replyTo,omitempty"`
+    OK          bool        `json:"ok"`
+    Status      int         `json:"status"`
+    Error       string      `json:"error,omitempty"`
+    Payload     interface{} `json:"payload,omitempty"`
+    ExecutionID string      `json:"executionId,omitempty"`
+}
+
+func (h handler) streamSocket(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    if !websocketpkg.IsUpgrade(r) {
+        w.Header().Set("Upgrade", "websocket")
+        writeError(w, http.StatusUpgradeRequired, "websocket upgrade required")
+        return
+    }
+    // Browsers do not apply CORS to WebSockets, so check the origin here.
+    if origin := r.Header.Get("Origin"); origin != "" && len(h.origins) > 0 && !h.origins[origin] {
+        writeError(w, http.StatusForbidden, "origin not allowed")
+        return
+    }
+    user, ok := h.streamUser(w, r, id)
+    if !ok {
+        return
+    }
+    opts, err := h.subscribeOptions(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    h.sockets.add()
+    defer h.sockets.done()
+    conn, err := websocketpkg.Upgrade(w, r)
+    if err != nil {
+        if errors.Is(err, websocketpkg.ErrBadHandshake) {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        log.Printf("ws upgrade: %v", err)
+        return
+    }
+    defer conn.Close(websocketpkg.CloseNormal, "")
+    conn.SetWriteWait(socketWriteWait)
+
+    lastEventID := r.URL.Query().Get("lastEventId")
+    sub, replay, unsubscribe := h.hub.Resume(id, lastEventID, opts)
+    defer unsubscribe()
+    session, leave := h.presence.Join(id, user)
+    defer leave()
+
+    if !replay.Complete {
+        resync := realtimepkg.Event{
+            ID:      replay.LastID,
+            StoryID: id,
+            Type:    "stream.resync",
+            Payload: map[string]string{"lastEventId": lastEventID},
+        }
+        if err := h.writeSocketEvent(conn, resync); err != nil {
+            return
+        }
+    }
+    for _, event := range replay.Events {
+        if err := h.writeSocketEvent(conn, event); err != nil {
+            return
+        }
+    }
+    if err := h.writeSocketEvent(conn, realtimepkg.Event{StoryID: id, Type: "presence.session", Payload: session.Entry()}); err != nil {
+        return
+    }
+
+    // A client that misses two pings in a row is gone.
+    pongWait := 2 * h.heartbeat
+    conn.SetReadDeadline(time.Now().Add(pongWait))
+    conn.SetPongHandler(func() {
+        conn.SetReadDeadline(time.Now().Add(pongWait))
+        session.Touch()
+    })
+    ctx, cancel := context.WithCancel(r.Context())
+    defer cancel()
+    done := make(chan error, 1)
+    go func() {
+        done <- h.readSocket(ctx, conn, session, pongWait)
+    }()
+
+    ping := time.NewTicker(h.heartbeat)
+    defer ping.Stop()
+    for {
+        select {
+        case err := <-done:
+            var closeErr *websocketpkg.CloseError
+            if !errors.As(err, &closeErr) {
+                log.Printf("ws: story %s: %v", id, err)
+            }
+            return
+        case <-h.shutdown:
+            h.writeSocketEvent(conn, realtimepkg.Event{StoryID: id, Type: "server.shutdown"})
+            conn.Close(websocketpkg.CloseGoingAway, "server shutting down")
+            return
+        case <-ping.C:
+            if err := conn.Ping(time.Now().Add(socketWriteWait)); err != nil {
+                return
+            }
+        case <-sub.Ready():
+            events, open := sub.Drain()
+            for _, event := range events {
+                if err := h.writeSocketEvent(conn, event); err != nil {
+                    return
+                }
+            }
+            if !open {
+                log.Printf("ws: dropped subscriber to story %s after it missed %d events", id, sub.Dropped())
+                conn.Close(websocketpkg.CloseTryAgainLater, "subscriber fell behind")
+                return
+            }
+        }
+    }
+}
+
+// streamUser authenticates a client opening an event stream or WebSocket on the story and checks
+// it may read the story, answering the request itself when not. When tokens are configured the
+// client must send a bearer token or, since browsers cannot set headers on either kind of
+// stream, a ticket from issueStreamTicket in the ticket query parameter; it then acts as the
+// token's user, and private stories only admit their owners. Otherwise the client acts as the
+// user named by the actor query parameter, like the REST endpoints.
+func (h handler) streamUser(w http.ResponseWriter, r *http.Request, id string) (string, bool) {
+    user, ok := r.URL.Query().Get("actor"), true
+    if len(h.tokens) > 0 {
+        if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+            user, ok = h.tickets.redeem(ticket, id)
+        } else {
+            user, ok = h.bearerUser(r)
+        }
+    }
+    if !ok {
+        w.Header().Set("WWW-Authenticate", "Bearer")
+        writeError(w, http.StatusUnauthorized, "invalid or missing token")
+        return "", false
+    }
+    return user, h.canRead(w, r, id, user)
+}
+
+// bearerUser returns the user whose token the request carries in its Authorization header.
+func (h handler) bearerUser(r *http.Request) (string, bool) {
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || strings.TrimSpace(token) == "" {
+        return "", false
+    }
+    token = strings.TrimSpace(token)
+    for candidate, user := range h.tokens {
+        if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+            return user, true
+        }
+    }
+    return "", false
+}
+
+// canRead checks that the story exists and, when tokens are configured, that user may see it,
+// answering the request itself when not.
+func (h handler) canRead(w http.ResponseWriter, r *http.Request, id, user string) bool {
+    story, err := h.stories.GetStory(r.Context(), id)
+    if err != nil {
+        if err == storypkg.ErrNotFound {
+            writeError(w, http.StatusNotFound, "story not found")
+            return false
+        }
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return false
+    }
+    if len(h.tokens) > 0 && story.Visibility == storypkg.VisibilityPrivate && !contains(story.Owners, user) {
+        writeError(w, http.StatusForbidden, "story is private")
+        return false
+    }
+    return true
+}
+
+// issueStreamTicket trades the caller's bearer token for a short-lived ticket that opens one
+// event stream or WebSocket on the story. Without configured tokens the ticket carries the actor
+// query parameter instead, so clients can use tickets either way.
+func (h handler) issueStreamTicket(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    user, ok := r.URL.Query().Get("actor"), true
+    if len(h.tokens) > 0 {
+        user, ok = h.bearerUser(r)
+    }
+    if !ok {
+        w.Header().Set("WWW-Authenticate", "Bearer")
+        writeError(w, http.StatusUnauthorized, "invalid or missing token")
+        return
+    }
+    if !h.canRead(w, r, id, user) {
+        return
+    }
+    ticket, expires := h.tickets.issue(id, user)
+    writeJSON(w, http.StatusCreated, map[string]interface{}{"ticket": ticket, "expiresAt": expires.UTC()})
+}
+
+// readSocket handles client messages until the connection fails or closes, answering each with
+// a socketReply.
+func (h handler) readSocket(ctx context.Context, conn *websocketpkg.Conn, session *realtimepkg.PresenceSession, pongWait time.Duration) error {
+    for {
+        messageType, data, err := conn.ReadMessage()
+        if err != nil {
+            return err
+        }
+        conn.SetReadDeadline(time.Now().Add(pongWait))
+        session.Touch()
+        if messageType != websocketpkg.TextMessage {
+            conn.Close(websocketpkg.CloseUnsupportedData, "expected a text message")
+            return errors.New("client sent a binary message")
+        }
+        reply := h.handleSocketMessage(ctx, session, data)
+        if err := writeSocketJSON(conn, reply); err != nil {
+            return err
+        }
+    }
+}
+
+// handleSocketMessage applies one client message to the story.
+func (h handler) handleSocketMessage(ctx context.Context, session *realtimepkg.PresenceSession, data []byte) socketReply {
+    entry := session.Entry()
+    id, user := entry.StoryID, entry.User
+    var request socketRequest
+    if err := json.Unmarshal(data, &request); err != nil {
+        return socketFailure(request, http.StatusBadRequest, "invalid json payload")
+    }
+    switch request.Type {
+    case "presence.update":
+        var payload struct {
+            BlockID string `json:"blockId"`
+        }
+        if len(request.Payload) > 0 {
+            if err := json.Unmarshal(request.Payload, &payload); err != nil {
+                return socketFailure(request, http.StatusBadRequest, "invalid json payload")
+            }
+        }
+        return socketReply{Type: "reply", ReplyTo: request.ID, OK: true, Status: http.StatusOK, Payload: session.Focus(payload.BlockID)}
+    case "block.update":
+        var payload struct {
+            BlockID          string              `json:"blockId"`
+            Type             *storypkg.BlockType `json:"type"`
+            Language         *string             `json:"language"`
+            Source           *string             `json:"source"`
+            DependsOn        *[]string           `json:"dependsOn"`
+            Message          string              `json:"message"`
+            ExpectedRevision string              `json:"expectedRevision"`
+            Run              bool                `json:"run"`
+        }
+        if err := json.Unmarshal(request.Payload, &payload); err != nil {
+            return socketFailure(request, http.StatusBadRequest, "invalid json payload")
+        }
+        if payload.BlockID == "" {
+            return socketFailure(request, http.StatusBadRequest, "blockId is required")
+        }
+        updated, err := h.stories.UpdateBlock(ctx, id, payload.BlockID, storypkg.BlockPatch{
+            Type:             payload.Type,
+            Language:         payload.Language,
+            Source:           payload.Source,
+            DependsOn:        payload.DependsOn,
+            Author:           user,
+            Message:          payload.Message,
+            ExpectedRevision: payload.ExpectedRevision,
+        })
+        if err != nil {
+            return socketError(request, err)
+        }
+        reply := socketReply{Type: "reply", ReplyTo: request.ID, OK: true, Status: http.StatusOK, Payload: updated}
+        if payload.Run {
+            reply.ExecutionID = h.runDownstream(ctx, id, payload.BlockID, user)
+        }
+        return reply
+    case "comment.create":
+        var payload struct {
+            Body    string `json:"body"`
+            BlockID string `json:"blockId"`
+        }
+        if err := json.Unmarshal(request.Payload, &payload); err != nil {
+            return socketFailure(request, http.StatusBadRequest, "invalid json payload")
+        }
+        updated, err := h.stories.RecordComment(ctx, id, storypkg.CommentInput{
+            Author:  user,
+            Body:    payload.Body,
+            BlockID: payload.BlockID,
+        })
+        if err != nil {
+            return socketError(request, err)
+        }
+        return socketReply{Type: "reply", ReplyTo: request.ID, OK: true, Status: http.StatusOK, Payload: updated}
+    default:
+        return socketFailure(request, http.StatusBadRequest, "unknown message type "+request.Type)
+    }
+}
+
+func socketFailure(request socketRequest, status int, msg string) socketReply {
+    return socketReply{Type: "reply", ReplyTo: request.ID, Status: status, Error: msg}
+}
+
+// socketError mirrors writeBlockError, including the current story on a conflict.
+func socketError(request socketRequest, err error) socketReply {
+    var conflict *storypkg.ConflictError
+    if errors.As(err, &conflict) {
+        reply := socketFailure(request, http.StatusConflict, "revision conflict")
+        reply.Payload = map[string]interface{}{
+            "currentRevision": conflict.Current.RevisionID,
+            "story":           conflict.Current,
+        }
+        return reply
+    }
+    switch err {
+    case storypkg.ErrNotFound:
+        return socketFailure(request, http.StatusNotFound, "story not found")
+    case storypkg.ErrBlockNotFound:
+        return socketFailure(request, http.StatusNotFound, "block not found")
+    default:
+        return socketFailure(request, http.StatusInternalServerError, err.Error())
+    }
+}
+
+// writeSocketEvent sends a story event with its stream ID. Events that cannot be encoded are
+// logged and skipped.
+func (h handler) writeSocketEvent(conn *websocketpkg.Conn, event realtimepkg.Event) error {
+    message := socketEvent{Event: event}
+    if event.ID != 0 {
+        message.StreamID = h.hub.StreamID(event.ID)
+    }
+    return writeSocketJSON(conn, message)
+}
+
+func writeSocketJSON(conn *websocketpkg.Conn, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        log.Printf("ws marshal error: %v", err)
+        return nil
+    }
+    return conn.WriteMessageTimeout(websocketpkg.TextMessage, data, time.Now().Add(socketWriteWait))
+}
+
+func contains(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
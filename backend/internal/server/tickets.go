@@ -0,0 +1,68 @@
+package server
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+    "sync"
+    "time"
+)
+
+// streamTicketTTL is how long a stream ticket can be redeemed after it was issued.
+const streamTicketTTL = 30 * time.Second
+
+// ticketStore hands out single-use tickets for opening a story's event stream or WebSocket.
+// Browsers cannot set headers on either, so a client trades its bearer token for a ticket over
+// an ordinary request and puts the ticket in the stream URL instead of the token.
+type ticketStore struct {
+    now func() time.Time
+
+    mu      sync.Mutex
+    tickets map[string]streamTicket
+}
+
+type streamTicket struct {
+    storyID string
+    user    string
+    expires time.Time
+}
+
+func newTicketStore() *ticketStore {
+    return &ticketStore{now: time.Now, tickets: make(map[string]streamTicket)}
+}
+
+// issue returns a ticket that lets user open one stream on the story, and when it expires.
+func (s *ticketStore) issue(storyID, user string) (string, time.Time) {
+    buf := make([]byte, 24)
+    if _, err := rand.Read(buf); err != nil {
+        panic(err)
+    }
+    ticket := base64.RawURLEncoding.EncodeToString(buf)
+    now := s.now()
+    expires := now.Add(streamTicketTTL)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for key, t := range s.tickets {
+        if !now.Before(t.expires) {
+            delete(s.tickets, key)
+        }
+    }
+    s.tickets[ticket] = streamTicket{storyID: storyID, user: user, expires: expires}
+    return ticket, expires
+}
+
+// redeem uses up ticket and returns its user, or false when the ticket is unknown, expired or
+// was issued for another story.
+func (s *ticketStore) redeem(ticket, storyID string) (string, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    t, ok := s.tickets[ticket]
+    if !ok {
+        return "", false
+    }
+    delete(s.tickets, ticket)
+    if t.storyID != storyID || !s.now().Before(t.expires) {
+        return "", false
+    }
+    return t.user, true
+}
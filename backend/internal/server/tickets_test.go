@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicketStore(t *testing.T) {
+	store := newTicketStore()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	ticket, expires := store.issue("story-1", "ada")
+	if !expires.Equal(now.Add(streamTicketTTL)) {
+		t.Fatalf("expected the ticket to expire at %s, got %s", now.Add(streamTicketTTL), expires)
+	}
+	if other, _ := store.issue("story-1", "ada"); other == ticket {
+		t.Fatalf("expected every ticket to be different")
+	}
+	if user, ok := store.redeem(ticket, "story-1"); !ok || user != "ada" {
+		t.Fatalf("expected the ticket to authenticate ada, got %q, %v", user, ok)
+	}
+	if _, ok := store.redeem(ticket, "story-1"); ok {
+		t.Fatalf("expected a ticket to work only once")
+	}
+
+	ticket, _ = store.issue("story-1", "ada")
+	if _, ok := store.redeem(ticket, "story-2"); ok {
+		t.Fatalf("expected a ticket not to open another story")
+	}
+	if _, ok := store.redeem(ticket, "story-1"); ok {
+		t.Fatalf("expected a ticket tried on another story to be used up")
+	}
+
+	ticket, _ = store.issue("story-1", "ada")
+	now = now.Add(streamTicketTTL)
+	if _, ok := store.redeem(ticket, "story-1"); ok {
+		t.Fatalf("expected an expired ticket to be refused")
+	}
+	if _, ok := store.redeem("", "story-1"); ok {
+		t.Fatalf("expected an empty ticket to be refused")
+	}
+
+	store.issue("story-1", "ada")
+	if n := len(store.tickets); n != 1 {
+		t.Fatalf("expected expired tickets to be purged, %d remain", n)
+	}
+}
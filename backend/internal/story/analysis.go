@@ -0,0 +1,899 @@
+package story
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// variableFamilies maps Block.Language values onto the sessions whose variables outlive a block.
+// Blocks in other languages run in a fresh process each time, so they only depend on other blocks
+// through declared dependencies.
+var variableFamilies = map[string]string{
+	"python":  "python",
+	"python3": "python",
+	"py":      "python",
+	"sh":      "sh",
+	"shell":   "sh",
+	"bash":    "bash",
+}
+
+// variableFamily returns the session a block's variables live in, or "" when they do not outlive
+// the block.
+func variableFamily(language string) string {
+	return variableFamilies[strings.ToLower(language)]
+}
+
+// analyse returns the names a block defines for later blocks and the names it reads before
+// defining them itself. The analysis is a heuristic: it recognises the common ways of binding a
+// name rather than parsing the language.
+func analyse(family, source string) (defines, uses []string) {
+	switch family {
+	case "python":
+		a := newPythonAnalysis()
+		for _, stmt := range pythonStatements(source) {
+			a.statement(stmt)
+		}
+		return a.result()
+	case "sh", "bash":
+		return analyseShell(source)
+	}
+	return nil, nil
+}
+
+// names tracks a set of names together with the order they were first added in.
+type names struct {
+	seen  map[string]bool
+	order []string
+}
+
+func (n *names) add(name string) {
+	if n.seen == nil {
+		n.seen = make(map[string]bool)
+	}
+	if !n.seen[name] {
+		n.seen[name] = true
+		n.order = append(n.order, name)
+	}
+}
+
+func (n *names) has(name string) bool { return n.seen[name] }
+
+func (n *names) sorted() []string {
+	if len(n.order) == 0 {
+		return nil
+	}
+	out := append([]string(nil), n.order...)
+	sort.Strings(out)
+	return out
+}
+
+// Python
+
+type pyTokenKind int
+
+const (
+	pyName pyTokenKind = iota
+	pyNumber
+	pyOp
+)
+
+type pyToken struct {
+	kind pyTokenKind
+	text string
+}
+
+func (t pyToken) is(text string) bool { return t.kind == pyOp && t.text == text }
+
+// pyStatement is one simple statement. Compound statement bodies written after the colon on the
+// same line get an indent one deeper than their header.
+type pyStatement struct {
+	indent int
+	tokens []pyToken
+}
+
+var pyOperators = []string{
+	"**=", "//=", ">>=", "<<=", "...",
+	"->", ":=", "==", "!=", "<=", ">=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "@=", "**", "//", "<<", ">>",
+}
+
+var pyKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true, "async": true,
+	"await": true, "break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+var pyCompound = map[string]bool{
+	"if": true, "elif": true, "else": true, "for": true, "while": true, "with": true, "try": true,
+	"except": true, "finally": true, "def": true, "class": true, "async": true,
+}
+
+// pythonStatements splits source into statements, dropping comments and string literals. The
+// expressions inside f-string braces are kept, bracketed so they read as a nested expression.
+func pythonStatements(source string) []pyStatement {
+	var (
+		stmts   []pyStatement
+		current pyStatement
+		depth   int
+		atStart = true
+	)
+	flush := func() {
+		if len(current.tokens) > 0 {
+			stmts = append(stmts, splitCompound(current)...)
+		}
+		current = pyStatement{indent: current.indent}
+	}
+	src := []rune(source)
+	for i := 0; i < len(src); {
+		if atStart && depth == 0 {
+			indent := 0
+			for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+				if src[i] == '\t' {
+					indent += 4
+				} else {
+					indent++
+				}
+				i++
+			}
+			if i < len(src) && src[i] != '\n' && src[i] != '\r' && src[i] != '#' {
+				current.indent = indent
+				atStart = false
+			}
+			if i >= len(src) {
+				break
+			}
+		}
+		c := src[i]
+		switch {
+		case c == '\n':
+			if depth == 0 {
+				flush()
+				atStart = true
+			}
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			i += 2
+		case c == ';' && depth == 0:
+			flush()
+			i++
+		case c == '"' || c == '\'':
+			var inner []pyToken
+			i, inner = pyString(src, i, "")
+			current.tokens = append(current.tokens, inner...)
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(src[i]) || unicode.IsDigit(src[i])) {
+				i++
+			}
+			word := string(src[start:i])
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') && isStringPrefix(word) {
+				var inner []pyToken
+				i, inner = pyString(src, i, word)
+				current.tokens = append(current.tokens, inner...)
+				continue
+			}
+			current.tokens = append(current.tokens, pyToken{kind: pyName, text: word})
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(src[i+1])):
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || unicode.IsLetter(src[i]) || unicode.IsDigit(src[i])) {
+				i++
+			}
+			current.tokens = append(current.tokens, pyToken{kind: pyNumber, text: string(src[start:i])})
+		case unicode.IsSpace(c):
+			i++
+		default:
+			op := string(c)
+			for _, candidate := range pyOperators {
+				if strings.HasPrefix(string(src[i:min(i+len(candidate), len(src))]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			switch op {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth > 0 {
+					depth--
+				}
+			}
+			current.tokens = append(current.tokens, pyToken{kind: pyOp, text: op})
+			i += len([]rune(op))
+		}
+	}
+	flush()
+	return stmts
+}
+
+func isStringPrefix(word string) bool {
+	if len(word) > 2 {
+		return false
+	}
+	for _, r := range strings.ToLower(word) {
+		if r != 'r' && r != 'b' && r != 'f' && r != 'u' {
+			return false
+		}
+	}
+	return true
+}
+
+// pyString skips the string literal starting at src[i] and returns the index after it together
+// with the tokens of any f-string replacement fields.
+func pyString(src []rune, i int, prefix string) (int, []pyToken) {
+	prefix = strings.ToLower(prefix)
+	raw := strings.Contains(prefix, "r")
+	format := strings.Contains(prefix, "f")
+	quote := src[i]
+	triple := i+2 < len(src) && src[i+1] == quote && src[i+2] == quote
+	if triple {
+		i += 3
+	} else {
+		i++
+	}
+	var tokens []pyToken
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\\' && !raw:
+			i += 2
+			continue
+		case c == '\n' && !triple:
+			return i, tokens
+		case c == quote && (!triple || (i+2 < len(src) && src[i+1] == quote && src[i+2] == quote)):
+			if triple {
+				return i + 3, tokens
+			}
+			return i + 1, tokens
+		case format && c == '{':
+			if i+1 < len(src) && src[i+1] == '{' {
+				i += 2
+				continue
+			}
+			end, expr := fieldExpression(src, i+1)
+			tokens = append(tokens, pyToken{kind: pyOp, text: "("})
+			for _, stmt := range pythonStatements(expr) {
+				tokens = append(tokens, stmt.tokens...)
+			}
+			tokens = append(tokens, pyToken{kind: pyOp, text: ")"})
+			i = end
+			continue
+		}
+		i++
+	}
+	return i, tokens
+}
+
+// fieldExpression returns the expression of the f-string replacement field starting at src[i],
+// without its conversion or format spec, and the index after the closing brace.
+func fieldExpression(src []rune, i int) (int, string) {
+	start, depth, end := i, 0, -1
+	for ; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case (c == ')' || c == ']') && depth > 0:
+			depth--
+		case c == '}':
+			if depth == 0 {
+				if end == -1 {
+					end = i
+				}
+				return i + 1, string(src[start:end])
+			}
+			depth--
+		case (c == '!' || c == ':') && depth == 0 && end == -1 && !(c == '!' && i+1 < len(src) && src[i+1] == '='):
+			end = i
+		}
+	}
+	if end == -1 {
+		end = len(src)
+	}
+	return i, string(src[start:end])
+}
+
+// splitCompound separates a compound statement's header from a body written on the same line.
+func splitCompound(stmt pyStatement) []pyStatement {
+	if len(stmt.tokens) == 0 || stmt.tokens[0].kind != pyName || !pyCompound[stmt.tokens[0].text] {
+		return []pyStatement{stmt}
+	}
+	depth := 0
+	for i, tok := range stmt.tokens {
+		switch {
+		case tok.is("(") || tok.is("[") || tok.is("{"):
+			depth++
+		case tok.is(")") || tok.is("]") || tok.is("}"):
+			depth--
+		case tok.kind == pyName && tok.text == "lambda":
+			return []pyStatement{stmt}
+		case tok.is(":") && depth == 0:
+			head := pyStatement{indent: stmt.indent, tokens: stmt.tokens[:i]}
+			if i+1 == len(stmt.tokens) {
+				return []pyStatement{head}
+			}
+			body := pyStatement{indent: stmt.indent + 1, tokens: stmt.tokens[i+1:]}
+			return append([]pyStatement{head}, splitCompound(body)...)
+		}
+	}
+	return []pyStatement{stmt}
+}
+
+// pyScope is a function or class body. Names it reads but does not bind are passed to the
+// enclosing scope when the body ends.
+type pyScope struct {
+	indent  int
+	locals  map[string]bool
+	globals map[string]bool
+	reads   names
+}
+
+type pythonAnalysis struct {
+	defines names
+	uses    names
+	// deferred collects names read inside function bodies, which only need to exist by the time
+	// the function is called.
+	deferred names
+	scopes   []*pyScope
+}
+
+func newPythonAnalysis() *pythonAnalysis {
+	return &pythonAnalysis{}
+}
+
+func (a *pythonAnalysis) statement(stmt pyStatement) {
+	for len(a.scopes) > 0 && a.scopes[len(a.scopes)-1].indent >= stmt.indent {
+		a.pop()
+	}
+	if first := stmt.tokens[0]; first.kind == pyName && first.text == "global" && len(a.scopes) > 0 {
+		top := a.scopes[len(a.scopes)-1]
+		for _, tok := range stmt.tokens[1:] {
+			if tok.kind == pyName {
+				top.globals[tok.text] = true
+			}
+		}
+		return
+	}
+	reads, binds, scope := pyStatementNames(stmt.tokens)
+	if len(a.scopes) == 0 {
+		for _, name := range reads {
+			if !a.defines.has(name) {
+				a.uses.add(name)
+			}
+		}
+		for _, name := range binds {
+			a.defines.add(name)
+		}
+	} else {
+		top := a.scopes[len(a.scopes)-1]
+		for _, name := range reads {
+			top.reads.add(name)
+		}
+		for _, name := range binds {
+			if a.declaredGlobal(name) {
+				a.defines.add(name)
+			} else {
+				top.locals[name] = true
+			}
+		}
+	}
+	if scope != nil {
+		scope.indent = stmt.indent
+		a.scopes = append(a.scopes, scope)
+	}
+}
+
+func (a *pythonAnalysis) declaredGlobal(name string) bool {
+	for _, scope := range a.scopes {
+		if scope.globals[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *pythonAnalysis) pop() {
+	scope := a.scopes[len(a.scopes)-1]
+	a.scopes = a.scopes[:len(a.scopes)-1]
+	for _, name := range scope.reads.order {
+		if scope.locals[name] {
+			continue
+		}
+		if len(a.scopes) > 0 {
+			a.scopes[len(a.scopes)-1].reads.add(name)
+		} else {
+			a.deferred.add(name)
+		}
+	}
+}
+
+func (a *pythonAnalysis) result() (defines, uses []string) {
+	for len(a.scopes) > 0 {
+		a.pop()
+	}
+	for _, name := range a.deferred.order {
+		if !a.defines.has(name) {
+			a.uses.add(name)
+		}
+	}
+	return a.defines.sorted(), a.uses.sorted()
+}
+
+// pyStatementNames returns the names a statement reads, the names it binds in the current scope
+// and, for def and class statements, the scope their body opens.
+func pyStatementNames(tokens []pyToken) (reads, binds []string, scope *pyScope) {
+	first := tokens[0]
+	if first.is("async") && len(tokens) > 1 {
+		tokens = tokens[1:]
+		first = tokens[0]
+	}
+	if first.kind == pyName {
+		switch first.text {
+		case "import":
+			for _, item := range splitTop(tokens[1:], ",") {
+				if alias := afterKeyword(item, "as"); alias != "" {
+					binds = append(binds, alias)
+				} else if len(item) > 0 && item[0].kind == pyName {
+					binds = append(binds, item[0].text)
+				}
+			}
+			return nil, binds, nil
+		case "from":
+			for i, tok := range tokens {
+				if tok.kind == pyName && tok.text == "import" {
+					for _, item := range splitTop(stripParens(tokens[i+1:]), ",") {
+						if alias := afterKeyword(item, "as"); alias != "" {
+							binds = append(binds, alias)
+						} else if len(item) > 0 && item[0].kind == pyName {
+							binds = append(binds, item[0].text)
+						}
+					}
+					break
+				}
+			}
+			return nil, binds, nil
+		case "def":
+			return pyFunction(tokens)
+		case "class":
+			scope = &pyScope{locals: map[string]bool{}, globals: map[string]bool{}}
+			if len(tokens) > 1 && tokens[1].kind == pyName {
+				binds = append(binds, tokens[1].text)
+				reads, _ = pyExpression(tokens[2:])
+			}
+			return reads, binds, scope
+		case "for":
+			for i, tok := range tokens {
+				if tok.kind == pyName && tok.text == "in" {
+					targetReads, targetBinds := pyTargets(tokens[1:i])
+					reads, binds = pyExpression(tokens[i+1:])
+					return append(reads, targetReads...), append(binds, targetBinds...), nil
+				}
+			}
+		case "with", "except":
+			return pyAliases(tokens[1:])
+		case "global", "nonlocal":
+			return nil, nil, nil
+		}
+	}
+	if first.is("@") {
+		reads, binds = pyExpression(tokens[1:])
+		return reads, binds, nil
+	}
+	return pyAssignment(tokens)
+}
+
+// pyFunction handles a def header: the name is bound in the current scope, parameters in the new
+// one, and defaults and annotations are read in the current scope.
+func pyFunction(tokens []pyToken) (reads, binds []string, scope *pyScope) {
+	scope = &pyScope{locals: map[string]bool{}, globals: map[string]bool{}}
+	if len(tokens) < 3 || tokens[1].kind != pyName || !tokens[2].is("(") {
+		return nil, nil, scope
+	}
+	binds = []string{tokens[1].text}
+	var expr []pyToken
+	depth, expectName := 1, true
+	i := 3
+	for ; i < len(tokens) && depth > 0; i++ {
+		tok := tokens[i]
+		switch {
+		case tok.is("(") || tok.is("[") || tok.is("{"):
+			depth++
+		case tok.is(")") || tok.is("]") || tok.is("}"):
+			depth--
+		case depth == 1 && tok.is(","):
+			expectName = true
+		case depth == 1 && (tok.is("*") || tok.is("**") || tok.is("/")):
+			continue
+		case depth == 1 && expectName && tok.kind == pyName:
+			scope.locals[tok.text] = true
+			expectName = false
+			continue
+		}
+		expr = append(expr, tok)
+	}
+	// Whatever follows the parameters is the return annotation.
+	expr = append(expr, tokens[i:]...)
+	reads, _ = pyExpression(expr)
+	return reads, binds, scope
+}
+
+// pyAliases handles with and except headers, binding the names after each "as".
+func pyAliases(tokens []pyToken) (reads, binds []string, scope *pyScope) {
+	var expr []pyToken
+	for _, item := range splitTop(stripParens(tokens), ",") {
+		target := -1
+		for i, tok := range item {
+			if tok.kind == pyName && tok.text == "as" {
+				target = i
+				break
+			}
+		}
+		if target == -1 {
+			expr = append(expr, item...)
+		} else {
+			expr = append(expr, item[:target]...)
+			targetReads, targetBinds := pyTargets(item[target+1:])
+			reads = append(reads, targetReads...)
+			binds = append(binds, targetBinds...)
+		}
+		expr = append(expr, pyToken{kind: pyOp, text: ","})
+	}
+	exprReads, exprBinds := pyExpression(expr)
+	return append(exprReads, reads...), append(exprBinds, binds...), nil
+}
+
+var pyAugmented = map[string]bool{
+	"+=": true, "-=": true, "*=": true, "/=": true, "//=": true, "%=": true, "**=": true,
+	">>=": true, "<<=": true, "&=": true, "|=": true, "^=": true, "@=": true,
+}
+
+// pyAssignment handles plain, augmented and annotated assignments and bare expressions.
+func pyAssignment(tokens []pyToken) (reads, binds []string, scope *pyScope) {
+	depth := 0
+annotation:
+	for i, tok := range tokens {
+		switch {
+		case tok.kind == pyName && tok.text == "lambda":
+			// The colon after lambda parameters does not start an annotation.
+			break annotation
+		case tok.is("(") || tok.is("[") || tok.is("{"):
+			depth++
+		case tok.is(")") || tok.is("]") || tok.is("}"):
+			depth--
+		case depth == 0 && tok.kind == pyOp && pyAugmented[tok.text]:
+			targetReads, targetBinds := pyTargets(tokens[:i])
+			reads, binds = pyExpression(tokens[i+1:])
+			reads = append(reads, targetReads...)
+			reads = append(reads, targetBinds...)
+			return reads, append(binds, targetBinds...), nil
+		case depth == 0 && tok.is(":"):
+			rest := tokens[i+1:]
+			value := -1
+			for j, t := range rest {
+				if t.is("=") {
+					value = j
+					break
+				}
+			}
+			if value == -1 {
+				reads, binds = pyExpression(rest)
+				return reads, binds, nil
+			}
+			targetReads, targetBinds := pyTargets(tokens[:i])
+			reads, binds = pyExpression(rest[:value])
+			valueReads, valueBinds := pyExpression(rest[value+1:])
+			reads = append(append(reads, valueReads...), targetReads...)
+			return reads, append(append(binds, valueBinds...), targetBinds...), nil
+		}
+	}
+	parts := splitTop(tokens, "=")
+	reads, binds = pyExpression(parts[len(parts)-1])
+	for _, target := range parts[:len(parts)-1] {
+		targetReads, targetBinds := pyTargets(target)
+		reads = append(reads, targetReads...)
+		binds = append(binds, targetBinds...)
+	}
+	return reads, binds, nil
+}
+
+// pyTargets returns the names an assignment target binds and the names it reads: the objects
+// behind attributes and subscripts, and the subscripts themselves.
+func pyTargets(tokens []pyToken) (reads, binds []string) {
+	var subscript []bool
+	inSubscript := func() bool {
+		for _, s := range subscript {
+			if s {
+				return true
+			}
+		}
+		return false
+	}
+	var indexTokens []pyToken
+	for i, tok := range tokens {
+		var prev, next pyToken
+		if i > 0 {
+			prev = tokens[i-1]
+		}
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		switch {
+		case tok.is("[") || tok.is("("):
+			isSubscript := inSubscript() || (i > 0 && (prev.kind == pyName || prev.is(")") || prev.is("]")))
+			subscript = append(subscript, isSubscript)
+		case tok.is("]") || tok.is(")"):
+			if len(subscript) > 0 {
+				subscript = subscript[:len(subscript)-1]
+			}
+		case tok.kind != pyName || pyKeywords[tok.text]:
+		case inSubscript():
+			indexTokens = append(indexTokens, tok)
+		case prev.is("."):
+		case next.is(".") || next.is("[") || next.is("("):
+			reads = append(reads, tok.text)
+		default:
+			binds = append(binds, tok.text)
+		}
+		if inSubscript() && tok.kind != pyName {
+			indexTokens = append(indexTokens, tok)
+		}
+	}
+	indexReads, _ := pyExpression(indexTokens)
+	return append(reads, indexReads...), binds
+}
+
+// pyExpression returns the names an expression reads and the names its walrus operators bind.
+// Comprehension and lambda variables are local to the expression and left out.
+func pyExpression(tokens []pyToken) (reads, binds []string) {
+	local := map[string]bool{}
+	for i, tok := range tokens {
+		if tok.kind != pyName {
+			continue
+		}
+		switch tok.text {
+		case "for":
+			for j := i + 1; j < len(tokens); j++ {
+				if tokens[j].kind == pyName && tokens[j].text == "in" {
+					break
+				}
+				if tokens[j].kind == pyName {
+					local[tokens[j].text] = true
+				}
+			}
+		case "lambda":
+			for j := i + 1; j < len(tokens) && !tokens[j].is(":"); j++ {
+				if tokens[j].kind == pyName {
+					local[tokens[j].text] = true
+				}
+			}
+		}
+	}
+	depth := 0
+	for i, tok := range tokens {
+		switch {
+		case tok.is("(") || tok.is("[") || tok.is("{"):
+			depth++
+			continue
+		case tok.is(")") || tok.is("]") || tok.is("}"):
+			depth--
+			continue
+		}
+		if tok.kind != pyName || pyKeywords[tok.text] || local[tok.text] {
+			continue
+		}
+		if i > 0 && tokens[i-1].is(".") {
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].is(":=") {
+			binds = append(binds, tok.text)
+			continue
+		}
+		// Keyword arguments name a parameter of the callee, not a variable.
+		if depth > 0 && i+1 < len(tokens) && tokens[i+1].is("=") && i > 0 && (tokens[i-1].is("(") || tokens[i-1].is(",")) {
+			continue
+		}
+		reads = append(reads, tok.text)
+	}
+	return reads, binds
+}
+
+// splitTop splits tokens on sep outside brackets.
+func splitTop(tokens []pyToken, sep string) [][]pyToken {
+	var (
+		parts [][]pyToken
+		depth int
+		start int
+	)
+	for i, tok := range tokens {
+		switch {
+		case tok.is("(") || tok.is("[") || tok.is("{"):
+			depth++
+		case tok.is(")") || tok.is("]") || tok.is("}"):
+			depth--
+		case depth == 0 && tok.is(sep):
+			parts = append(parts, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+func stripParens(tokens []pyToken) []pyToken {
+	if len(tokens) >= 2 && tokens[0].is("(") && tokens[len(tokens)-1].is(")") {
+		return tokens[1 : len(tokens)-1]
+	}
+	return tokens
+}
+
+func afterKeyword(tokens []pyToken, keyword string) string {
+	for i, tok := range tokens {
+		if tok.kind == pyName && tok.text == keyword && i+1 < len(tokens) && tokens[i+1].kind == pyName {
+			return tokens[i+1].text
+		}
+	}
+	return ""
+}
+
+// Shell
+
+var (
+	shellExpansion  = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+	shellAssignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(\[[^\]]*\])?\+?=`)
+	shellIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	shellFunction   = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\(\)`)
+)
+
+// shellLeading lists words that may precede the command word of a simple command.
+var shellLeading = map[string]bool{
+	"then": true, "do": true, "else": true, "{": true, "(": true, "!": true,
+	"if": true, "elif": true, "while": true, "until": true, "time": true, "done": true, "fi": true,
+	"esac": true,
+}
+
+// shellReadOptions lists read flags that take an argument.
+var shellReadOptions = map[string]bool{"-p": true, "-d": true, "-n": true, "-N": true, "-t": true, "-u": true, "-i": true}
+
+// analyseShell finds variables and functions defined by a shell block and the ones it expands or
+// calls before defining them.
+func analyseShell(source string) (defines, uses []string) {
+	var def, use names
+	for _, command := range shellCommands(source) {
+		for _, m := range shellExpansion.FindAllStringSubmatch(command, -1) {
+			if !def.has(m[1]) {
+				use.add(m[1])
+			}
+		}
+		fields := strings.Fields(command)
+		for len(fields) > 0 && shellLeading[fields[0]] {
+			fields = fields[1:]
+		}
+		var assigned []string
+		for len(fields) > 0 {
+			m := shellAssignment.FindStringSubmatch(fields[0])
+			if m == nil {
+				break
+			}
+			assigned = append(assigned, m[1])
+			fields = fields[1:]
+		}
+		// Assignments define variables when they stand alone; before a command word they only
+		// set its environment.
+		if len(fields) == 0 {
+			for _, name := range assigned {
+				def.add(name)
+			}
+			continue
+		}
+		switch word := fields[0]; {
+		case word == "export" || word == "local" || word == "readonly" || word == "declare" || word == "typeset":
+			for _, field := range fields[1:] {
+				if strings.HasPrefix(field, "-") {
+					continue
+				}
+				if name, _, _ := strings.Cut(field, "="); shellIdentifier.MatchString(name) {
+					def.add(name)
+				}
+			}
+		case word == "read":
+			for i := 1; i < len(fields); i++ {
+				switch field := fields[i]; {
+				case field == "-a" && i+1 < len(fields):
+					def.add(fields[i+1])
+					i++
+				case shellReadOptions[field]:
+					i++
+				case strings.HasPrefix(field, "-"):
+				case shellIdentifier.MatchString(field):
+					def.add(field)
+				}
+			}
+		case word == "for" && len(fields) > 1 && shellIdentifier.MatchString(fields[1]):
+			def.add(fields[1])
+		case word == "function" && len(fields) > 1:
+			def.add(strings.TrimSuffix(fields[1], "()"))
+		case shellFunction.MatchString(word):
+			def.add(shellFunction.FindStringSubmatch(word)[1])
+		case len(fields) > 1 && fields[1] == "()" && shellIdentifier.MatchString(word):
+			def.add(word)
+		case shellIdentifier.MatchString(word) && !def.has(word):
+			// A command word may call a function another block defined.
+			use.add(word)
+		}
+	}
+	return def.sorted(), use.sorted()
+}
+
+// shellCommands splits source into simple commands with comments removed and single-quoted text
+// blanked, since nothing inside single quotes is expanded.
+func shellCommands(source string) []string {
+	var (
+		commands []string
+		current  strings.Builder
+		quote    rune
+	)
+	flush := func() {
+		if text := strings.TrimSpace(current.String()); text != "" {
+			commands = append(commands, text)
+		}
+		current.Reset()
+	}
+	src := []rune(source)
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+				current.WriteRune(c)
+			} else {
+				current.WriteRune(' ')
+			}
+		case quote == '"':
+			if c == '\\' && i+1 < len(src) {
+				current.WriteRune(c)
+				i++
+				current.WriteRune(src[i])
+				continue
+			}
+			if c == '"' {
+				quote = 0
+			}
+			current.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			current.WriteRune(c)
+		case c == '\\' && i+1 < len(src):
+			i++
+			if src[i] != '\n' {
+				current.WriteRune(src[i])
+			}
+		case c == '#' && (i == 0 || unicode.IsSpace(src[i-1])):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			flush()
+		case c == '\n' || c == ';' || c == '|' || c == '&':
+			flush()
+		case c == '$' && i+1 < len(src) && src[i+1] == '{':
+			for ; i < len(src) && src[i] != '}'; i++ {
+				current.WriteRune(src[i])
+			}
+			current.WriteRune('}')
+		case c == '(' && i+1 < len(src) && src[i+1] == ')':
+			current.WriteString("()")
+			i++
+		case c == '(' || c == ')' || c == '{' || c == '}':
+			// Treat grouping as a command boundary so the word after it reads as a command.
+			flush()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+	return commands
+}
@@ -0,0 +1,115 @@
+package story
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyse(t *testing.T) {
+	cases := []struct {
+		name    string
+		family  string
+		source  string
+		defines []string
+		uses    []string
+	}{
+		{
+			name:    "python assignment",
+			family:  "python",
+			source:  "x = 1\ny = x + z",
+			defines: []string{"x", "y"},
+			uses:    []string{"z"},
+		},
+		{
+			name:    "python augmented and annotated assignment",
+			family:  "python",
+			source:  "total += step\ncount: int = 0",
+			defines: []string{"count", "total"},
+			uses:    []string{"int", "step", "total"},
+		},
+		{
+			name:    "python unpacking, subscripts and attributes",
+			family:  "python",
+			source:  "a, (b, c) = pair\nitems[key] = value\nobj.attr = other",
+			defines: []string{"a", "b", "c"},
+			uses:    []string{"items", "key", "obj", "other", "pair", "value"},
+		},
+		{
+			name:    "python imports",
+			family:  "python",
+			source:  "import numpy as np\nimport os.path\nfrom math import pi, tau as t",
+			defines: []string{"np", "os", "pi", "t"},
+		},
+		{
+			name:    "python comprehension variables stay local",
+			family:  "python",
+			source:  "squares = [n * n for n in values]\nlookup = {k: v for k, v in pairs if k != skip}\nprint(n)",
+			defines: []string{"lookup", "squares"},
+			uses:    []string{"n", "pairs", "print", "skip", "values"},
+		},
+		{
+			name:    "python strings and comments",
+			family:  "python",
+			source:  "s = 'quoted' + \"{braces}\"\nf = f\"{name}!\"  # hidden\n'''\nq = 1\n'''",
+			defines: []string{"f", "s"},
+			uses:    []string{"name"},
+		},
+		{
+			name:    "python functions and classes",
+			family:  "python",
+			source:  "def area(r):\n    return pi * r * r\n\nclass Shape:\n    sides = n\nresult = area(radius)",
+			defines: []string{"Shape", "area", "result"},
+			uses:    []string{"n", "pi", "radius"},
+		},
+		{
+			name:    "python compound statements",
+			family:  "python",
+			source:  "for i in range(count):\n    acc = acc + i\nwith open(path) as fh:\n    data = fh.read()\nif (m := pattern.match(line)):\n    pass",
+			defines: []string{"acc", "data", "fh", "i", "m"},
+			uses:    []string{"acc", "count", "line", "open", "path", "pattern", "range"},
+		},
+		{
+			name:    "shell export and expansion",
+			family:  "sh",
+			source:  "export NAME=value OTHER\nreadonly R=1\necho \"$NAME ${OTHER} $UNSET\"",
+			defines: []string{"NAME", "OTHER", "R"},
+			uses:    []string{"UNSET", "echo"},
+		},
+		{
+			name:   "shell quotes and comments",
+			family: "sh",
+			source: "echo '$QUOTED' # $COMMENTED\necho \"$DOUBLE\"",
+			uses:   []string{"DOUBLE", "echo"},
+		},
+		{
+			name:    "shell assignments, read and for",
+			family:  "bash",
+			source:  "X=1\nY=\"$X$Z\" cmd\nread -r -p prompt line rest\nfor f in *.txt; do echo $f; done",
+			defines: []string{"X", "f", "line", "rest"},
+			uses:    []string{"Z", "cmd", "echo"},
+		},
+		{
+			name:    "shell functions",
+			family:  "sh",
+			source:  "greet() { echo hi; }\nfunction bye { echo bye; }\ngreet\nhelper arg",
+			defines: []string{"bye", "greet"},
+			uses:    []string{"echo", "helper"},
+		},
+		{
+			name:   "other languages are not analysed",
+			family: "",
+			source: "x = y",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			defines, uses := analyse(tc.family, tc.source)
+			if !reflect.DeepEqual(defines, tc.defines) {
+				t.Fatalf("expected defines %q, got %q", tc.defines, defines)
+			}
+			if !reflect.DeepEqual(uses, tc.uses) {
+				t.Fatalf("expected uses %q, got %q", tc.uses, uses)
+			}
+		})
+	}
+}
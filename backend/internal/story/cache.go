@@ -0,0 +1,97 @@
+package story
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// OutputCache stores the outputs of code blocks that ran successfully, keyed by BlockKeys.
+type OutputCache interface {
+	Get(key string) ([]Output, bool)
+	Put(key string, outputs []Output)
+}
+
+// BlockKeys returns a cache key for every code block. A key hashes the block's language and
+// source together with the key of the code block before it, so editing a block invalidates it and
+// everything after it while edits to markdown invalidate nothing. The run's parameter values seed
+// the chain, so each set of values is cached separately.
+func BlockKeys(blocks []Block, params map[string]interface{}) map[string]string {
+	ordered := append([]Block(nil), blocks...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Position < ordered[j].Position })
+	keys := make(map[string]string, len(ordered))
+	previous := ""
+	if len(params) > 0 {
+		// json.Marshal sorts map keys, so equal values always hash the same.
+		encoded, _ := json.Marshal(params)
+		sum := sha256.Sum256(encoded)
+		previous = hex.EncodeToString(sum[:])
+	}
+	for _, block := range ordered {
+		if block.Type != BlockCode {
+			continue
+		}
+		h := sha256.New()
+		for _, part := range []string{previous, block.Language, block.Source} {
+			h.Write([]byte(part))
+			// A separator keeps ("ab", "c") and ("a", "bc") apart.
+			h.Write([]byte{0})
+		}
+		previous = hex.EncodeToString(h.Sum(nil))
+		keys[block.ID] = previous
+	}
+	return keys
+}
+
+type memoryOutputCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	outputs []Output
+}
+
+// NewMemoryOutputCache returns an OutputCache holding up to max entries, evicting the least
+// recently used first.
+func NewMemoryOutputCache(max int) OutputCache {
+	return &memoryOutputCache{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryOutputCache) Get(key string) ([]Output, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return append([]Output(nil), el.Value.(*cacheEntry).outputs...), true
+}
+
+func (c *memoryOutputCache) Put(key string, outputs []Output) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	outputs = append([]Output(nil), outputs...)
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).outputs = outputs
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, outputs: outputs})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
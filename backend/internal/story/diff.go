@@ -0,0 +1,263 @@
+package story
+
+import "strings"
+
+// BlockChange classifies how a block differs between two revisions.
+type BlockChange string
+
+const (
+	BlockAdded    BlockChange = "added"
+	BlockRemoved  BlockChange = "removed"
+	BlockModified BlockChange = "modified"
+	BlockMoved    BlockChange = "moved"
+)
+
+// LineOp marks a line in a text diff as kept, inserted or deleted.
+type LineOp string
+
+const (
+	LineEqual  LineOp = "equal"
+	LineInsert LineOp = "insert"
+	LineDelete LineOp = "delete"
+)
+
+// LineChange is a single line of a text diff.
+type LineChange struct {
+	Op   LineOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// BlockDiff describes a block that was added, removed, edited or reordered.
+type BlockDiff struct {
+	BlockID      string       `json:"blockId"`
+	Change       BlockChange  `json:"change"`
+	Moved        bool         `json:"moved"`
+	Fields       []string     `json:"fields,omitempty"`
+	FromPosition *int         `json:"fromPosition,omitempty"`
+	ToPosition   *int         `json:"toPosition,omitempty"`
+	Source       []LineChange `json:"source,omitempty"`
+	Outputs      []LineChange `json:"outputs,omitempty"`
+}
+
+// RevisionDiff lists the block-level changes needed to go from one revision to another.
+type RevisionDiff struct {
+	StoryID string      `json:"storyId"`
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Blocks  []BlockDiff `json:"blocks"`
+}
+
+// DiffRevisions compares two revisions block by block. Blocks are matched by ID; a block
+// counts as moved when its order relative to the other surviving blocks changed.
+func DiffRevisions(from, to Revision) RevisionDiff {
+	diff := RevisionDiff{StoryID: to.StoryID, From: from.ID, To: to.ID, Blocks: []BlockDiff{}}
+
+	fromIdx := make(map[string]int, len(from.Blocks))
+	for i, block := range from.Blocks {
+		fromIdx[block.ID] = i
+	}
+	toIdx := make(map[string]int, len(to.Blocks))
+	for i, block := range to.Blocks {
+		toIdx[block.ID] = i
+	}
+
+	var fromKept, toKept []string
+	for _, block := range from.Blocks {
+		if _, ok := toIdx[block.ID]; ok {
+			fromKept = append(fromKept, block.ID)
+		}
+	}
+	for _, block := range to.Blocks {
+		if _, ok := fromIdx[block.ID]; ok {
+			toKept = append(toKept, block.ID)
+		}
+	}
+	inOrder := make(map[string]bool, len(toKept))
+	for _, pair := range lcs(fromKept, toKept) {
+		inOrder[toKept[pair[1]]] = true
+	}
+
+	for i, block := range to.Blocks {
+		toPos := i
+		j, ok := fromIdx[block.ID]
+		if !ok {
+			diff.Blocks = append(diff.Blocks, BlockDiff{
+				BlockID:    block.ID,
+				Change:     BlockAdded,
+				ToPosition: &toPos,
+				Source:     diffLines("", block.Source),
+				Outputs:    diffLines("", outputText(block.Outputs)),
+			})
+			continue
+		}
+		fromPos := j
+		prev := from.Blocks[j]
+		entry := BlockDiff{
+			BlockID:      block.ID,
+			Moved:        !inOrder[block.ID],
+			Fields:       changedFields(prev, block),
+			FromPosition: &fromPos,
+			ToPosition:   &toPos,
+		}
+		switch {
+		case len(entry.Fields) > 0:
+			entry.Change = BlockModified
+		case entry.Moved:
+			entry.Change = BlockMoved
+		default:
+			continue
+		}
+		if prev.Source != block.Source {
+			entry.Source = diffLines(prev.Source, block.Source)
+		}
+		if prevOut, out := outputText(prev.Outputs), outputText(block.Outputs); prevOut != out {
+			entry.Outputs = diffLines(prevOut, out)
+		}
+		diff.Blocks = append(diff.Blocks, entry)
+	}
+
+	for i, block := range from.Blocks {
+		if _, ok := toIdx[block.ID]; ok {
+			continue
+		}
+		fromPos := i
+		diff.Blocks = append(diff.Blocks, BlockDiff{
+			BlockID:      block.ID,
+			Change:       BlockRemoved,
+			FromPosition: &fromPos,
+			Source:       diffLines(block.Source, ""),
+			Outputs:      diffLines(outputText(block.Outputs), ""),
+		})
+	}
+	return diff
+}
+
+func changedFields(a, b Block) []string {
+	var fields []string
+	if a.Type != b.Type {
+		fields = append(fields, "type")
+	}
+	if a.Language != b.Language {
+		fields = append(fields, "language")
+	}
+	if a.Source != b.Source {
+		fields = append(fields, "source")
+	}
+	if strings.Join(a.DependsOn, "\n") != strings.Join(b.DependsOn, "\n") {
+		fields = append(fields, "dependsOn")
+	}
+	if outputText(a.Outputs) != outputText(b.Outputs) {
+		fields = append(fields, "outputs")
+	}
+	return fields
+}
+
+// outputText flattens outputs into comparable text, one header line per output.
+func outputText(outputs []Output) string {
+	var sb strings.Builder
+	for _, out := range outputs {
+		sb.WriteString("[" + out.Kind + " " + out.MimeType + "]\n")
+		sb.WriteString(out.Data)
+		if !strings.HasSuffix(out.Data, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// diffLines produces a line-level diff; empty input on both sides yields no lines.
+func diffLines(a, b string) []LineChange {
+	aLines, bLines := splitLines(a), splitLines(b)
+	changes := make([]LineChange, 0, len(aLines)+len(bLines))
+	i, j := 0, 0
+	for _, pair := range lcs(aLines, bLines) {
+		for ; i < pair[0]; i++ {
+			changes = append(changes, LineChange{Op: LineDelete, Text: aLines[i]})
+		}
+		for ; j < pair[1]; j++ {
+			changes = append(changes, LineChange{Op: LineInsert, Text: bLines[j]})
+		}
+		changes = append(changes, LineChange{Op: LineEqual, Text: aLines[i]})
+		i++
+		j++
+	}
+	for ; i < len(aLines); i++ {
+		changes = append(changes, LineChange{Op: LineDelete, Text: aLines[i]})
+	}
+	for ; j < len(bLines); j++ {
+		changes = append(changes, LineChange{Op: LineInsert, Text: bLines[j]})
+	}
+	return changes
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// maxDiffCells bounds the table lcs fills in. When the lines that differ between the two sides
+// would need more, lcs gives up on them and they show as deleted and inserted whole, so a huge
+// source cannot exhaust memory.
+const maxDiffCells = 1 << 22
+
+// lcs returns index pairs of a longest common subsequence of a and b in ascending order. The
+// common prefix and suffix are matched directly, leaving the table for the part that changed.
+func lcs(a, b []string) [][2]int {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	pairs := make([][2]int, 0, prefix+suffix)
+	for i := 0; i < prefix; i++ {
+		pairs = append(pairs, [2]int{i, i})
+	}
+	for _, pair := range lcsTable(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		pairs = append(pairs, [2]int{pair[0] + prefix, pair[1] + prefix})
+	}
+	for k := suffix; k > 0; k-- {
+		pairs = append(pairs, [2]int{len(a) - k, len(b) - k})
+	}
+	return pairs
+}
+
+// lcsTable finds a longest common subsequence by dynamic programming, or returns nil when that
+// would take more than maxDiffCells cells.
+func lcsTable(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 || int64(n+1)*int64(m+1) > maxDiffCells {
+		return nil
+	}
+	width := m + 1
+	table := make([]int32, (n+1)*width)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*width+j] = table[(i+1)*width+j+1] + 1
+			} else if table[(i+1)*width+j] >= table[i*width+j+1] {
+				table[i*width+j] = table[(i+1)*width+j]
+			} else {
+				table[i*width+j] = table[i*width+j+1]
+			}
+		}
+	}
+	var pairs [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[i] == b[j]:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case table[(i+1)*width+j] >= table[i*width+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,102 @@
+package story_test
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/example/multistory/internal/story"
+)
+
+func revision(blocks ...story.Block) story.Revision {
+	return story.Revision{ID: "rev", StoryID: "s", Blocks: blocks}
+}
+
+// sourceDiff diffs one block's source between two revisions and renders it as -/+/space lines.
+func sourceDiff(t *testing.T, from, to string) []string {
+	t.Helper()
+	diff := story.DiffRevisions(
+		revision(story.Block{ID: "a", Type: story.BlockCode, Source: from}),
+		revision(story.Block{ID: "a", Type: story.BlockCode, Source: to}),
+	)
+	if from == to {
+		if len(diff.Blocks) != 0 {
+			t.Fatalf("expected no changes, got %+v", diff.Blocks)
+		}
+		return nil
+	}
+	if len(diff.Blocks) != 1 {
+		t.Fatalf("expected one changed block, got %+v", diff.Blocks)
+	}
+	var lines []string
+	for _, change := range diff.Blocks[0].Source {
+		prefix := " "
+		switch change.Op {
+		case story.LineDelete:
+			prefix = "-"
+		case story.LineInsert:
+			prefix = "+"
+		}
+		lines = append(lines, prefix+change.Text)
+	}
+	return lines
+}
+
+func TestDiffSource(t *testing.T) {
+	cases := []struct {
+		name     string
+		from, to string
+		want     []string
+	}{
+		{name: "added", from: "", to: "a\nb\n", want: []string{"+a", "+b"}},
+		{name: "removed", from: "a\nb", to: "", want: []string{"-a", "-b"}},
+		{name: "edited middle", from: "a\nb\nc", to: "a\nx\nc", want: []string{" a", "-b", "+x", " c"}},
+		{name: "inserted", from: "a\nc", to: "a\nb\nc", want: []string{" a", "+b", " c"}},
+		{name: "reordered", from: "a\nb\nc\nd", to: "b\na\nc\nd", want: []string{"-a", " b", "+a", " c", " d"}},
+		{name: "repeated lines", from: "x\nx\ny", to: "x\ny\nx", want: []string{" x", "-x", " y", "+x"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sourceDiff(t, tc.from, tc.to); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func numberedLines(n int, format string) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, format+"\n", i)
+	}
+	return sb.String()
+}
+
+func TestDiffLargeSource(t *testing.T) {
+	const n = 20000
+	original := numberedLines(n, "line %d")
+
+	// A small edit in a huge block keeps the surrounding lines matched.
+	edited := strings.Replace(original, "line 10000\n", "changed\n", 1)
+	lines := sourceDiff(t, original, edited)
+	if len(lines) != n+1 {
+		t.Fatalf("expected %d lines, got %d", n+1, len(lines))
+	}
+	if lines[10000] != "-line 10000" || lines[10001] != "+changed" || lines[9999] != " line 9999" || lines[10002] != " line 10001" {
+		t.Fatalf("expected only line 10000 to change, got %q", lines[9999:10003])
+	}
+
+	// Two unrelated huge sources must not need a table of lines × lines.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	lines = sourceDiff(t, original, numberedLines(n, "other %d"))
+	runtime.ReadMemStats(&after)
+	if len(lines) != 2*n || lines[0] != "-line 0" || lines[n] != "+other 0" {
+		t.Fatalf("expected every line to be deleted and inserted, got %d lines", len(lines))
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 256<<20 {
+		t.Fatalf("expected the diff to stay small, it allocated %d MB", allocated>>20)
+	}
+}
@@ -0,0 +1,287 @@
+package story
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Graph is the dependency graph over a story's code blocks. A block depends on the nearest
+// earlier block in the same session that defines a variable it reads, and on every block listed
+// in its DependsOn.
+type Graph struct {
+	Nodes  []GraphNode  `json:"nodes"`
+	Edges  []GraphEdge  `json:"edges"`
+	Issues []GraphIssue `json:"issues"`
+}
+
+// GraphNode describes one code block. Defines lists the variables the block leaves behind for
+// later blocks; Uses lists the ones it reads from parameters or other blocks.
+type GraphNode struct {
+	BlockID   string   `json:"blockId"`
+	Language  string   `json:"language,omitempty"`
+	Position  int      `json:"position"`
+	Defines   []string `json:"defines,omitempty"`
+	Uses      []string `json:"uses,omitempty"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// GraphEdge says To depends on From, through the variables in Names, because To declared the
+// dependency, or both.
+type GraphEdge struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Names    []string `json:"names,omitempty"`
+	Declared bool     `json:"declared,omitempty"`
+}
+
+// IssueKind classifies a problem with a story's dependency graph.
+type IssueKind string
+
+const (
+	// IssueCycle means the blocks in GraphIssue.Blocks depend on each other.
+	IssueCycle IssueKind = "cycle"
+	// IssueUndefined means a block reads a variable that only a later block defines, or declares
+	// a dependency on a block that is not a code block of the story.
+	IssueUndefined IssueKind = "undefined"
+	// IssueOrder means a block declares a dependency on a block that runs after it.
+	IssueOrder IssueKind = "order"
+)
+
+// GraphIssue is a validation error found while building the graph.
+type GraphIssue struct {
+	Kind    IssueKind `json:"kind"`
+	BlockID string    `json:"blockId"`
+	// Blocks lists every block in a cycle, in story order.
+	Blocks []string `json:"blocks,omitempty"`
+	// Name is the undefined variable or block ID.
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+}
+
+// BuildGraph analyses the code blocks and returns their dependency graph. Variables named after
+// one of params are taken to come from the parameter unless an earlier block redefines them.
+func BuildGraph(blocks []Block, params []Parameter) Graph {
+	ordered := append([]Block(nil), blocks...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Position < ordered[j].Position })
+
+	isParam := make(map[string]bool, len(params))
+	for _, param := range params {
+		isParam[param.Name] = true
+	}
+	graph := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}, Issues: []GraphIssue{}}
+	index := make(map[string]int)
+	var (
+		families []string
+		uses     [][]string
+	)
+	for _, block := range ordered {
+		if block.Type != BlockCode {
+			continue
+		}
+		family := variableFamily(block.Language)
+		defines, used := analyse(family, block.Source)
+		index[block.ID] = len(graph.Nodes)
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			BlockID:   block.ID,
+			Language:  block.Language,
+			Position:  block.Position,
+			Defines:   defines,
+			DependsOn: append([]string(nil), block.DependsOn...),
+		})
+		families = append(families, family)
+		uses = append(uses, used)
+	}
+
+	edges := make(map[[2]int]*GraphEdge)
+	edge := func(from, to int) *GraphEdge {
+		key := [2]int{from, to}
+		if e, ok := edges[key]; ok {
+			return e
+		}
+		e := &GraphEdge{From: graph.Nodes[from].BlockID, To: graph.Nodes[to].BlockID}
+		edges[key] = e
+		return e
+	}
+	for i := range graph.Nodes {
+		node := &graph.Nodes[i]
+		for _, name := range uses[i] {
+			if from := definer(graph.Nodes, families, name, i-1, -1, families[i]); from != -1 {
+				e := edge(from, i)
+				e.Names = append(e.Names, name)
+				node.Uses = append(node.Uses, name)
+				continue
+			}
+			if isParam[name] {
+				node.Uses = append(node.Uses, name)
+				continue
+			}
+			if later := definer(graph.Nodes, families, name, i+1, 1, families[i]); later != -1 {
+				node.Uses = append(node.Uses, name)
+				graph.Issues = append(graph.Issues, GraphIssue{
+					Kind:    IssueUndefined,
+					BlockID: node.BlockID,
+					Name:    name,
+					Message: fmt.Sprintf("block %s uses %s before block %s defines it", node.BlockID, name, graph.Nodes[later].BlockID),
+				})
+			}
+		}
+		for _, dep := range node.DependsOn {
+			from, ok := index[dep]
+			if !ok {
+				graph.Issues = append(graph.Issues, GraphIssue{
+					Kind:    IssueUndefined,
+					BlockID: node.BlockID,
+					Name:    dep,
+					Message: fmt.Sprintf("block %s depends on %s, which is not a code block in this story", node.BlockID, dep),
+				})
+				continue
+			}
+			edge(from, i).Declared = true
+		}
+	}
+
+	keys := make([][2]int, 0, len(edges))
+	for key := range edges {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][1] != keys[j][1] {
+			return keys[i][1] < keys[j][1]
+		}
+		return keys[i][0] < keys[j][0]
+	})
+	successors := make([][]int, len(graph.Nodes))
+	for _, key := range keys {
+		graph.Edges = append(graph.Edges, *edges[key])
+		successors[key[0]] = append(successors[key[0]], key[1])
+	}
+
+	component := components(successors)
+	size := make(map[int]int)
+	for _, c := range component {
+		size[c]++
+	}
+	reported := make(map[int]bool)
+	for i, c := range component {
+		selfLoop := edges[[2]int{i, i}] != nil
+		if (size[c] < 2 && !selfLoop) || reported[c] {
+			continue
+		}
+		reported[c] = true
+		var members []string
+		for j, other := range component {
+			if other == c {
+				members = append(members, graph.Nodes[j].BlockID)
+			}
+		}
+		message := fmt.Sprintf("blocks %s depend on each other", strings.Join(members, ", "))
+		if len(members) == 1 {
+			message = fmt.Sprintf("block %s depends on itself", members[0])
+		}
+		graph.Issues = append(graph.Issues, GraphIssue{
+			Kind:    IssueCycle,
+			BlockID: members[0],
+			Blocks:  members,
+			Message: message,
+		})
+	}
+	for _, key := range keys {
+		from, to := key[0], key[1]
+		if from > to && component[from] != component[to] {
+			graph.Issues = append(graph.Issues, GraphIssue{
+				Kind:    IssueOrder,
+				BlockID: graph.Nodes[to].BlockID,
+				Name:    graph.Nodes[from].BlockID,
+				Message: fmt.Sprintf("block %s depends on block %s, which runs after it", graph.Nodes[to].BlockID, graph.Nodes[from].BlockID),
+			})
+		}
+	}
+	return graph
+}
+
+// definer walks from start in direction step and returns the index of the first node in family
+// that defines name, or -1.
+func definer(nodes []GraphNode, families []string, name string, start, step int, family string) int {
+	if family == "" {
+		return -1
+	}
+	for i := start; i >= 0 && i < len(nodes); i += step {
+		if families[i] != family {
+			continue
+		}
+		for _, defined := range nodes[i].Defines {
+			if defined == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// components labels each node with its strongly connected component using Tarjan's algorithm.
+func components(successors [][]int) []int {
+	var (
+		index   = make([]int, len(successors))
+		low     = make([]int, len(successors))
+		onStack = make([]bool, len(successors))
+		label   = make([]int, len(successors))
+		stack   []int
+		next    = 1
+		count   int
+		visit   func(v int)
+	)
+	visit = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range successors[v] {
+			if index[w] == 0 {
+				visit(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] == index[v] {
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				label[w] = count
+				if w == v {
+					break
+				}
+			}
+			count++
+		}
+	}
+	for v := range successors {
+		if index[v] == 0 {
+			visit(v)
+		}
+	}
+	return label
+}
+
+// Downstream returns blockID and every block that depends on it, directly or not.
+func (g Graph) Downstream(blockID string) map[string]bool {
+	successors := make(map[string][]string)
+	for _, e := range g.Edges {
+		successors[e.From] = append(successors[e.From], e.To)
+	}
+	selected := map[string]bool{blockID: true}
+	queue := []string{blockID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range successors[current] {
+			if !selected[next] {
+				selected[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return selected
+}
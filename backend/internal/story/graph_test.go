@@ -0,0 +1,189 @@
+package story_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/example/multistory/internal/realtime"
+	"github.com/example/multistory/internal/story"
+)
+
+func code(id string, position int, source string, dependsOn ...string) story.Block {
+	return story.Block{ID: id, Type: story.BlockCode, Language: "python", Source: source, Position: position, DependsOn: dependsOn}
+}
+
+func issueKinds(graph story.Graph) []story.IssueKind {
+	var kinds []story.IssueKind
+	for _, issue := range graph.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	return kinds
+}
+
+func TestBuildGraph(t *testing.T) {
+	cases := []struct {
+		name   string
+		blocks []story.Block
+		params []story.Parameter
+		edges  []story.GraphEdge
+		issues []story.IssueKind
+	}{
+		{
+			name:   "variables link to the nearest definition",
+			blocks: []story.Block{code("a", 0, "x = 1"), code("b", 1, "x = 2"), code("c", 2, "print(x)")},
+			edges:  []story.GraphEdge{{From: "b", To: "c", Names: []string{"x"}}},
+		},
+		{
+			name:   "blocks are ordered by position",
+			blocks: []story.Block{code("c", 2, "y = x"), code("a", 0, "x = 1")},
+			edges:  []story.GraphEdge{{From: "a", To: "c", Names: []string{"x"}}},
+		},
+		{
+			name:   "parameters satisfy uses",
+			blocks: []story.Block{code("a", 0, "y = limit * 2")},
+			params: []story.Parameter{{Name: "limit", Type: story.ParamNumber}},
+		},
+		{
+			name:   "use before definition",
+			blocks: []story.Block{code("a", 0, "print(x)"), code("b", 1, "x = 1")},
+			issues: []story.IssueKind{story.IssueUndefined},
+		},
+		{
+			name:   "unknown declared dependency",
+			blocks: []story.Block{code("a", 0, "x = 1", "missing")},
+			issues: []story.IssueKind{story.IssueUndefined},
+		},
+		{
+			name:   "declared dependency on a later block",
+			blocks: []story.Block{code("a", 0, "x = 1", "b"), code("b", 1, "y = 2")},
+			edges:  []story.GraphEdge{{From: "b", To: "a", Declared: true}},
+			issues: []story.IssueKind{story.IssueOrder},
+		},
+		{
+			name:   "self dependency",
+			blocks: []story.Block{code("a", 0, "x = 1", "a")},
+			edges:  []story.GraphEdge{{From: "a", To: "a", Declared: true}},
+			issues: []story.IssueKind{story.IssueCycle},
+		},
+		{
+			name:   "cycle through a declared dependency",
+			blocks: []story.Block{code("a", 0, "x = 1", "b"), code("b", 1, "y = x")},
+			edges: []story.GraphEdge{
+				{From: "b", To: "a", Declared: true},
+				{From: "a", To: "b", Names: []string{"x"}},
+			},
+			issues: []story.IssueKind{story.IssueCycle},
+		},
+		{
+			name: "languages keep separate variables",
+			blocks: []story.Block{
+				code("a", 0, "x = 1"),
+				{ID: "b", Type: story.BlockCode, Language: "bash", Source: "echo $x", Position: 1},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			graph := story.BuildGraph(tc.blocks, tc.params)
+			edges := tc.edges
+			if edges == nil {
+				edges = []story.GraphEdge{}
+			}
+			if !reflect.DeepEqual(graph.Edges, edges) {
+				t.Fatalf("expected edges %+v, got %+v", edges, graph.Edges)
+			}
+			if kinds := issueKinds(graph); !reflect.DeepEqual(kinds, tc.issues) {
+				t.Fatalf("expected issues %v, got %+v", tc.issues, graph.Issues)
+			}
+		})
+	}
+}
+
+func TestGraphCycleListsMembers(t *testing.T) {
+	graph := story.BuildGraph([]story.Block{
+		code("a", 0, "x = 1", "c"),
+		code("b", 1, "y = x"),
+		code("c", 2, "z = y"),
+	}, nil)
+	if len(graph.Issues) != 1 || graph.Issues[0].Kind != story.IssueCycle {
+		t.Fatalf("expected a single cycle, got %+v", graph.Issues)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(graph.Issues[0].Blocks, want) {
+		t.Fatalf("expected cycle members %v, got %v", want, graph.Issues[0].Blocks)
+	}
+}
+
+func TestGraphDownstream(t *testing.T) {
+	graph := story.BuildGraph([]story.Block{
+		code("a", 0, "x = 1"),
+		code("b", 1, "y = x"),
+		code("c", 2, "z = 3"),
+		code("d", 3, "print(y, z)"),
+		code("e", 4, "w = 4", "c"),
+	}, nil)
+	cases := map[string][]string{
+		"a": {"a", "b", "d"},
+		"b": {"b", "d"},
+		"c": {"c", "d", "e"},
+		"d": {"d"},
+	}
+	for blockID, want := range cases {
+		got := graph.Downstream(blockID)
+		expected := make(map[string]bool, len(want))
+		for _, id := range want {
+			expected[id] = true
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected downstream of %s to be %v, got %v", blockID, want, got)
+		}
+	}
+}
+
+func TestSelectDownstream(t *testing.T) {
+	blocks := []story.Block{code("a", 0, "x = limit"), code("b", 1, "y = x"), code("c", 2, "z = 1")}
+	target := story.ExecutionTarget{Scope: story.ScopeDownstream, BlockID: "a"}
+
+	selected, err := target.Select(blocks, []story.Parameter{{Name: "limit", Type: story.ParamNumber}})
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if want := map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(selected, want) {
+		t.Fatalf("expected %v, got %v", want, selected)
+	}
+
+	blocks[0].DependsOn = []string{"b"}
+	if _, err := target.Select(blocks, nil); !errors.Is(err, story.ErrCycle) {
+		t.Fatalf("expected ErrCycle, got %v", err)
+	}
+	target.Scope = story.ScopeFrom
+	if _, err := target.Select(blocks, nil); err != nil {
+		t.Fatalf("expected other scopes to ignore the cycle, got %v", err)
+	}
+}
+
+func TestServiceReportsGraphIssues(t *testing.T) {
+	svc := story.NewService(story.NewMemoryRepository(), nil, realtime.NewHub(), nil)
+	ctx := context.Background()
+	created, err := svc.CreateStory(ctx, story.CreateStoryInput{
+		Title: "issues",
+		Blocks: []story.BlockInput{
+			{Type: story.BlockCode, Language: "python", Source: "print(x)"},
+			{Type: story.BlockCode, Language: "python", Source: "x = 1"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create story: %v", err)
+	}
+	if kinds := issueKinds(story.Graph{Issues: created.Issues}); !reflect.DeepEqual(kinds, []story.IssueKind{story.IssueUndefined}) {
+		t.Fatalf("expected the created story to report the undefined use, got %+v", created.Issues)
+	}
+	fetched, err := svc.GetStory(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get story: %v", err)
+	}
+	if len(fetched.Issues) != 1 {
+		t.Fatalf("expected the fetched story to report one issue, got %+v", fetched.Issues)
+	}
+}
@@ -0,0 +1,211 @@
+package story
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidParameter is returned when parameter definitions or the values supplied for a run do
+// not check out. The wrapping error says which parameter and why.
+var ErrInvalidParameter = errors.New("story: invalid parameter")
+
+// ParameterType says what values a Parameter accepts.
+type ParameterType string
+
+const (
+	ParamString ParameterType = "string"
+	ParamNumber ParameterType = "number"
+	// ParamDate values are calendar dates written as "2006-01-02".
+	ParamDate ParameterType = "date"
+	// ParamEnum values must be one of the parameter's Options.
+	ParamEnum ParameterType = "enum"
+)
+
+// DateLayout is the format of ParamDate values.
+const DateLayout = "2006-01-02"
+
+// Parameter is a typed input to a story. Runners make each value available to code blocks as a
+// variable named after the parameter. Without a Default a value must be supplied for every run.
+type Parameter struct {
+	Name    string        `json:"name"`
+	Type    ParameterType `json:"type"`
+	Label   string        `json:"label,omitempty"`
+	Default interface{}   `json:"default,omitempty"`
+	Options []string      `json:"options,omitempty"`
+}
+
+// parameterName keeps names usable as variables in every language a block may be written in. R
+// does not allow a leading underscore.
+var parameterName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// reservedNames maps names a parameter cannot take to the reason. Keywords would not parse once
+// assigned, and assigning a shell variable the shell or a runtime relies on changes how the block
+// runs rather than passing it a value.
+var reservedNames = func() map[string]string {
+	words := []struct{ reason, names string }{
+		{"a keyword in Python", "False None True and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield"},
+		{"reserved in JavaScript", "arguments await break case catch class const continue debugger default delete do else enum eval export extends false finally for function if implements import in instanceof interface let new null package private protected public return static super switch this throw true try typeof undefined var void while with yield Infinity NaN"},
+		{"reserved in R", "if else repeat while function for in next break TRUE FALSE NULL Inf NaN NA NA_integer_ NA_real_ NA_complex_ NA_character_"},
+		{"a variable the shell relies on", "PATH IFS HOME ENV CDPATH SHELL SHELLOPTS POSIXLY_CORRECT PS1 PS2 PS3 PS4 PWD OLDPWD OPTIND OPTARG OPTERR UID EUID PPID GROUPS RANDOM SRANDOM LINENO SECONDS FUNCNAME PIPESTATUS HISTFILE HOSTNAME HOSTTYPE MACHTYPE OSTYPE MAIL MAILPATH MAILCHECK TMOUT USER LOGNAME TERM TMPDIR TZ LANG LANGUAGE"},
+	}
+	reserved := make(map[string]string)
+	for _, w := range words {
+		for _, name := range strings.Fields(w.names) {
+			if _, ok := reserved[name]; !ok {
+				reserved[name] = w.reason
+			}
+		}
+	}
+	return reserved
+}()
+
+// reservedPrefixes are environment variable families read by the dynamic loader, the shell, the
+// C library and the language runtimes.
+var reservedPrefixes = []string{"LD_", "DYLD_", "BASH", "LC_", "PYTHON", "NODE_", "R_"}
+
+// reservedName reports why name cannot be used as a parameter, or "" when it can.
+func reservedName(name string) string {
+	if reason, ok := reservedNames[name]; ok {
+		return reason
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return "a variable the shell or a runtime relies on"
+		}
+	}
+	return ""
+}
+
+// NormalizeParameters checks a story's parameter definitions and returns them with defaults
+// converted to their canonical form.
+func NormalizeParameters(params []Parameter) ([]Parameter, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(params))
+	normalized := make([]Parameter, len(params))
+	for i, param := range params {
+		if !parameterName.MatchString(param.Name) {
+			return nil, fmt.Errorf("%w: name %q must be a letter followed by letters, digits or underscores", ErrInvalidParameter, param.Name)
+		}
+		if reason := reservedName(param.Name); reason != "" {
+			return nil, fmt.Errorf("%w: name %s is %s", ErrInvalidParameter, param.Name, reason)
+		}
+		if seen[param.Name] {
+			return nil, fmt.Errorf("%w: %s is defined twice", ErrInvalidParameter, param.Name)
+		}
+		seen[param.Name] = true
+		switch param.Type {
+		case ParamString, ParamNumber, ParamDate:
+			if len(param.Options) > 0 {
+				return nil, fmt.Errorf("%w: %s: only enum parameters take options", ErrInvalidParameter, param.Name)
+			}
+		case ParamEnum:
+			if len(param.Options) == 0 {
+				return nil, fmt.Errorf("%w: %s: enum parameters need options", ErrInvalidParameter, param.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s: type must be string, number, date or enum", ErrInvalidParameter, param.Name)
+		}
+		param.Options = append([]string(nil), param.Options...)
+		if param.Default != nil {
+			value, err := param.convert(param.Default)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: default %v", ErrInvalidParameter, param.Name, err)
+			}
+			param.Default = value
+		}
+		normalized[i] = param
+	}
+	return normalized, nil
+}
+
+// ResolveParameters checks values against the story's parameters and fills in defaults. Numbers
+// come back as float64 and every other type as a string. It returns nil for a story without
+// parameters.
+func ResolveParameters(params []Parameter, values map[string]interface{}) (map[string]interface{}, error) {
+	known := make(map[string]bool, len(params))
+	for _, param := range params {
+		known[param.Name] = true
+	}
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w: unknown %s", ErrInvalidParameter, strings.Join(unknown, ", "))
+	}
+	if len(params) == 0 {
+		return nil, nil
+	}
+	resolved := make(map[string]interface{}, len(params))
+	for _, param := range params {
+		raw, ok := values[param.Name]
+		if !ok || raw == nil {
+			if param.Default == nil {
+				return nil, fmt.Errorf("%w: %s is required", ErrInvalidParameter, param.Name)
+			}
+			raw = param.Default
+		}
+		value, err := param.convert(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidParameter, param.Name, err)
+		}
+		resolved[param.Name] = value
+	}
+	return resolved, nil
+}
+
+// convert returns raw in the canonical form for the parameter's type.
+func (p Parameter) convert(raw interface{}) (interface{}, error) {
+	switch p.Type {
+	case ParamNumber:
+		switch v := raw.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case json.Number:
+			return v.Float64()
+		}
+		return nil, fmt.Errorf("%s is not a number", display(raw))
+	case ParamDate:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a date", display(raw))
+		}
+		if _, err := time.Parse(DateLayout, s); err != nil {
+			return nil, fmt.Errorf("%q is not a date like %s", s, DateLayout)
+		}
+		return s, nil
+	case ParamEnum:
+		s, ok := raw.(string)
+		if !ok || !contains(p.Options, s) {
+			return nil, fmt.Errorf("%s is not one of %s", display(raw), strings.Join(p.Options, ", "))
+		}
+		return s, nil
+	default:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string", display(raw))
+		}
+		return s, nil
+	}
+}
+
+// display renders a submitted value the way the client wrote it, so "5" and 5 read differently.
+func display(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
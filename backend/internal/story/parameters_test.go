@@ -0,0 +1,53 @@
+package story_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/example/multistory/internal/story"
+)
+
+func TestNormalizeParametersNames(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "region", valid: true},
+		{name: "top_n", valid: true},
+		{name: "Path", valid: true},
+		{name: "classes", valid: true},
+		{name: "", valid: false},
+		{name: "2nd", valid: false},
+		{name: "_private", valid: false},
+		{name: "top-n", valid: false},
+		// Names the shell, the loader or a runtime reads from the environment.
+		{name: "PATH", valid: false},
+		{name: "IFS", valid: false},
+		{name: "HOME", valid: false},
+		{name: "LD_PRELOAD", valid: false},
+		{name: "LC_ALL", valid: false},
+		{name: "BASH_ENV", valid: false},
+		{name: "PYTHONPATH", valid: false},
+		// Words that do not parse as a variable in one of the block languages.
+		{name: "class", valid: false},
+		{name: "def", valid: false},
+		{name: "None", valid: false},
+		{name: "True", valid: false},
+		{name: "typeof", valid: false},
+		{name: "undefined", valid: false},
+		{name: "function", valid: false},
+		{name: "NULL", valid: false},
+		{name: "NA_real_", valid: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := story.NormalizeParameters([]story.Parameter{{Name: tc.name, Type: story.ParamString}})
+			if tc.valid && err != nil {
+				t.Fatalf("expected %q to be accepted, got %v", tc.name, err)
+			}
+			if !tc.valid && !errors.Is(err, story.ErrInvalidParameter) {
+				t.Fatalf("expected %q to be rejected, got %v", tc.name, err)
+			}
+		})
+	}
+}
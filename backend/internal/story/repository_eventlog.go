@@ -0,0 +1,396 @@
+package story
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// Record types written to the event log.
+const (
+    RecordStoryCreated      = "story.created"
+    RecordStoryUpdated      = "story.updated"
+    RecordRevisionAppended  = "revision.appended"
+    RecordRevisionCommitted = "revision.committed"
+    RecordCommentAdded      = "comment.added"
+    RecordExecutionRecorded = "execution.recorded"
+    RecordScheduleSaved     = "schedule.saved"
+    RecordScheduleDeleted   = "schedule.deleted"
+)
+
+const (
+    logFileName      = "events.log"
+    snapshotFileName = "snapshot.json"
+    // snapshotEvery is how many records may accumulate before the state is snapshotted.
+    snapshotEvery = 500
+)
+
+// LogRecord is a single append-only entry in the event log.
+type LogRecord struct {
+    Seq              uint64     `json:"seq"`
+    Type             string     `json:"type"`
+    StoryID          string     `json:"storyId"`
+    At               time.Time  `json:"at"`
+    Story            *Story     `json:"story,omitempty"`
+    Revision         *Revision  `json:"revision,omitempty"`
+    Comment          *Comment   `json:"comment,omitempty"`
+    Execution        *Execution `json:"execution,omitempty"`
+    Schedule         *Schedule  `json:"schedule,omitempty"`
+    ScheduleID       string     `json:"scheduleId,omitempty"`
+    ExpectedRevision string     `json:"expectedRevision,omitempty"`
+}
+
+type snapshot struct {
+    Seq        uint64                 `json:"seq"`
+    Stories    map[string]Story       `json:"stories"`
+    Revisions  map[string][]Revision  `json:"revisions"`
+    Executions map[string][]Execution `json:"executions"`
+    Schedules  map[string]Schedule    `json:"schedules"`
+}
+
+type eventLogRepository struct {
+    // mu serialises writers so validation, logging and applying happen as one step.
+    mu    sync.Mutex
+    dir   string
+    state *memoryRepository
+    seq   uint64
+    // sinceSnapshot counts records appended after the last snapshot.
+    sinceSnapshot int
+    now           func() time.Time
+}
+
+// NewEventLogRepository returns a Repository that appends every mutation to a log in dir and
+// rebuilds current state on startup by replaying it on top of the latest snapshot. The log is not
+// a source of realtime events: realtime.Hub replays missed events from its own buffer only, since
+// the log holds just the changes since the last snapshot and none of the execution or presence
+// events a stream carries.
+func NewEventLogRepository(dir string) (Repository, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    r := &eventLogRepository{
+        dir:   dir,
+        state: NewMemoryRepository().(*memoryRepository),
+        now:   func() time.Time { return time.Now().UTC() },
+    }
+    if err := r.loadSnapshot(); err != nil {
+        return nil, err
+    }
+    if err := r.replay(); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+func (r *eventLogRepository) Create(ctx context.Context, story Story) error {
+    return r.record(ctx, LogRecord{Type: RecordStoryCreated, StoryID: story.ID, Story: &story})
+}
+
+func (r *eventLogRepository) Update(ctx context.Context, story Story) error {
+    return r.record(ctx, LogRecord{Type: RecordStoryUpdated, StoryID: story.ID, Story: &story})
+}
+
+func (r *eventLogRepository) Get(ctx context.Context, id string) (Story, error) {
+    return r.state.Get(ctx, id)
+}
+
+func (r *eventLogRepository) List(ctx context.Context) ([]Story, error) {
+    return r.state.List(ctx)
+}
+
+func (r *eventLogRepository) AppendRevision(ctx context.Context, revision Revision) error {
+    return r.record(ctx, LogRecord{Type: RecordRevisionAppended, StoryID: revision.StoryID, Revision: &revision})
+}
+
+func (r *eventLogRepository) Commit(ctx context.Context, story Story, revision Revision, expectedRevisionID string) error {
+    return r.record(ctx, LogRecord{
+        Type:             RecordRevisionCommitted,
+        StoryID:          story.ID,
+        Story:            &story,
+        Revision:         &revision,
+        ExpectedRevision: expectedRevisionID,
+    })
+}
+
+func (r *eventLogRepository) ListRevisions(ctx context.Context, storyID string) ([]Revision, error) {
+    return r.state.ListRevisions(ctx, storyID)
+}
+
+func (r *eventLogRepository) GetRevision(ctx context.Context, storyID, revisionID string) (Revision, error) {
+    return r.state.GetRevision(ctx, storyID, revisionID)
+}
+
+func (r *eventLogRepository) AppendComment(ctx context.Context, comment Comment) error {
+    return r.record(ctx, LogRecord{Type: RecordCommentAdded, StoryID: comment.StoryID, Comment: &comment})
+}
+
+func (r *eventLogRepository) AppendExecution(ctx context.Context, execution Execution) error {
+    return r.record(ctx, LogRecord{Type: RecordExecutionRecorded, StoryID: execution.StoryID, Execution: &execution})
+}
+
+func (r *eventLogRepository) ListExecutions(ctx context.Context, storyID string, filter ExecutionFilter) ([]Execution, int, error) {
+    return r.state.ListExecutions(ctx, storyID, filter)
+}
+
+func (r *eventLogRepository) SaveSchedule(ctx context.Context, schedule Schedule) error {
+    return r.record(ctx, LogRecord{Type: RecordScheduleSaved, StoryID: schedule.StoryID, Schedule: &schedule})
+}
+
+func (r *eventLogRepository) GetSchedule(ctx context.Context, id string) (Schedule, error) {
+    return r.state.GetSchedule(ctx, id)
+}
+
+func (r *eventLogRepository) ListSchedules(ctx context.Context, storyID string) ([]Schedule, error) {
+    return r.state.ListSchedules(ctx, storyID)
+}
+
+func (r *eventLogRepository) DeleteSchedule(ctx context.Context, id string) error {
+    schedule, err := r.state.GetSchedule(ctx, id)
+    if err != nil {
+        return err
+    }
+    return r.record(ctx, LogRecord{Type: RecordScheduleDeleted, StoryID: schedule.StoryID, ScheduleID: id})
+}
+
+// record validates rec against current state, makes it durable, then applies it. Nothing reaches
+// the log unless it would apply cleanly, so replay never has to skip entries.
+func (r *eventLogRepository) record(ctx context.Context, rec LogRecord) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if err := r.validate(ctx, rec); err != nil {
+        return err
+    }
+    rec.Seq = r.seq + 1
+    rec.At = r.now()
+    if err := r.appendLog(rec); err != nil {
+        return err
+    }
+    r.seq = rec.Seq
+    if err := r.apply(ctx, rec); err != nil {
+        return err
+    }
+    r.sinceSnapshot++
+    if r.sinceSnapshot >= snapshotEvery {
+        // A failed snapshot only slows the next startup; the log remains authoritative.
+        if err := r.compact(); err == nil {
+            r.sinceSnapshot = 0
+        }
+    }
+    return nil
+}
+
+// compact snapshots the state and empties the log, whose records the snapshot now covers. A
+// crash in between leaves records that replay skips by sequence number.
+func (r *eventLogRepository) compact() error {
+    if err := r.writeSnapshot(); err != nil {
+        return err
+    }
+    err := os.Truncate(filepath.Join(r.dir, logFileName), 0)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    return err
+}
+
+func (r *eventLogRepository) validate(ctx context.Context, rec LogRecord) error {
+    if rec.Type == RecordStoryCreated {
+        return nil
+    }
+    current, err := r.state.Get(ctx, rec.StoryID)
+    if err != nil {
+        return err
+    }
+    if rec.Type == RecordRevisionCommitted && current.RevisionID != rec.ExpectedRevision {
+        return ErrConflict
+    }
+    if rec.Type == RecordScheduleDeleted {
+        _, err := r.state.GetSchedule(ctx, rec.ScheduleID)
+        return err
+    }
+    return nil
+}
+
+func (r *eventLogRepository) apply(ctx context.Context, rec LogRecord) error {
+    switch rec.Type {
+    case RecordStoryCreated:
+        return r.state.Create(ctx, *rec.Story)
+    case RecordStoryUpdated:
+        return r.state.Update(ctx, *rec.Story)
+    case RecordRevisionAppended:
+        return r.state.AppendRevision(ctx, *rec.Revision)
+    case RecordRevisionCommitted:
+        return r.state.Commit(ctx, *rec.Story, *rec.Revision, rec.ExpectedRevision)
+    case RecordCommentAdded:
+        return r.state.AppendComment(ctx, *rec.Comment)
+    case RecordExecutionRecorded:
+        return r.state.AppendExecution(ctx, *rec.Execution)
+    case RecordScheduleSaved:
+        return r.state.SaveSchedule(ctx, *rec.Schedule)
+    case RecordScheduleDeleted:
+        return r.state.DeleteSchedule(ctx, rec.ScheduleID)
+    default:
+        return fmt.Errorf("story: unknown log record type %q", rec.Type)
+    }
+}
+
+func (r *eventLogRepository) appendLog(rec LogRecord) error {
+    line, err := json.Marshal(rec)
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(filepath.Join(r.dir, logFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// replay applies records newer than the snapshot and trims any torn tail so later appends start
+// on a fresh line.
+func (r *eventLogRepository) replay() error {
+    ctx := context.Background()
+    path := filepath.Join(r.dir, logFileName)
+    consumed, err := scanLog(path, func(rec LogRecord) error {
+        if rec.Seq <= r.seq {
+            return nil
+        }
+        if err := r.apply(ctx, rec); err != nil {
+            return fmt.Errorf("story: replay record %d: %w", rec.Seq, err)
+        }
+        r.seq = rec.Seq
+        r.sinceSnapshot++
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    info, err := os.Stat(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if info.Size() > consumed {
+        return os.Truncate(path, consumed)
+    }
+    return nil
+}
+
+// scanLog feeds every complete record to fn and reports how many bytes they span. A torn final
+// line from a crash mid-append is ignored.
+func scanLog(path string, fn func(LogRecord) error) (int64, error) {
+    f, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    defer f.Close()
+    var consumed int64
+    reader := bufio.NewReader(f)
+    for {
+        line, err := reader.ReadBytes('\n')
+        if err == io.EOF {
+            return consumed, nil
+        }
+        if err != nil {
+            return consumed, err
+        }
+        var rec LogRecord
+        if err := json.Unmarshal(line, &rec); err != nil {
+            return consumed, fmt.Errorf("story: corrupt log record at byte %d: %w", consumed, err)
+        }
+        if err := fn(rec); err != nil {
+            return consumed, err
+        }
+        consumed += int64(len(line))
+    }
+}
+
+func (r *eventLogRepository) loadSnapshot() error {
+    data, err := os.ReadFile(filepath.Join(r.dir, snapshotFileName))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    var snap snapshot
+    if err := json.Unmarshal(data, &snap); err != nil {
+        return fmt.Errorf("story: corrupt snapshot: %w", err)
+    }
+    if snap.Stories != nil {
+        r.state.stories = snap.Stories
+    }
+    if snap.Revisions != nil {
+        r.state.revisions = snap.Revisions
+    }
+    if snap.Executions != nil {
+        r.state.executions = snap.Executions
+    }
+    if snap.Schedules != nil {
+        r.state.schedules = snap.Schedules
+    }
+    r.seq = snap.Seq
+    return nil
+}
+
+// writeSnapshot durably stores the full state via rename so a crash never leaves a partial
+// snapshot.
+func (r *eventLogRepository) writeSnapshot() error {
+    r.state.mu.RLock()
+    data, err := json.Marshal(snapshot{
+        Seq:        r.seq,
+        Stories:    r.state.stories,
+        Revisions:  r.state.revisions,
+        Executions: r.state.executions,
+        Schedules:  r.state.schedules,
+    })
+    r.state.mu.RUnlock()
+    if err != nil {
+        return err
+    }
+    // The log is truncated once the snapshot is in place, so it has to be on disk first.
+    tmp := filepath.Join(r.dir, snapshotFileName+".tmp")
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, filepath.Join(r.dir, snapshotFileName)); err != nil {
+        return err
+    }
+    dir, err := os.Open(r.dir)
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+    return dir.Sync()
+}
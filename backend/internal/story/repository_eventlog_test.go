@@ -0,0 +1,110 @@
+package story_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/example/multistory/internal/story"
+	"github.com/example/multistory/internal/story/storytest"
+)
+
+func TestEventLogRepository(t *testing.T) {
+	storytest.RunRepositoryTests(t, func(t *testing.T) story.Repository {
+		repo, err := story.NewEventLogRepository(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewEventLogRepository: %v", err)
+		}
+		return repo
+	})
+}
+
+func TestEventLogRepositoryReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo, err := story.NewEventLogRepository(dir)
+	if err != nil {
+		t.Fatalf("NewEventLogRepository: %v", err)
+	}
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := story.Story{ID: "s1", Title: "Replay", RevisionID: "r0", CreatedAt: at, UpdatedAt: at}
+	if err := repo.Create(ctx, s); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	s.Blocks = []story.Block{{ID: "b1", Type: story.BlockCode, Source: "1 + 1"}}
+	if err := repo.Commit(ctx, s, story.Revision{ID: "r1", StoryID: "s1", Blocks: s.Blocks, CreatedAt: at}, "r0"); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	// Enough records to cross the snapshot threshold and leave a tail to replay after it.
+	for i := 0; i < 520; i++ {
+		if err := repo.AppendComment(ctx, story.Comment{ID: fmt.Sprintf("c%d", i), StoryID: "s1", CreatedAt: at}); err != nil {
+			t.Fatalf("AppendComment: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshot.json")); err != nil {
+		t.Fatalf("expected a snapshot: %v", err)
+	}
+	want, _ := repo.Get(ctx, "s1")
+	wantRevs, _ := repo.ListRevisions(ctx, "s1")
+
+	// Simulate a crash mid-append; the torn record must be dropped, not fail startup.
+	f, err := os.OpenFile(filepath.Join(dir, "events.log"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	f.WriteString(`{"seq":9999,"type":"comment.ad`)
+	f.Close()
+
+	reopened, err := story.NewEventLogRepository(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	got, err := reopened.Get(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Get after replay: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed story differs\n got: %+v\nwant: %+v", got, want)
+	}
+	gotRevs, _ := reopened.ListRevisions(ctx, "s1")
+	if !reflect.DeepEqual(gotRevs, wantRevs) {
+		t.Fatalf("replayed revisions differ\n got: %+v\nwant: %+v", gotRevs, wantRevs)
+	}
+	if err := reopened.AppendComment(ctx, story.Comment{ID: "after", StoryID: "s1", CreatedAt: at}); err != nil {
+		t.Fatalf("AppendComment after replay: %v", err)
+	}
+	// The snapshot compacted the log, so only the records after it remain.
+	records := readLog(t, dir)
+	if n := len(records); n != 23 {
+		t.Fatalf("got %d records, want 23", n)
+	}
+	if first := records[0]; first.Seq != 501 {
+		t.Fatalf("first record = %+v, want seq 501", first)
+	}
+	if last := records[len(records)-1]; last.Type != story.RecordCommentAdded || last.Seq != 523 {
+		t.Fatalf("last record = %+v", last)
+	}
+}
+
+func readLog(t *testing.T, dir string) []story.LogRecord {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "events.log"))
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	var records []story.LogRecord
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var rec story.LogRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("decode record: %v", err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
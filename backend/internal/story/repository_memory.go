@@ -7,16 +7,20 @@ import (
 )
 
 type memoryRepository struct {
-    mu        sync.RWMutex
-    stories   map[string]Story
-    revisions map[string][]Revision
+    mu         sync.RWMutex
+    stories    map[string]Story
+    revisions  map[string][]Revision
+    executions map[string][]Execution
+    schedules  map[string]Schedule
 }
 
 // NewMemoryRepository returns an in-memory store suitable for prototypes.
 func NewMemoryRepository() Repository {
     return &memoryRepository{
-        stories:   make(map[string]Story),
-        revisions: make(map[string][]Revision),
+        stories:    make(map[string]Story),
+        revisions:  make(map[string][]Revision),
+        executions: make(map[string][]Execution),
+        schedules:  make(map[string]Schedule),
     }
 }
 
@@ -66,6 +70,7 @@ func (m *memoryRepository) AppendRevision(_ context.Context, revision Revision)
     if _, ok := m.stories[revision.StoryID]; !ok {
         return ErrNotFound
     }
+    revision = cloneRevision(revision)
     m.revisions[revision.StoryID] = append(m.revisions[revision.StoryID], revision)
     story := cloneStory(m.stories[revision.StoryID])
     story.RevisionID = revision.ID
@@ -74,15 +79,48 @@ func (m *memoryRepository) AppendRevision(_ context.Context, revision Revision)
     return nil
 }
 
+func (m *memoryRepository) Commit(_ context.Context, story Story, revision Revision, expectedRevisionID string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    current, ok := m.stories[story.ID]
+    if !ok {
+        return ErrNotFound
+    }
+    if current.RevisionID != expectedRevisionID {
+        return ErrConflict
+    }
+    story.RevisionID = revision.ID
+    story.Comments = reanchorComments(current.Comments, story.Blocks)
+    m.stories[story.ID] = cloneStory(story)
+    m.revisions[story.ID] = append(m.revisions[story.ID], cloneRevision(revision))
+    return nil
+}
+
 func (m *memoryRepository) ListRevisions(_ context.Context, storyID string) ([]Revision, error) {
     m.mu.RLock()
     defer m.mu.RUnlock()
     revs := m.revisions[storyID]
     clones := make([]Revision, len(revs))
-    copy(clones, revs)
+    for i, rev := range revs {
+        clones[i] = cloneRevision(rev)
+    }
     return clones, nil
 }
 
+func (m *memoryRepository) GetRevision(_ context.Context, storyID, revisionID string) (Revision, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    if _, ok := m.stories[storyID]; !ok {
+        return Revision{}, ErrNotFound
+    }
+    for _, rev := range m.revisions[storyID] {
+        if rev.ID == revisionID {
+            return cloneRevision(rev), nil
+        }
+    }
+    return Revision{}, ErrRevisionNotFound
+}
+
 func (m *memoryRepository) AppendComment(_ context.Context, comment Comment) error {
     m.mu.Lock()
     defer m.mu.Unlock()
@@ -95,11 +133,179 @@ func (m *memoryRepository) AppendComment(_ context.Context, comment Comment) err
     return nil
 }
 
+func (m *memoryRepository) AppendExecution(_ context.Context, execution Execution) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.stories[execution.StoryID]; !ok {
+        return ErrNotFound
+    }
+    m.executions[execution.StoryID] = append(m.executions[execution.StoryID], cloneExecution(execution))
+    return nil
+}
+
+func (m *memoryRepository) ListExecutions(_ context.Context, storyID string, filter ExecutionFilter) ([]Execution, int, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    if _, ok := m.stories[storyID]; !ok {
+        return nil, 0, ErrNotFound
+    }
+    var matched []Execution
+    all := m.executions[storyID]
+    for i := len(all) - 1; i >= 0; i-- {
+        if filter.Status == "" || all[i].Status == filter.Status {
+            matched = append(matched, all[i])
+        }
+    }
+    total := len(matched)
+    if filter.Offset >= total {
+        return nil, total, nil
+    }
+    matched = matched[filter.Offset:]
+    if filter.Limit > 0 && len(matched) > filter.Limit {
+        matched = matched[:filter.Limit]
+    }
+    page := make([]Execution, len(matched))
+    for i, execution := range matched {
+        page[i] = cloneExecution(execution)
+    }
+    return page, total, nil
+}
+
+func (m *memoryRepository) SaveSchedule(_ context.Context, schedule Schedule) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.stories[schedule.StoryID]; !ok {
+        return ErrNotFound
+    }
+    m.schedules[schedule.ID] = cloneSchedule(schedule)
+    return nil
+}
+
+func (m *memoryRepository) GetSchedule(_ context.Context, id string) (Schedule, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    schedule, ok := m.schedules[id]
+    if !ok {
+        return Schedule{}, ErrScheduleNotFound
+    }
+    return cloneSchedule(schedule), nil
+}
+
+func (m *memoryRepository) ListSchedules(_ context.Context, storyID string) ([]Schedule, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    schedules := []Schedule{}
+    for _, schedule := range m.schedules {
+        if storyID == "" || schedule.StoryID == storyID {
+            schedules = append(schedules, cloneSchedule(schedule))
+        }
+    }
+    sort.Slice(schedules, func(i, j int) bool {
+        if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt) {
+            return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+        }
+        return schedules[i].ID < schedules[j].ID
+    })
+    return schedules, nil
+}
+
+func (m *memoryRepository) DeleteSchedule(_ context.Context, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.schedules[id]; !ok {
+        return ErrScheduleNotFound
+    }
+    delete(m.schedules, id)
+    return nil
+}
+
 func cloneStory(s Story) Story {
     clone := s
-    clone.Blocks = append([]Block(nil), s.Blocks...)
+    clone.Blocks = cloneBlocks(s.Blocks)
     clone.Comments = append([]Comment(nil), s.Comments...)
     clone.Owners = append([]string(nil), s.Owners...)
     clone.Tags = append([]string(nil), s.Tags...)
+    clone.Parameters = cloneParameters(s.Parameters)
+    return clone
+}
+
+func cloneRevision(r Revision) Revision {
+    clone := r
+    clone.Blocks = cloneBlocks(r.Blocks)
     return clone
 }
+
+func cloneBlocks(blocks []Block) []Block {
+    if blocks == nil {
+        return nil
+    }
+    clone := make([]Block, len(blocks))
+    for i, b := range blocks {
+        b.Outputs = append([]Output(nil), b.Outputs...)
+        b.DependsOn = append([]string(nil), b.DependsOn...)
+        clone[i] = b
+    }
+    return clone
+}
+
+func cloneParameters(params []Parameter) []Parameter {
+    if params == nil {
+        return nil
+    }
+    clone := make([]Parameter, len(params))
+    for i, p := range params {
+        p.Options = append([]string(nil), p.Options...)
+        clone[i] = p
+    }
+    return clone
+}
+
+func cloneExecution(e Execution) Execution {
+    clone := e
+    clone.Logs = append([]string(nil), e.Logs...)
+    clone.Parameters = cloneValues(e.Parameters)
+    return clone
+}
+
+func cloneValues(values map[string]interface{}) map[string]interface{} {
+    if values == nil {
+        return nil
+    }
+    clone := make(map[string]interface{}, len(values))
+    for name, value := range values {
+        clone[name] = value
+    }
+    return clone
+}
+
+func cloneSchedule(s Schedule) Schedule {
+    clone := s
+    if s.NextRunAt != nil {
+        next := *s.NextRunAt
+        clone.NextRunAt = &next
+    }
+    if s.LastRunAt != nil {
+        last := *s.LastRunAt
+        clone.LastRunAt = &last
+    }
+    return clone
+}
+
+// reanchorComments returns comments with those on blocks missing from blocks moved to the story.
+func reanchorComments(comments []Comment, blocks []Block) []Comment {
+    if len(comments) == 0 {
+        return comments
+    }
+    present := make(map[string]bool, len(blocks))
+    for _, block := range blocks {
+        present[block.ID] = true
+    }
+    anchored := make([]Comment, len(comments))
+    for i, comment := range comments {
+        if comment.BlockID != "" && !present[comment.BlockID] {
+            comment.BlockID = ""
+        }
+        anchored[i] = comment
+    }
+    return anchored
+}
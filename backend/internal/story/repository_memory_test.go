@@ -0,0 +1,14 @@
+package story_test
+
+import (
+	"testing"
+
+	"github.com/example/multistory/internal/story"
+	"github.com/example/multistory/internal/story/storytest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	storytest.RunRepositoryTests(t, func(t *testing.T) story.Repository {
+		return story.NewMemoryRepository()
+	})
+}
@@ -0,0 +1,748 @@
+package story
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    _ "modernc.org/sqlite"
+)
+
+// timeLayout is fixed-width so stored timestamps sort lexically in chronological order.
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// migrations are applied in order at startup; append new steps, never edit existing ones.
+var migrations = []string{
+    `CREATE TABLE stories (
+        id          TEXT PRIMARY KEY,
+        title       TEXT NOT NULL,
+        description TEXT NOT NULL,
+        owners      TEXT NOT NULL,
+        visibility  TEXT NOT NULL,
+        revision_id TEXT NOT NULL,
+        tags        TEXT NOT NULL,
+        created_at  TEXT NOT NULL,
+        updated_at  TEXT NOT NULL
+    );
+    CREATE TABLE blocks (
+        story_id   TEXT NOT NULL REFERENCES stories(id),
+        id         TEXT NOT NULL,
+        type       TEXT NOT NULL,
+        language   TEXT NOT NULL,
+        source     TEXT NOT NULL,
+        position   INTEGER NOT NULL,
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL,
+        outputs    TEXT NOT NULL,
+        PRIMARY KEY (story_id, id)
+    );
+    CREATE TABLE revisions (
+        seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+        id         TEXT NOT NULL,
+        story_id   TEXT NOT NULL REFERENCES stories(id),
+        author     TEXT NOT NULL,
+        message    TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        blocks     TEXT NOT NULL
+    );
+    CREATE INDEX revisions_story ON revisions(story_id, seq);
+    CREATE TABLE comments (
+        seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+        id         TEXT NOT NULL,
+        story_id   TEXT NOT NULL REFERENCES stories(id),
+        block_id   TEXT NOT NULL,
+        author     TEXT NOT NULL,
+        body       TEXT NOT NULL,
+        created_at TEXT NOT NULL
+    );
+    CREATE INDEX comments_story ON comments(story_id, seq);`,
+    `CREATE TABLE executions (
+        seq           INTEGER PRIMARY KEY AUTOINCREMENT,
+        id            TEXT NOT NULL,
+        story_id      TEXT NOT NULL REFERENCES stories(id),
+        actor         TEXT NOT NULL,
+        status        TEXT NOT NULL,
+        base_revision TEXT NOT NULL,
+        revision      TEXT NOT NULL,
+        started_at    TEXT NOT NULL,
+        finished_at   TEXT NOT NULL,
+        logs          TEXT NOT NULL,
+        error         TEXT NOT NULL
+    );
+    CREATE INDEX executions_story ON executions(story_id, status, seq);`,
+    `ALTER TABLE executions ADD COLUMN target_scope TEXT NOT NULL DEFAULT '';
+    ALTER TABLE executions ADD COLUMN target_block TEXT NOT NULL DEFAULT '';`,
+    `CREATE TABLE schedules (
+        id          TEXT PRIMARY KEY,
+        story_id    TEXT NOT NULL REFERENCES stories(id),
+        cron        TEXT NOT NULL,
+        timezone    TEXT NOT NULL,
+        enabled     INTEGER NOT NULL,
+        created_by  TEXT NOT NULL,
+        created_at  TEXT NOT NULL,
+        updated_at  TEXT NOT NULL,
+        next_run_at TEXT,
+        last_run_at TEXT,
+        last_job_id TEXT NOT NULL
+    );
+    CREATE INDEX schedules_story ON schedules(story_id, created_at);`,
+    `ALTER TABLE stories ADD COLUMN parameters TEXT NOT NULL DEFAULT 'null';
+    ALTER TABLE executions ADD COLUMN parameters TEXT NOT NULL DEFAULT 'null';`,
+    `ALTER TABLE blocks ADD COLUMN depends_on TEXT NOT NULL DEFAULT 'null';`,
+}
+
+type sqliteRepository struct {
+    db *sql.DB
+}
+
+// OpenSQLite opens the database file at path with settings suited to a single-node deployment.
+func OpenSQLite(path string) (*sql.DB, error) {
+    db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+    if err != nil {
+        return nil, err
+    }
+    // A single connection serialises writers, which keeps read-check-write sequences atomic.
+    db.SetMaxOpenConns(1)
+    return db, nil
+}
+
+// NewSQLiteRepository returns a Repository persisted in db, migrating the schema first.
+func NewSQLiteRepository(ctx context.Context, db *sql.DB) (Repository, error) {
+    if err := migrate(ctx, db); err != nil {
+        return nil, err
+    }
+    return &sqliteRepository{db: db}, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB) error {
+    if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version    INTEGER PRIMARY KEY,
+        applied_at TEXT NOT NULL
+    )`); err != nil {
+        return fmt.Errorf("story: create migrations table: %w", err)
+    }
+    var current int
+    if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+        return fmt.Errorf("story: read schema version: %w", err)
+    }
+    for version := current + 1; version <= len(migrations); version++ {
+        err := withTx(ctx, db, func(tx *sql.Tx) error {
+            if _, err := tx.ExecContext(ctx, migrations[version-1]); err != nil {
+                return err
+            }
+            _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+                version, formatTime(time.Now().UTC()))
+            return err
+        })
+        if err != nil {
+            return fmt.Errorf("story: apply migration %d: %w", version, err)
+        }
+    }
+    return nil
+}
+
+func (r *sqliteRepository) Create(ctx context.Context, story Story) error {
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if err := writeStoryRow(ctx, tx, story); err != nil {
+            return err
+        }
+        if err := writeBlocks(ctx, tx, story.ID, story.Blocks); err != nil {
+            return err
+        }
+        return replaceComments(ctx, tx, story.ID, story.Comments)
+    })
+}
+
+func (r *sqliteRepository) Update(ctx context.Context, story Story) error {
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, story.ID); err != nil {
+            return err
+        }
+        if err := writeStoryRow(ctx, tx, story); err != nil {
+            return err
+        }
+        if err := writeBlocks(ctx, tx, story.ID, story.Blocks); err != nil {
+            return err
+        }
+        return replaceComments(ctx, tx, story.ID, story.Comments)
+    })
+}
+
+func (r *sqliteRepository) Get(ctx context.Context, id string) (Story, error) {
+    var story Story
+    err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+        var err error
+        story, err = readStory(ctx, tx, id)
+        return err
+    })
+    return story, err
+}
+
+func (r *sqliteRepository) List(ctx context.Context) ([]Story, error) {
+    var stories []Story
+    err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+        rows, err := tx.QueryContext(ctx, `SELECT id FROM stories ORDER BY created_at DESC`)
+        if err != nil {
+            return err
+        }
+        var ids []string
+        for rows.Next() {
+            var id string
+            if err := rows.Scan(&id); err != nil {
+                rows.Close()
+                return err
+            }
+            ids = append(ids, id)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+        stories = make([]Story, 0, len(ids))
+        for _, id := range ids {
+            story, err := readStory(ctx, tx, id)
+            if err != nil {
+                return err
+            }
+            stories = append(stories, story)
+        }
+        return nil
+    })
+    return stories, err
+}
+
+func (r *sqliteRepository) AppendRevision(ctx context.Context, revision Revision) error {
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, revision.StoryID); err != nil {
+            return err
+        }
+        if err := insertRevision(ctx, tx, revision); err != nil {
+            return err
+        }
+        if _, err := tx.ExecContext(ctx, `UPDATE stories SET revision_id = ? WHERE id = ?`, revision.ID, revision.StoryID); err != nil {
+            return err
+        }
+        return writeBlocks(ctx, tx, revision.StoryID, revision.Blocks)
+    })
+}
+
+func (r *sqliteRepository) Commit(ctx context.Context, story Story, revision Revision, expectedRevisionID string) error {
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        current, err := currentRevision(ctx, tx, story.ID)
+        if err != nil {
+            return err
+        }
+        if current != expectedRevisionID {
+            return ErrConflict
+        }
+        story.RevisionID = revision.ID
+        if err := writeStoryRow(ctx, tx, story); err != nil {
+            return err
+        }
+        if err := writeBlocks(ctx, tx, story.ID, story.Blocks); err != nil {
+            return err
+        }
+        _, err = tx.ExecContext(ctx, `UPDATE comments SET block_id = ''
+            WHERE story_id = ? AND block_id <> '' AND block_id NOT IN (SELECT id FROM blocks WHERE story_id = ?)`,
+            story.ID, story.ID)
+        if err != nil {
+            return err
+        }
+        return insertRevision(ctx, tx, revision)
+    })
+}
+
+func (r *sqliteRepository) ListRevisions(ctx context.Context, storyID string) ([]Revision, error) {
+    rows, err := r.db.QueryContext(ctx, `SELECT id, story_id, author, message, created_at, blocks
+        FROM revisions WHERE story_id = ? ORDER BY seq`, storyID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    revisions := []Revision{}
+    for rows.Next() {
+        revision, err := scanRevision(rows)
+        if err != nil {
+            return nil, err
+        }
+        revisions = append(revisions, revision)
+    }
+    return revisions, rows.Err()
+}
+
+func (r *sqliteRepository) GetRevision(ctx context.Context, storyID, revisionID string) (Revision, error) {
+    var revision Revision
+    err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, storyID); err != nil {
+            return err
+        }
+        row := tx.QueryRowContext(ctx, `SELECT id, story_id, author, message, created_at, blocks
+            FROM revisions WHERE story_id = ? AND id = ? ORDER BY seq DESC LIMIT 1`, storyID, revisionID)
+        var err error
+        revision, err = scanRevision(row)
+        if err == sql.ErrNoRows {
+            return ErrRevisionNotFound
+        }
+        return err
+    })
+    return revision, err
+}
+
+func (r *sqliteRepository) AppendComment(ctx context.Context, comment Comment) error {
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, comment.StoryID); err != nil {
+            return err
+        }
+        return insertComments(ctx, tx, comment.StoryID, []Comment{comment})
+    })
+}
+
+func (r *sqliteRepository) AppendExecution(ctx context.Context, execution Execution) error {
+    logs, err := json.Marshal(execution.Logs)
+    if err != nil {
+        return err
+    }
+    params, err := json.Marshal(execution.Parameters)
+    if err != nil {
+        return err
+    }
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, execution.StoryID); err != nil {
+            return err
+        }
+        _, err := tx.ExecContext(ctx, `INSERT INTO executions
+            (id, story_id, actor, status, base_revision, revision, target_scope, target_block, started_at, finished_at, logs, error, parameters)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            execution.ID, execution.StoryID, execution.Actor, execution.Status, execution.BaseRevision, execution.Revision,
+            string(execution.Target.Scope), execution.Target.BlockID,
+            formatTime(execution.StartedAt), formatTime(execution.FinishedAt), string(logs), execution.Error, string(params))
+        return err
+    })
+}
+
+func (r *sqliteRepository) ListExecutions(ctx context.Context, storyID string, filter ExecutionFilter) ([]Execution, int, error) {
+    var (
+        executions []Execution
+        total      int
+    )
+    limit := filter.Limit
+    if limit <= 0 {
+        limit = -1
+    }
+    err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, storyID); err != nil {
+            return err
+        }
+        if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM executions
+            WHERE story_id = ? AND (? = '' OR status = ?)`, storyID, filter.Status, filter.Status).Scan(&total); err != nil {
+            return err
+        }
+        rows, err := tx.QueryContext(ctx, `SELECT id, story_id, actor, status, base_revision, revision,
+            target_scope, target_block, started_at, finished_at, logs, error, parameters
+            FROM executions WHERE story_id = ? AND (? = '' OR status = ?)
+            ORDER BY seq DESC LIMIT ? OFFSET ?`, storyID, filter.Status, filter.Status, limit, filter.Offset)
+        if err != nil {
+            return err
+        }
+        defer rows.Close()
+        for rows.Next() {
+            execution, err := scanExecution(rows)
+            if err != nil {
+                return err
+            }
+            executions = append(executions, execution)
+        }
+        return rows.Err()
+    })
+    return executions, total, err
+}
+
+func (r *sqliteRepository) SaveSchedule(ctx context.Context, schedule Schedule) error {
+    return withTx(ctx, r.db, func(tx *sql.Tx) error {
+        if _, err := currentRevision(ctx, tx, schedule.StoryID); err != nil {
+            return err
+        }
+        _, err := tx.ExecContext(ctx, `INSERT INTO schedules
+            (id, story_id, cron, timezone, enabled, created_by, created_at, updated_at, next_run_at, last_run_at, last_job_id)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT(id) DO UPDATE SET
+                story_id = excluded.story_id, cron = excluded.cron, timezone = excluded.timezone,
+                enabled = excluded.enabled, created_by = excluded.created_by, created_at = excluded.created_at,
+                updated_at = excluded.updated_at, next_run_at = excluded.next_run_at,
+                last_run_at = excluded.last_run_at, last_job_id = excluded.last_job_id`,
+            schedule.ID, schedule.StoryID, schedule.Cron, schedule.Timezone, schedule.Enabled, schedule.CreatedBy,
+            formatTime(schedule.CreatedAt), formatTime(schedule.UpdatedAt),
+            formatOptionalTime(schedule.NextRunAt), formatOptionalTime(schedule.LastRunAt), schedule.LastJobID)
+        return err
+    })
+}
+
+func (r *sqliteRepository) GetSchedule(ctx context.Context, id string) (Schedule, error) {
+    row := r.db.QueryRowContext(ctx, `SELECT id, story_id, cron, timezone, enabled, created_by, created_at, updated_at,
+        next_run_at, last_run_at, last_job_id FROM schedules WHERE id = ?`, id)
+    schedule, err := scanSchedule(row)
+    if err == sql.ErrNoRows {
+        return Schedule{}, ErrScheduleNotFound
+    }
+    return schedule, err
+}
+
+func (r *sqliteRepository) ListSchedules(ctx context.Context, storyID string) ([]Schedule, error) {
+    rows, err := r.db.QueryContext(ctx, `SELECT id, story_id, cron, timezone, enabled, created_by, created_at, updated_at,
+        next_run_at, last_run_at, last_job_id FROM schedules WHERE ? = '' OR story_id = ?
+        ORDER BY created_at, id`, storyID, storyID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    schedules := []Schedule{}
+    for rows.Next() {
+        schedule, err := scanSchedule(rows)
+        if err != nil {
+            return nil, err
+        }
+        schedules = append(schedules, schedule)
+    }
+    return schedules, rows.Err()
+}
+
+func (r *sqliteRepository) DeleteSchedule(ctx context.Context, id string) error {
+    res, err := r.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = ?`, id)
+    if err != nil {
+        return err
+    }
+    n, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrScheduleNotFound
+    }
+    return nil
+}
+
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    if err := fn(tx); err != nil {
+        _ = tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+func currentRevision(ctx context.Context, tx *sql.Tx, storyID string) (string, error) {
+    var revisionID string
+    err := tx.QueryRowContext(ctx, `SELECT revision_id FROM stories WHERE id = ?`, storyID).Scan(&revisionID)
+    if err == sql.ErrNoRows {
+        return "", ErrNotFound
+    }
+    return revisionID, err
+}
+
+func writeStoryRow(ctx context.Context, tx *sql.Tx, story Story) error {
+    owners, err := json.Marshal(story.Owners)
+    if err != nil {
+        return err
+    }
+    tags, err := json.Marshal(story.Tags)
+    if err != nil {
+        return err
+    }
+    params, err := json.Marshal(story.Parameters)
+    if err != nil {
+        return err
+    }
+    _, err = tx.ExecContext(ctx, `INSERT INTO stories
+        (id, title, description, owners, visibility, revision_id, tags, parameters, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(id) DO UPDATE SET
+            title = excluded.title, description = excluded.description, owners = excluded.owners,
+            visibility = excluded.visibility, revision_id = excluded.revision_id, tags = excluded.tags,
+            parameters = excluded.parameters, created_at = excluded.created_at, updated_at = excluded.updated_at`,
+        story.ID, story.Title, story.Description, string(owners), string(story.Visibility),
+        story.RevisionID, string(tags), string(params), formatTime(story.CreatedAt), formatTime(story.UpdatedAt))
+    return err
+}
+
+// writeBlocks replaces every block stored for the story; insertion order preserves slice order.
+func writeBlocks(ctx context.Context, tx *sql.Tx, storyID string, blocks []Block) error {
+    if _, err := tx.ExecContext(ctx, `DELETE FROM blocks WHERE story_id = ?`, storyID); err != nil {
+        return err
+    }
+    for _, block := range blocks {
+        outputs, err := json.Marshal(block.Outputs)
+        if err != nil {
+            return err
+        }
+        dependsOn, err := json.Marshal(block.DependsOn)
+        if err != nil {
+            return err
+        }
+        _, err = tx.ExecContext(ctx, `INSERT INTO blocks
+            (story_id, id, type, language, source, position, created_at, updated_at, outputs, depends_on)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            storyID, block.ID, string(block.Type), block.Language, block.Source, block.Position,
+            formatTime(block.CreatedAt), formatTime(block.UpdatedAt), string(outputs), string(dependsOn))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// replaceComments swaps the story's stored comments for the given set.
+func replaceComments(ctx context.Context, tx *sql.Tx, storyID string, comments []Comment) error {
+    if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE story_id = ?`, storyID); err != nil {
+        return err
+    }
+    return insertComments(ctx, tx, storyID, comments)
+}
+
+func insertComments(ctx context.Context, tx *sql.Tx, storyID string, comments []Comment) error {
+    for _, comment := range comments {
+        _, err := tx.ExecContext(ctx, `INSERT INTO comments (id, story_id, block_id, author, body, created_at)
+            VALUES (?, ?, ?, ?, ?, ?)`,
+            comment.ID, storyID, comment.BlockID, comment.Author, comment.Body, formatTime(comment.CreatedAt))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func insertRevision(ctx context.Context, tx *sql.Tx, revision Revision) error {
+    blocks, err := json.Marshal(revision.Blocks)
+    if err != nil {
+        return err
+    }
+    _, err = tx.ExecContext(ctx, `INSERT INTO revisions (id, story_id, author, message, created_at, blocks)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+        revision.ID, revision.StoryID, revision.Author, revision.Message, formatTime(revision.CreatedAt), string(blocks))
+    return err
+}
+
+func readStory(ctx context.Context, tx *sql.Tx, id string) (Story, error) {
+    var (
+        story                Story
+        owners, tags, params string
+        visibility           string
+        createdAt, updatedAt string
+    )
+    err := tx.QueryRowContext(ctx, `SELECT id, title, description, owners, visibility, revision_id, tags, parameters,
+        created_at, updated_at FROM stories WHERE id = ?`, id).Scan(&story.ID, &story.Title, &story.Description, &owners,
+        &visibility, &story.RevisionID, &tags, &params, &createdAt, &updatedAt)
+    if err == sql.ErrNoRows {
+        return Story{}, ErrNotFound
+    }
+    if err != nil {
+        return Story{}, err
+    }
+    story.Visibility = Visibility(visibility)
+    if err := json.Unmarshal([]byte(owners), &story.Owners); err != nil {
+        return Story{}, err
+    }
+    if err := json.Unmarshal([]byte(tags), &story.Tags); err != nil {
+        return Story{}, err
+    }
+    // Match the memory store, which hands back nil rather than empty slices.
+    if len(story.Owners) == 0 {
+        story.Owners = nil
+    }
+    if len(story.Tags) == 0 {
+        story.Tags = nil
+    }
+    if err := json.Unmarshal([]byte(params), &story.Parameters); err != nil {
+        return Story{}, err
+    }
+    if len(story.Parameters) == 0 {
+        story.Parameters = nil
+    }
+    if story.CreatedAt, err = parseTime(createdAt); err != nil {
+        return Story{}, err
+    }
+    if story.UpdatedAt, err = parseTime(updatedAt); err != nil {
+        return Story{}, err
+    }
+    if story.Blocks, err = readBlocks(ctx, tx, id); err != nil {
+        return Story{}, err
+    }
+    if story.Comments, err = readComments(ctx, tx, id); err != nil {
+        return Story{}, err
+    }
+    return story, nil
+}
+
+func readBlocks(ctx context.Context, tx *sql.Tx, storyID string) ([]Block, error) {
+    rows, err := tx.QueryContext(ctx, `SELECT id, type, language, source, position, created_at, updated_at, outputs, depends_on
+        FROM blocks WHERE story_id = ? ORDER BY rowid`, storyID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var blocks []Block
+    for rows.Next() {
+        var (
+            b                    Block
+            blockType            string
+            createdAt, updatedAt string
+            outputs, dependsOn   string
+        )
+        if err := rows.Scan(&b.ID, &blockType, &b.Language, &b.Source, &b.Position, &createdAt, &updatedAt, &outputs, &dependsOn); err != nil {
+            return nil, err
+        }
+        b.Type = BlockType(blockType)
+        if b.CreatedAt, err = parseTime(createdAt); err != nil {
+            return nil, err
+        }
+        if b.UpdatedAt, err = parseTime(updatedAt); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal([]byte(outputs), &b.Outputs); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal([]byte(dependsOn), &b.DependsOn); err != nil {
+            return nil, err
+        }
+        blocks = append(blocks, b)
+    }
+    return blocks, rows.Err()
+}
+
+func readComments(ctx context.Context, tx *sql.Tx, storyID string) ([]Comment, error) {
+    rows, err := tx.QueryContext(ctx, `SELECT id, story_id, block_id, author, body, created_at
+        FROM comments WHERE story_id = ? ORDER BY seq`, storyID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var comments []Comment
+    for rows.Next() {
+        var (
+            comment   Comment
+            createdAt string
+        )
+        if err := rows.Scan(&comment.ID, &comment.StoryID, &comment.BlockID, &comment.Author, &comment.Body, &createdAt); err != nil {
+            return nil, err
+        }
+        if comment.CreatedAt, err = parseTime(createdAt); err != nil {
+            return nil, err
+        }
+        comments = append(comments, comment)
+    }
+    return comments, rows.Err()
+}
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanRevision(row rowScanner) (Revision, error) {
+    var (
+        revision  Revision
+        createdAt string
+        blocks    string
+    )
+    if err := row.Scan(&revision.ID, &revision.StoryID, &revision.Author, &revision.Message, &createdAt, &blocks); err != nil {
+        return Revision{}, err
+    }
+    var err error
+    if revision.CreatedAt, err = parseTime(createdAt); err != nil {
+        return Revision{}, err
+    }
+    if err := json.Unmarshal([]byte(blocks), &revision.Blocks); err != nil {
+        return Revision{}, err
+    }
+    if len(revision.Blocks) == 0 {
+        revision.Blocks = nil
+    }
+    return revision, nil
+}
+
+func scanExecution(row rowScanner) (Execution, error) {
+    var (
+        execution             Execution
+        scope                 string
+        startedAt, finishedAt string
+        logs, params          string
+    )
+    if err := row.Scan(&execution.ID, &execution.StoryID, &execution.Actor, &execution.Status, &execution.BaseRevision,
+        &execution.Revision, &scope, &execution.Target.BlockID, &startedAt, &finishedAt, &logs, &execution.Error,
+        &params); err != nil {
+        return Execution{}, err
+    }
+    execution.Target.Scope = ExecutionScope(scope)
+    var err error
+    if execution.StartedAt, err = parseTime(startedAt); err != nil {
+        return Execution{}, err
+    }
+    if execution.FinishedAt, err = parseTime(finishedAt); err != nil {
+        return Execution{}, err
+    }
+    if err := json.Unmarshal([]byte(logs), &execution.Logs); err != nil {
+        return Execution{}, err
+    }
+    if len(execution.Logs) == 0 {
+        execution.Logs = nil
+    }
+    if err := json.Unmarshal([]byte(params), &execution.Parameters); err != nil {
+        return Execution{}, err
+    }
+    return execution, nil
+}
+
+func scanSchedule(row rowScanner) (Schedule, error) {
+    var (
+        schedule             Schedule
+        createdAt, updatedAt string
+        nextRunAt, lastRunAt sql.NullString
+    )
+    if err := row.Scan(&schedule.ID, &schedule.StoryID, &schedule.Cron, &schedule.Timezone, &schedule.Enabled,
+        &schedule.CreatedBy, &createdAt, &updatedAt, &nextRunAt, &lastRunAt, &schedule.LastJobID); err != nil {
+        return Schedule{}, err
+    }
+    var err error
+    if schedule.CreatedAt, err = parseTime(createdAt); err != nil {
+        return Schedule{}, err
+    }
+    if schedule.UpdatedAt, err = parseTime(updatedAt); err != nil {
+        return Schedule{}, err
+    }
+    if schedule.NextRunAt, err = parseOptionalTime(nextRunAt); err != nil {
+        return Schedule{}, err
+    }
+    if schedule.LastRunAt, err = parseOptionalTime(lastRunAt); err != nil {
+        return Schedule{}, err
+    }
+    return schedule, nil
+}
+
+func formatOptionalTime(t *time.Time) interface{} {
+    if t == nil {
+        return nil
+    }
+    return formatTime(*t)
+}
+
+func parseOptionalTime(value sql.NullString) (*time.Time, error) {
+    if !value.Valid {
+        return nil, nil
+    }
+    t, err := parseTime(value.String)
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
+
+func formatTime(t time.Time) string {
+    return t.UTC().Format(timeLayout)
+}
+
+func parseTime(value string) (time.Time, error) {
+    return time.Parse(timeLayout, value)
+}
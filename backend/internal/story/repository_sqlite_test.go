@@ -0,0 +1,25 @@
+package story_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/multistory/internal/story"
+	"github.com/example/multistory/internal/story/storytest"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	storytest.RunRepositoryTests(t, func(t *testing.T) story.Repository {
+		db, err := story.OpenSQLite(filepath.Join(t.TempDir(), "stories.db"))
+		if err != nil {
+			t.Fatalf("OpenSQLite: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		repo, err := story.NewSQLiteRepository(context.Background(), db)
+		if err != nil {
+			t.Fatalf("NewSQLiteRepository: %v", err)
+		}
+		return repo
+	})
+}
@@ -2,6 +2,7 @@ package story
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"time"
 
@@ -9,24 +10,40 @@ import (
 	"github.com/example/multistory/pkg/id"
 )
 
+// maxCommitAttempts bounds how often an unpinned edit is re-applied after losing a race.
+const maxCommitAttempts = 3
+
+// Execution history paging bounds.
+const (
+	defaultExecutionPage = 20
+	maxExecutionPage     = 100
+)
+
 type service struct {
 	repo   Repository
 	runner Runner
 	hub    *realtime.Hub
+	cache  OutputCache
 	now    func() time.Time
 }
 
-// NewService wires dependencies for high-level operations on stories.
-func NewService(repo Repository, runner Runner, hub *realtime.Hub) Service {
+// NewService wires dependencies for high-level operations on stories. cache may be nil to run
+// every block every time.
+func NewService(repo Repository, runner Runner, hub *realtime.Hub, cache OutputCache) Service {
 	return &service{
 		repo:   repo,
 		runner: runner,
 		hub:    hub,
+		cache:  cache,
 		now:    func() time.Time { return time.Now().UTC() },
 	}
 }
 
 func (s *service) CreateStory(ctx context.Context, input CreateStoryInput) (Story, error) {
+	params, err := NormalizeParameters(input.Parameters)
+	if err != nil {
+		return Story{}, err
+	}
 	story := Story{
 		ID:          id.New(),
 		Title:       input.Title,
@@ -34,6 +51,7 @@ func (s *service) CreateStory(ctx context.Context, input CreateStoryInput) (Stor
 		Owners:      append([]string(nil), input.Owners...),
 		Visibility:  input.Visibility,
 		Tags:        append([]string(nil), input.Tags...),
+		Parameters:  params,
 		CreatedAt:   s.now(),
 		UpdatedAt:   s.now(),
 	}
@@ -54,6 +72,7 @@ func (s *service) CreateStory(ctx context.Context, input CreateStoryInput) (Stor
 	}); err != nil {
 		return Story{}, err
 	}
+	story = validated(story)
 	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "story.created", Payload: story})
 	return story, nil
 }
@@ -63,6 +82,9 @@ func (s *service) ListStories(ctx context.Context, filter Filter) ([]Story, erro
 	if err != nil {
 		return nil, err
 	}
+	for i := range stories {
+		stories[i] = validated(stories[i])
+	}
 	if filter == (Filter{}) {
 		return stories, nil
 	}
@@ -83,33 +105,176 @@ func (s *service) ListStories(ctx context.Context, filter Filter) ([]Story, erro
 }
 
 func (s *service) GetStory(ctx context.Context, id string) (Story, error) {
-	return s.repo.Get(ctx, id)
+	story, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Story{}, err
+	}
+	return validated(story), nil
 }
 
 func (s *service) AppendBlock(ctx context.Context, storyID string, input BlockInput) (Story, error) {
-	story, err := s.repo.Get(ctx, storyID)
+	block := s.newBlock(input, 0)
+	story, err := s.mutate(ctx, storyID, input.ExpectedRevision, input.Author, orDefault(input.Message, "Added block"), func(story *Story) error {
+		if input.Position > 0 && input.Position <= len(story.Blocks) {
+			pos := input.Position - 1
+			story.Blocks = append(story.Blocks[:pos], append([]Block{block}, story.Blocks[pos:]...)...)
+		} else {
+			story.Blocks = append(story.Blocks, block)
+		}
+		for idx := range story.Blocks {
+			story.Blocks[idx].Position = idx
+			story.Blocks[idx].UpdatedAt = s.now()
+		}
+		return nil
+	})
+	if err != nil {
+		return Story{}, err
+	}
+	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "story.updated", Payload: story})
+	return story, nil
+}
+
+func (s *service) UpdateBlock(ctx context.Context, storyID string, blockID string, input BlockPatch) (Story, error) {
+	story, err := s.mutate(ctx, storyID, input.ExpectedRevision, input.Author, orDefault(input.Message, "Edited block"), func(story *Story) error {
+		idx := blockIndex(story.Blocks, blockID)
+		if idx == -1 {
+			return ErrBlockNotFound
+		}
+		block := &story.Blocks[idx]
+		if input.Type != nil {
+			block.Type = *input.Type
+		}
+		if input.Language != nil {
+			block.Language = *input.Language
+		}
+		if input.Source != nil {
+			block.Source = *input.Source
+		}
+		if input.DependsOn != nil {
+			block.DependsOn = dependencies(*input.DependsOn)
+		}
+		block.UpdatedAt = s.now()
+		s.renumber(story.Blocks)
+		return nil
+	})
 	if err != nil {
 		return Story{}, err
 	}
-	block := s.newBlock(input, len(story.Blocks))
-	if input.Position > 0 && input.Position <= len(story.Blocks) {
-		pos := input.Position - 1
+	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "block.updated", Payload: story})
+	return story, nil
+}
+
+func (s *service) DeleteBlock(ctx context.Context, storyID string, blockID string, opts WriteOptions) (Story, error) {
+	story, err := s.mutate(ctx, storyID, opts.ExpectedRevision, opts.Actor, "Deleted block", func(story *Story) error {
+		idx := blockIndex(story.Blocks, blockID)
+		if idx == -1 {
+			return ErrBlockNotFound
+		}
+		story.Blocks = append(story.Blocks[:idx], story.Blocks[idx+1:]...)
+		s.renumber(story.Blocks)
+		return nil
+	})
+	if err != nil {
+		return Story{}, err
+	}
+	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "block.deleted", Payload: story})
+	return story, nil
+}
+
+// MoveBlock places the block at the zero-based position, clamping out-of-range values to the ends.
+func (s *service) MoveBlock(ctx context.Context, storyID string, blockID string, position int, opts WriteOptions) (Story, error) {
+	story, err := s.mutate(ctx, storyID, opts.ExpectedRevision, opts.Actor, "Moved block", func(story *Story) error {
+		idx := blockIndex(story.Blocks, blockID)
+		if idx == -1 {
+			return ErrBlockNotFound
+		}
+		block := story.Blocks[idx]
+		story.Blocks = append(story.Blocks[:idx], story.Blocks[idx+1:]...)
+		pos := position
+		if pos < 0 {
+			pos = 0
+		}
+		if pos > len(story.Blocks) {
+			pos = len(story.Blocks)
+		}
 		story.Blocks = append(story.Blocks[:pos], append([]Block{block}, story.Blocks[pos:]...)...)
-	} else {
-		story.Blocks = append(story.Blocks, block)
+		s.renumber(story.Blocks)
+		return nil
+	})
+	if err != nil {
+		return Story{}, err
 	}
-	for idx := range story.Blocks {
-		story.Blocks[idx].Position = idx
-		story.Blocks[idx].UpdatedAt = s.now()
+	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "block.moved", Payload: story})
+	return story, nil
+}
+
+func (s *service) ListRevisions(ctx context.Context, storyID string) ([]Revision, error) {
+	if _, err := s.repo.Get(ctx, storyID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListRevisions(ctx, storyID)
+}
+
+func (s *service) GetRevision(ctx context.Context, storyID string, revisionID string) (Revision, error) {
+	return s.repo.GetRevision(ctx, storyID, revisionID)
+}
+
+func (s *service) DiffRevisions(ctx context.Context, storyID string, fromID string, toID string) (RevisionDiff, error) {
+	from, err := s.repo.GetRevision(ctx, storyID, fromID)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	to, err := s.repo.GetRevision(ctx, storyID, toID)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	return DiffRevisions(from, to), nil
+}
+
+// RestoreRevision copies a historical revision's blocks onto the story as a new revision,
+// leaving the existing history untouched.
+func (s *service) RestoreRevision(ctx context.Context, storyID string, revisionID string, opts WriteOptions) (Story, error) {
+	revision, err := s.repo.GetRevision(ctx, storyID, revisionID)
+	if err != nil {
+		return Story{}, err
+	}
+	story, err := s.mutate(ctx, storyID, opts.ExpectedRevision, opts.Actor, "Restored revision "+revision.ID, func(story *Story) error {
+		story.Blocks = append([]Block(nil), revision.Blocks...)
+		return nil
+	})
+	if err != nil {
+		return Story{}, err
+	}
+	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "story.restored", Payload: story})
+	return story, nil
+}
+
+// UpdateParameters replaces the story's parameter definitions.
+func (s *service) UpdateParameters(ctx context.Context, storyID string, params []Parameter, opts WriteOptions) (Story, error) {
+	params, err := NormalizeParameters(params)
+	if err != nil {
+		return Story{}, err
 	}
-	story.UpdatedAt = s.now()
-	if err := s.repo.Update(ctx, story); err != nil {
+	story, err := s.mutate(ctx, storyID, opts.ExpectedRevision, opts.Actor, "Updated parameters", func(story *Story) error {
+		story.Parameters = params
+		return nil
+	})
+	if err != nil {
 		return Story{}, err
 	}
 	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "story.updated", Payload: story})
 	return story, nil
 }
 
+// StoryGraph analyses the story's code blocks and returns their dependency graph.
+func (s *service) StoryGraph(ctx context.Context, storyID string) (Graph, error) {
+	story, err := s.repo.Get(ctx, storyID)
+	if err != nil {
+		return Graph{}, err
+	}
+	return BuildGraph(story.Blocks, story.Parameters), nil
+}
+
 func (s *service) RecordComment(ctx context.Context, storyID string, input CommentInput) (Story, error) {
 	story, err := s.repo.Get(ctx, storyID)
 	if err != nil {
@@ -128,33 +293,208 @@ func (s *service) RecordComment(ctx context.Context, storyID string, input Comme
 	}
 	story.Comments = append(story.Comments, comment)
 	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "comment.created", Payload: comment})
-	return story, nil
+	return validated(story), nil
 }
 
-func (s *service) ExecuteStory(ctx context.Context, id string, actor string) (ExecutionResult, error) {
+// ExecuteStory runs the targeted blocks and merges their outputs into the latest story, so edits made
+// while the run was in flight are kept. Unless opts.Force is set, blocks whose inputs match an
+// earlier successful run are offered to the runner from the cache. Parameter values are checked
+// against the story's definitions before anything runs.
+func (s *service) ExecuteStory(ctx context.Context, id string, opts ExecuteOptions) (ExecutionResult, error) {
 	story, err := s.repo.Get(ctx, id)
 	if err != nil {
 		return ExecutionResult{}, err
 	}
-	result, err := s.runner.Execute(ctx, ExecutionRequest{Story: story, Actor: actor})
+	selected, err := opts.Target.Select(story.Blocks, story.Parameters)
 	if err != nil {
 		return ExecutionResult{}, err
 	}
-	revision := Revision{
-		ID:        result.Revision,
-		StoryID:   story.ID,
-		Author:    actor,
-		Message:   "Automated execution",
-		CreatedAt: result.FinishedAt,
-		Blocks:    result.Blocks,
+	params, err := ResolveParameters(story.Parameters, opts.Parameters)
+	if err != nil {
+		return ExecutionResult{}, err
+	}
+	keys := BlockKeys(story.Blocks, params)
+	var cached map[string][]Output
+	if s.cache != nil && !opts.Force {
+		cached = make(map[string][]Output)
+		for blockID := range selected {
+			if key, ok := keys[blockID]; ok {
+				if out, ok := s.cache.Get(key); ok {
+					cached[blockID] = out
+				}
+			}
+		}
+	}
+	started := s.now()
+	result, err := s.runner.Execute(ctx, ExecutionRequest{
+		Story:      story,
+		Actor:      opts.Actor,
+		Target:     opts.Target,
+		Parameters: params,
+		Cached:     cached,
+		Progress: func(ev BlockEvent) {
+			s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "block.execution." + string(ev.Kind), Payload: ev})
+		},
+	})
+	if err != nil {
+		status := "failed"
+		if errors.Is(err, context.Canceled) {
+			status = "cancelled"
+		}
+		// The run already failed; a history write error would only hide why.
+		_ = s.recordExecution(ctx, Execution{
+			StoryID:      story.ID,
+			Actor:        opts.Actor,
+			Status:       status,
+			BaseRevision: story.RevisionID,
+			Target:       opts.Target,
+			StartedAt:    started,
+			FinishedAt:   s.now(),
+			Error:        err.Error(),
+			Parameters:   params,
+		})
+		return ExecutionResult{}, err
+	}
+	outputs := make(map[string][]Output, len(selected))
+	for _, block := range result.Blocks {
+		if selected[block.ID] {
+			outputs[block.ID] = block.Outputs
+		}
+	}
+	// Only a completed run guarantees every targeted block produced its outputs from these inputs.
+	if s.cache != nil && result.Status == "completed" {
+		for blockID, out := range outputs {
+			if key, ok := keys[blockID]; ok {
+				s.cache.Put(key, out)
+			}
+		}
 	}
-	if err := s.repo.AppendRevision(ctx, revision); err != nil {
+	updated, err := s.mutate(ctx, story.ID, "", opts.Actor, "Automated execution", func(current *Story) error {
+		for i := range current.Blocks {
+			if out, ok := outputs[current.Blocks[i].ID]; ok {
+				current.Blocks[i].Outputs = out
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ExecutionResult{}, err
+	}
+	result.Revision = updated.RevisionID
+	result.Parameters = params
+	err = s.recordExecution(ctx, Execution{
+		StoryID:      story.ID,
+		Actor:        opts.Actor,
+		Status:       result.Status,
+		BaseRevision: story.RevisionID,
+		Revision:     result.Revision,
+		Target:       opts.Target,
+		StartedAt:    result.StartedAt,
+		FinishedAt:   result.FinishedAt,
+		Logs:         result.Logs,
+		Parameters:   params,
+	})
+	if err != nil {
 		return ExecutionResult{}, err
 	}
 	s.hub.Publish(realtime.Event{StoryID: story.ID, Type: "story.executed", Payload: result})
 	return result, nil
 }
 
+func (s *service) ListExecutions(ctx context.Context, id string, filter ExecutionFilter) (ExecutionPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultExecutionPage
+	}
+	if filter.Limit > maxExecutionPage {
+		filter.Limit = maxExecutionPage
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	executions, total, err := s.repo.ListExecutions(ctx, id, filter)
+	if err != nil {
+		return ExecutionPage{}, err
+	}
+	if executions == nil {
+		executions = []Execution{}
+	}
+	return ExecutionPage{Executions: executions, Total: total, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
+
+// recordExecution stores the run in the story's history. It ignores cancellation of ctx so that
+// aborted runs are recorded too.
+func (s *service) recordExecution(ctx context.Context, execution Execution) error {
+	execution.ID = id.New()
+	execution.Actor = orDefault(execution.Actor, "anonymous")
+	return s.repo.AppendExecution(context.WithoutCancel(ctx), execution)
+}
+
+// RestartKernel clears interpreter state the runner keeps for the story.
+func (s *service) RestartKernel(ctx context.Context, id string, actor string) error {
+	if _, err := s.repo.Get(ctx, id); err != nil {
+		return err
+	}
+	restarter, ok := s.runner.(SessionRestarter)
+	if !ok {
+		return ErrNoSessions
+	}
+	if err := restarter.RestartSessions(ctx, id); err != nil {
+		return err
+	}
+	s.hub.Publish(realtime.Event{StoryID: id, Type: "kernel.restarted", Payload: map[string]string{"actor": actor}})
+	return nil
+}
+
+// mutate applies an edit to the latest story state and commits it as a new revision. When the caller
+// pins an expected revision a stale base fails with a ConflictError; otherwise the edit is re-applied
+// on top of whichever concurrent write won.
+func (s *service) mutate(ctx context.Context, storyID, expected, author, message string, apply func(*Story) error) (Story, error) {
+	for attempt := 0; ; attempt++ {
+		story, err := s.repo.Get(ctx, storyID)
+		if err != nil {
+			return Story{}, err
+		}
+		if expected != "" && story.RevisionID != expected {
+			return Story{}, &ConflictError{Current: validated(story)}
+		}
+		base := story.RevisionID
+		if err := apply(&story); err != nil {
+			return Story{}, err
+		}
+		// Mirror what Commit does to comments on removed blocks.
+		story.Comments = reanchorComments(story.Comments, story.Blocks)
+		story.RevisionID = id.New()
+		story.UpdatedAt = s.now()
+		err = s.repo.Commit(ctx, story, Revision{
+			ID:        story.RevisionID,
+			StoryID:   story.ID,
+			Author:    orDefault(author, "anonymous"),
+			Message:   message,
+			CreatedAt: story.UpdatedAt,
+			Blocks:    append([]Block(nil), story.Blocks...),
+		}, base)
+		if err == nil {
+			return validated(story), nil
+		}
+		if err != ErrConflict {
+			return Story{}, err
+		}
+		if expected != "" || attempt >= maxCommitAttempts-1 {
+			current, err := s.repo.Get(ctx, storyID)
+			if err != nil {
+				return Story{}, err
+			}
+			return Story{}, &ConflictError{Current: validated(current)}
+		}
+	}
+}
+
+// validated fills in the story's Issues from its dependency graph.
+func validated(story Story) Story {
+	story.Issues = BuildGraph(story.Blocks, story.Parameters).Issues
+	return story
+}
+
 func (s *service) newBlock(input BlockInput, position int) Block {
 	now := s.now()
 	return Block{
@@ -162,6 +502,7 @@ func (s *service) newBlock(input BlockInput, position int) Block {
 		Type:      input.Type,
 		Language:  input.Language,
 		Source:    input.Source,
+		DependsOn: dependencies(input.DependsOn),
 		Position:  position,
 		CreatedAt: now,
 		UpdatedAt: now,
@@ -169,6 +510,38 @@ func (s *service) newBlock(input BlockInput, position int) Block {
 	}
 }
 
+// dependencies copies declared dependencies, dropping blanks and repeats; none comes back as nil.
+func dependencies(ids []string) []string {
+	var deps []string
+	for _, dep := range ids {
+		dep = strings.TrimSpace(dep)
+		if dep != "" && !contains(deps, dep) {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// renumber rewrites Position to match slice order, touching only blocks that moved.
+func (s *service) renumber(blocks []Block) {
+	now := s.now()
+	for idx := range blocks {
+		if blocks[idx].Position != idx {
+			blocks[idx].Position = idx
+			blocks[idx].UpdatedAt = now
+		}
+	}
+}
+
+func blockIndex(blocks []Block, blockID string) int {
+	for idx, block := range blocks {
+		if block.ID == blockID {
+			return idx
+		}
+	}
+	return -1
+}
+
 func contains(values []string, target string) bool {
 	for _, value := range values {
 		if value == target {
@@ -178,6 +551,13 @@ func contains(values []string, target string) bool {
 	return false
 }
 
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
 func firstOrDefault(values []string) string {
 	if len(values) == 0 {
 		return "system"
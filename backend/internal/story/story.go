@@ -3,14 +3,40 @@ package story
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 )
 
 var (
 	// ErrNotFound is returned when a story cannot be located in the repository.
 	ErrNotFound = errors.New("story: not found")
+	// ErrBlockNotFound is returned when a block ID does not belong to the story.
+	ErrBlockNotFound = errors.New("story: block not found")
+	// ErrRevisionNotFound is returned when a revision does not exist for the story.
+	ErrRevisionNotFound = errors.New("story: revision not found")
+	// ErrConflict is returned when a write was based on a revision that is no longer current.
+	ErrConflict = errors.New("story: revision conflict")
+	// ErrNoSessions is returned when the configured runner keeps no interpreter state.
+	ErrNoSessions = errors.New("story: runner has no sessions")
+	// ErrInvalidTarget is returned when an execution target names an unknown scope or omits its block.
+	ErrInvalidTarget = errors.New("story: invalid execution target")
+	// ErrCycle is returned when a downstream run is requested while blocks depend on each other.
+	ErrCycle = errors.New("story: dependency cycle")
+	// ErrScheduleNotFound is returned when a schedule ID is unknown.
+	ErrScheduleNotFound = errors.New("story: schedule not found")
 )
 
+// ConflictError reports a stale write together with the story's current state.
+type ConflictError struct {
+	Current Story
+}
+
+func (e *ConflictError) Error() string { return ErrConflict.Error() }
+
+// Unwrap lets errors.Is match ErrConflict.
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
 // Visibility controls who can view or edit a story.
 type Visibility string
 
@@ -39,6 +65,8 @@ type Block struct {
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 	Outputs   []Output  `json:"outputs"`
+	// DependsOn lists blocks this one depends on beyond those found by analysing variables.
+	DependsOn []string `json:"dependsOn,omitempty"`
 }
 
 // Output contains rendered content associated with a block execution.
@@ -69,8 +97,13 @@ type Story struct {
 	Blocks      []Block    `json:"blocks"`
 	Comments    []Comment  `json:"comments"`
 	Tags        []string   `json:"tags"`
-	CreatedAt   time.Time  `json:"createdAt"`
-	UpdatedAt   time.Time  `json:"updatedAt"`
+	// Parameters are the inputs a run can supply; see ResolveParameters.
+	Parameters []Parameter `json:"parameters,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	UpdatedAt  time.Time   `json:"updatedAt"`
+	// Issues lists the validation errors of the story's dependency graph, such as cycles and
+	// undefined references. The service derives it from the blocks; repositories do not store it.
+	Issues []GraphIssue `json:"issues,omitempty"`
 }
 
 // Revision records the state of a story at a single point in time.
@@ -92,19 +125,211 @@ type ExecutionResult struct {
 	Status     string    `json:"status"`
 	Blocks     []Block   `json:"blocks"`
 	Logs       []string  `json:"logs"`
+	// Parameters holds the value of every story parameter the run used, defaults included.
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Execution is the stored record of one run of a story.
+type Execution struct {
+	ID      string `json:"id"`
+	StoryID string `json:"storyId"`
+	Actor   string `json:"actor"`
+	// Status is "completed" or "failed" as reported by the runner, or "cancelled" when the run was aborted.
+	Status string `json:"status"`
+	// BaseRevision is the revision the run read; Revision is the one it produced, if any.
+	BaseRevision string          `json:"baseRevision"`
+	Revision     string          `json:"revision,omitempty"`
+	Target       ExecutionTarget `json:"target"`
+	StartedAt    time.Time       `json:"startedAt"`
+	FinishedAt   time.Time       `json:"finishedAt"`
+	Logs         []string        `json:"logs,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	// Parameters holds the resolved parameter values, when the story has parameters.
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ExecutionFilter selects a page of a story's executions, newest first.
+type ExecutionFilter struct {
+	// Status, when set, keeps only executions with that status.
+	Status string
+	Limit  int
+	Offset int
+}
+
+// ExecutionPage is one page of execution history.
+type ExecutionPage struct {
+	Executions []Execution `json:"executions"`
+	Total      int         `json:"total"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+}
+
+// Schedule runs a story automatically whenever its cron expression matches.
+type Schedule struct {
+	ID      string `json:"id"`
+	StoryID string `json:"storyId"`
+	// Cron is a five-field cron expression or a macro such as "@daily".
+	Cron string `json:"cron"`
+	// Timezone is the IANA zone Cron is evaluated in; empty means UTC.
+	Timezone  string     `json:"timezone,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	NextRunAt *time.Time `json:"nextRunAt,omitempty"`
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+	// LastJobID is the execution job started by the most recent trigger.
+	LastJobID string `json:"lastJobId,omitempty"`
+}
+
+// ExecutionScope says which blocks around ExecutionTarget.BlockID a run covers.
+type ExecutionScope string
+
+const (
+	ScopeAll   ExecutionScope = "all"
+	ScopeBlock ExecutionScope = "block"
+	ScopeFrom  ExecutionScope = "from"
+	ScopeUpTo  ExecutionScope = "upTo"
+	// ScopeDownstream runs the block and every block that depends on it; see BuildGraph. It is
+	// refused with ErrCycle while the graph has a cycle.
+	ScopeDownstream ExecutionScope = "downstream"
+)
+
+// ExecutionTarget narrows a run to part of the story. An empty Scope runs just BlockID when one is
+// given and the whole story otherwise.
+type ExecutionTarget struct {
+	Scope   ExecutionScope `json:"scope,omitempty"`
+	BlockID string         `json:"blockId,omitempty"`
+}
+
+// All reports whether the target covers every block.
+func (t ExecutionTarget) All() bool {
+	return t.Scope == ScopeAll || (t.Scope == "" && t.BlockID == "")
+}
+
+// Select returns the IDs of the blocks the target covers, ordering blocks by Position. params are
+// the story's parameters, which the dependency graph needs for ScopeDownstream.
+func (t ExecutionTarget) Select(blocks []Block, params []Parameter) (map[string]bool, error) {
+	selected := make(map[string]bool, len(blocks))
+	if t.All() {
+		for _, block := range blocks {
+			selected[block.ID] = true
+		}
+		return selected, nil
+	}
+	if t.BlockID == "" {
+		return nil, ErrInvalidTarget
+	}
+	ordered := append([]Block(nil), blocks...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Position < ordered[j].Position })
+	idx := blockIndex(ordered, t.BlockID)
+	if idx == -1 {
+		return nil, ErrBlockNotFound
+	}
+	var from, to int
+	switch t.Scope {
+	case "", ScopeBlock:
+		from, to = idx, idx
+	case ScopeFrom:
+		from, to = idx, len(ordered)-1
+	case ScopeUpTo:
+		from, to = 0, idx
+	case ScopeDownstream:
+		graph := BuildGraph(ordered, params)
+		for _, issue := range graph.Issues {
+			if issue.Kind == IssueCycle {
+				return nil, fmt.Errorf("%w: %s", ErrCycle, issue.Message)
+			}
+		}
+		return graph.Downstream(t.BlockID), nil
+	default:
+		return nil, ErrInvalidTarget
+	}
+	for _, block := range ordered[from : to+1] {
+		selected[block.ID] = true
+	}
+	return selected, nil
+}
+
+// ExecuteOptions describe a requested run.
+type ExecuteOptions struct {
+	Actor  string          `json:"actor"`
+	Target ExecutionTarget `json:"target"`
+	// Force runs every targeted block even when cached outputs are available.
+	Force bool `json:"force,omitempty"`
+	// Parameters supplies values for the story's parameters; missing ones take their defaults.
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
 }
 
 // ExecutionRequest captures inputs required to execute a story.
 type ExecutionRequest struct {
 	Story Story
 	Actor string
+	// Target limits the run to some blocks. Runners leave the Outputs of other blocks untouched.
+	Target ExecutionTarget
+	// Parameters holds resolved values for every story parameter. Runners define them as
+	// variables before the first code block runs.
+	Parameters map[string]interface{}
+	// Cached holds outputs, by block ID, from earlier runs of targeted blocks whose inputs have
+	// not changed. Runners may report them instead of running the block.
+	Cached map[string][]Output
+	// Progress, when set, receives per-block events as the runner works through the story.
+	Progress ProgressFunc
+}
+
+// Report forwards ev to the request's Progress callback, if any.
+func (r ExecutionRequest) Report(ev BlockEvent) {
+	if r.Progress == nil {
+		return
+	}
+	if ev.StoryID == "" {
+		ev.StoryID = r.Story.ID
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now().UTC()
+	}
+	r.Progress(ev)
 }
 
+// BlockEventKind names a step in a single block's execution.
+type BlockEventKind string
+
+const (
+	BlockEventStarted  BlockEventKind = "started"
+	BlockEventStdout   BlockEventKind = "stdout"
+	BlockEventStderr   BlockEventKind = "stderr"
+	BlockEventOutput   BlockEventKind = "output"
+	BlockEventFinished BlockEventKind = "finished"
+)
+
+// BlockEvent reports incremental progress for one block while a story executes.
+type BlockEvent struct {
+	StoryID string         `json:"storyId"`
+	BlockID string         `json:"blockId"`
+	Kind    BlockEventKind `json:"kind"`
+	At      time.Time      `json:"at"`
+	// Text carries a stdout or stderr chunk.
+	Text string `json:"text,omitempty"`
+	// Output is set for output events.
+	Output *Output `json:"output,omitempty"`
+	// Status and DurationMs are set when the block finishes.
+	Status     string `json:"status,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty"`
+}
+
+// ProgressFunc receives block events; it must be safe to call from the runner's goroutines.
+type ProgressFunc func(BlockEvent)
+
 // Runner abstracts execution backends used by the story service.
 type Runner interface {
 	Execute(ctx context.Context, req ExecutionRequest) (ExecutionResult, error)
 }
 
+// SessionRestarter is implemented by runners that keep interpreter state between blocks.
+type SessionRestarter interface {
+	RestartSessions(ctx context.Context, storyID string) error
+}
+
 // Repository describes persistence operations for stories.
 type Repository interface {
 	Create(ctx context.Context, story Story) error
@@ -112,8 +337,23 @@ type Repository interface {
 	Get(ctx context.Context, id string) (Story, error)
 	List(ctx context.Context) ([]Story, error)
 	AppendRevision(ctx context.Context, revision Revision) error
+	// Commit atomically stores the story and appends revision, failing with ErrConflict
+	// unless the stored RevisionID still equals expectedRevisionID. Comments on blocks the story
+	// no longer has are re-anchored to the story.
+	Commit(ctx context.Context, story Story, revision Revision, expectedRevisionID string) error
 	ListRevisions(ctx context.Context, storyID string) ([]Revision, error)
+	GetRevision(ctx context.Context, storyID string, revisionID string) (Revision, error)
 	AppendComment(ctx context.Context, comment Comment) error
+	AppendExecution(ctx context.Context, execution Execution) error
+	// ListExecutions returns the page of executions selected by filter, newest first, and the
+	// number of executions matching filter.Status.
+	ListExecutions(ctx context.Context, storyID string, filter ExecutionFilter) ([]Execution, int, error)
+	// SaveSchedule creates or replaces a schedule by ID.
+	SaveSchedule(ctx context.Context, schedule Schedule) error
+	GetSchedule(ctx context.Context, id string) (Schedule, error)
+	// ListSchedules returns the story's schedules, or every schedule when storyID is empty, oldest first.
+	ListSchedules(ctx context.Context, storyID string) ([]Schedule, error)
+	DeleteSchedule(ctx context.Context, id string) error
 }
 
 // Filter is used when searching for stories.
@@ -129,8 +369,19 @@ type Service interface {
 	ListStories(ctx context.Context, filter Filter) ([]Story, error)
 	GetStory(ctx context.Context, id string) (Story, error)
 	AppendBlock(ctx context.Context, id string, input BlockInput) (Story, error)
+	UpdateBlock(ctx context.Context, id string, blockID string, input BlockPatch) (Story, error)
+	DeleteBlock(ctx context.Context, id string, blockID string, opts WriteOptions) (Story, error)
+	MoveBlock(ctx context.Context, id string, blockID string, position int, opts WriteOptions) (Story, error)
+	ListRevisions(ctx context.Context, id string) ([]Revision, error)
+	GetRevision(ctx context.Context, id string, revisionID string) (Revision, error)
+	DiffRevisions(ctx context.Context, id string, fromID string, toID string) (RevisionDiff, error)
+	RestoreRevision(ctx context.Context, id string, revisionID string, opts WriteOptions) (Story, error)
 	RecordComment(ctx context.Context, id string, input CommentInput) (Story, error)
-	ExecuteStory(ctx context.Context, id string, actor string) (ExecutionResult, error)
+	ExecuteStory(ctx context.Context, id string, opts ExecuteOptions) (ExecutionResult, error)
+	ListExecutions(ctx context.Context, id string, filter ExecutionFilter) (ExecutionPage, error)
+	RestartKernel(ctx context.Context, id string, actor string) error
+	UpdateParameters(ctx context.Context, id string, params []Parameter, opts WriteOptions) (Story, error)
+	StoryGraph(ctx context.Context, id string) (Graph, error)
 }
 
 // CreateStoryInput captures the payload for a new story.
@@ -141,6 +392,7 @@ type CreateStoryInput struct {
 	Visibility  Visibility
 	Tags        []string
 	Blocks      []BlockInput
+	Parameters  []Parameter
 }
 
 // BlockInput defines the data required to insert a new block.
@@ -149,6 +401,31 @@ type BlockInput struct {
 	Language string
 	Source   string
 	Position int
+	// DependsOn declares dependencies on other blocks by ID.
+	DependsOn []string
+	Author    string
+	Message   string
+	// ExpectedRevision, when set, rejects the write unless it matches the story's RevisionID.
+	ExpectedRevision string
+}
+
+// BlockPatch lists the block fields to change; nil fields are left untouched.
+type BlockPatch struct {
+	Type     *BlockType
+	Language *string
+	Source   *string
+	// DependsOn, when set, replaces the block's declared dependencies.
+	DependsOn *[]string
+	Author    string
+	Message   string
+	// ExpectedRevision, when set, rejects the write unless it matches the story's RevisionID.
+	ExpectedRevision string
+}
+
+// WriteOptions attributes a write and optionally pins the revision it was based on.
+type WriteOptions struct {
+	Actor            string
+	ExpectedRevision string
 }
 
 // CommentInput collects authoring information for a comment.
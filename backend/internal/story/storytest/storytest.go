@@ -0,0 +1,679 @@
+// Package storytest provides a behavioural conformance suite for story.Repository implementations.
+package storytest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/multistory/internal/story"
+)
+
+// Factory returns an empty repository; it is called once per subtest.
+type Factory func(t *testing.T) story.Repository
+
+// RunRepositoryTests exercises the contract every story.Repository must honour.
+func RunRepositoryTests(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo story.Repository)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"GetNotFound", testGetNotFound},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"ListNewestFirst", testListNewestFirst},
+		{"CloneIsolation", testCloneIsolation},
+		{"AppendRevision", testAppendRevision},
+		{"AppendRevisionNotFound", testAppendRevisionNotFound},
+		{"ListRevisionsOrder", testListRevisionsOrder},
+		{"GetRevision", testGetRevision},
+		{"Commit", testCommit},
+		{"CommitConflict", testCommitConflict},
+		{"CommitReanchorsComments", testCommitReanchorsComments},
+		{"AppendComment", testAppendComment},
+		{"AppendCommentNotFound", testAppendCommentNotFound},
+		{"ConcurrentCommits", testConcurrentCommits},
+		{"ConcurrentComments", testConcurrentComments},
+		{"AppendExecution", testAppendExecution},
+		{"ListExecutionsPaging", testListExecutionsPaging},
+		{"ExecutionsNotFound", testExecutionsNotFound},
+		{"SaveSchedule", testSaveSchedule},
+		{"ListSchedules", testListSchedules},
+		{"DeleteSchedule", testDeleteSchedule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory(t))
+		})
+	}
+}
+
+var epoch = time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+func fixture(id string, offset time.Duration) story.Story {
+	at := epoch.Add(offset)
+	return story.Story{
+		ID:          id,
+		Title:       "Story " + id,
+		Description: "Fixture " + id,
+		Owners:      []string{"ann", "bob"},
+		Visibility:  story.VisibilityOrganization,
+		RevisionID:  id + "-r0",
+		Blocks: []story.Block{
+			{ID: id + "-b0", Type: story.BlockMarkdown, Source: "# Intro", Position: 0, CreatedAt: at, UpdatedAt: at},
+			{
+				ID: id + "-b1", Type: story.BlockCode, Language: "python", Source: "print(1)", Position: 1,
+				CreatedAt: at, UpdatedAt: at, DependsOn: []string{id + "-b0"},
+				Outputs: []story.Output{{Kind: "text", MimeType: "text/plain", Data: "1"}},
+			},
+		},
+		Tags: []string{"sales"},
+		Parameters: []story.Parameter{
+			{Name: "region", Type: story.ParamEnum, Default: "emea", Options: []string{"emea", "apac"}},
+			{Name: "since", Type: story.ParamDate, Label: "Since"},
+			{Name: "top", Type: story.ParamNumber, Default: 10.0},
+		},
+		CreatedAt: at,
+		UpdatedAt: at,
+	}
+}
+
+func revisionOf(s story.Story, id string, offset time.Duration) story.Revision {
+	return story.Revision{
+		ID:        id,
+		StoryID:   s.ID,
+		Author:    "ann",
+		Message:   "revision " + id,
+		CreatedAt: epoch.Add(offset),
+		Blocks:    append([]story.Block(nil), s.Blocks...),
+	}
+}
+
+func mustCreate(t *testing.T, repo story.Repository, s story.Story) {
+	t.Helper()
+	if err := repo.Create(context.Background(), s); err != nil {
+		t.Fatalf("Create(%s): %v", s.ID, err)
+	}
+}
+
+func mustGet(t *testing.T, repo story.Repository, id string) story.Story {
+	t.Helper()
+	got, err := repo.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", id, err)
+	}
+	return got
+}
+
+func assertEqual(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s mismatch\n got: %+v\nwant: %+v", what, got, want)
+	}
+}
+
+func testCreateAndGet(t *testing.T, repo story.Repository) {
+	want := fixture("s1", 0)
+	mustCreate(t, repo, want)
+	assertEqual(t, "story", mustGet(t, repo, "s1"), want)
+}
+
+func testGetNotFound(t *testing.T, repo story.Repository) {
+	if _, err := repo.Get(context.Background(), "missing"); err != story.ErrNotFound {
+		t.Fatalf("Get(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+}
+
+func testUpdate(t *testing.T, repo story.Repository) {
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	s.Title = "Renamed"
+	s.Blocks = s.Blocks[:1]
+	s.Blocks[0].Source = "# Changed"
+	s.Parameters = s.Parameters[:1]
+	s.UpdatedAt = epoch.Add(time.Hour)
+	if err := repo.Update(context.Background(), s); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	assertEqual(t, "story", mustGet(t, repo, "s1"), s)
+}
+
+func testUpdateNotFound(t *testing.T, repo story.Repository) {
+	if err := repo.Update(context.Background(), fixture("missing", 0)); err != story.ErrNotFound {
+		t.Fatalf("Update(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+	if _, err := repo.Get(context.Background(), "missing"); err != story.ErrNotFound {
+		t.Fatalf("Update(missing) must not create the story, Get error = %v", err)
+	}
+}
+
+func testListNewestFirst(t *testing.T, repo story.Repository) {
+	mustCreate(t, repo, fixture("old", 0))
+	mustCreate(t, repo, fixture("new", 2*time.Hour))
+	mustCreate(t, repo, fixture("mid", time.Hour))
+	stories, err := repo.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var ids []string
+	for _, s := range stories {
+		ids = append(ids, s.ID)
+	}
+	assertEqual(t, "list order", ids, []string{"new", "mid", "old"})
+}
+
+func testCloneIsolation(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	input := fixture("s1", 0)
+	want := fixture("s1", 0)
+	mustCreate(t, repo, input)
+	input.Title = "mutated input"
+	input.Blocks[0].Source = "mutated input"
+	input.Owners[0] = "mutated"
+	input.Blocks[1].Outputs[0].Data = "mutated input"
+	input.Blocks[1].DependsOn[0] = "mutated input"
+	input.Parameters[0].Options[0] = "mutated input"
+
+	got := mustGet(t, repo, "s1")
+	got.Blocks[0].Source = "mutated result"
+	got.Tags[0] = "mutated"
+	got.Blocks[1].Outputs[0].Data = "mutated result"
+	got.Blocks[1].DependsOn[0] = "mutated result"
+	got.Parameters[0].Options[0] = "mutated result"
+	got.Blocks = append(got.Blocks, story.Block{ID: "extra"})
+
+	listed, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	listed[0].Owners[0] = "mutated"
+	listed[0].Blocks[1].Source = "mutated list"
+	listed[0].Blocks[1].Outputs[0].Data = "mutated list"
+	listed[0].Parameters[0].Options[1] = "mutated list"
+
+	rev := revisionOf(fixture("s1", 0), "r1", time.Minute)
+	if err := repo.AppendRevision(ctx, rev); err != nil {
+		t.Fatalf("AppendRevision: %v", err)
+	}
+	want.RevisionID = "r1"
+	rev.Blocks[0].Source = "mutated revision input"
+	rev.Blocks[1].Outputs[0].Data = "mutated revision input"
+	rev.Blocks[1].DependsOn[0] = "mutated revision input"
+	revs, err := repo.ListRevisions(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	revs[0].Blocks[0].Source = "mutated revision result"
+	revs[0].Blocks[1].Outputs[0].Data = "mutated revision result"
+	revs[0].Blocks[1].DependsOn[0] = "mutated revision result"
+
+	assertEqual(t, "story", mustGet(t, repo, "s1"), want)
+	revs, err = repo.ListRevisions(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	assertEqual(t, "revisions", revs, []story.Revision{revisionOf(fixture("s1", 0), "r1", time.Minute)})
+}
+
+func testAppendRevision(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	next := s
+	next.Blocks = []story.Block{s.Blocks[1]}
+	rev := revisionOf(next, "r1", time.Minute)
+	if err := repo.AppendRevision(ctx, rev); err != nil {
+		t.Fatalf("AppendRevision: %v", err)
+	}
+	got := mustGet(t, repo, "s1")
+	if got.RevisionID != "r1" {
+		t.Fatalf("RevisionID = %q, want r1", got.RevisionID)
+	}
+	assertEqual(t, "blocks", got.Blocks, rev.Blocks)
+}
+
+func testAppendRevisionNotFound(t *testing.T, repo story.Repository) {
+	err := repo.AppendRevision(context.Background(), revisionOf(fixture("missing", 0), "r1", 0))
+	if err != story.ErrNotFound {
+		t.Fatalf("AppendRevision(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+}
+
+func testListRevisionsOrder(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	mustCreate(t, repo, fixture("other", 0))
+	// Append order wins over CreatedAt so history reads the way it was written.
+	var want []story.Revision
+	for i, offset := range []time.Duration{time.Hour, time.Minute, 2 * time.Hour} {
+		rev := revisionOf(s, fmt.Sprintf("r%d", i), offset)
+		if err := repo.AppendRevision(ctx, rev); err != nil {
+			t.Fatalf("AppendRevision: %v", err)
+		}
+		want = append(want, rev)
+	}
+	if err := repo.AppendRevision(ctx, revisionOf(fixture("other", 0), "other-r", 0)); err != nil {
+		t.Fatalf("AppendRevision(other): %v", err)
+	}
+	revs, err := repo.ListRevisions(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	assertEqual(t, "revisions", revs, want)
+
+	empty, err := repo.ListRevisions(ctx, "missing")
+	if err != nil {
+		t.Fatalf("ListRevisions(missing): %v", err)
+	}
+	if len(empty) != 0 {
+		t.Fatalf("ListRevisions(missing) = %v, want empty", empty)
+	}
+}
+
+func testGetRevision(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	rev := revisionOf(s, "r1", time.Minute)
+	if err := repo.AppendRevision(ctx, rev); err != nil {
+		t.Fatalf("AppendRevision: %v", err)
+	}
+	got, err := repo.GetRevision(ctx, "s1", "r1")
+	if err != nil {
+		t.Fatalf("GetRevision: %v", err)
+	}
+	assertEqual(t, "revision", got, rev)
+	if _, err := repo.GetRevision(ctx, "s1", "nope"); err != story.ErrRevisionNotFound {
+		t.Fatalf("GetRevision(nope) error = %v, want %v", err, story.ErrRevisionNotFound)
+	}
+	if _, err := repo.GetRevision(ctx, "missing", "r1"); err != story.ErrNotFound {
+		t.Fatalf("GetRevision(missing story) error = %v, want %v", err, story.ErrNotFound)
+	}
+}
+
+func testCommit(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	if err := repo.AppendComment(ctx, story.Comment{ID: "c1", StoryID: "s1", Author: "ann", Body: "hi", CreatedAt: epoch}); err != nil {
+		t.Fatalf("AppendComment: %v", err)
+	}
+
+	next := s
+	next.Title = "Committed"
+	next.Blocks = s.Blocks[:1]
+	next.UpdatedAt = epoch.Add(time.Minute)
+	rev := revisionOf(next, "r1", time.Minute)
+	if err := repo.Commit(ctx, next, rev, s.RevisionID); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	got := mustGet(t, repo, "s1")
+	if got.RevisionID != "r1" || got.Title != "Committed" || len(got.Blocks) != 1 {
+		t.Fatalf("Commit stored %+v", got)
+	}
+	if len(got.Comments) != 1 {
+		t.Fatalf("Commit must keep comments added since the read, got %d", len(got.Comments))
+	}
+	revs, err := repo.ListRevisions(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	assertEqual(t, "revisions", revs, []story.Revision{rev})
+
+	if err := repo.Commit(ctx, fixture("missing", 0), rev, ""); err != story.ErrNotFound {
+		t.Fatalf("Commit(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+}
+
+func testCommitReanchorsComments(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	comments := []story.Comment{
+		{ID: "c1", StoryID: "s1", BlockID: "s1-b0", Author: "ann", Body: "kept", CreatedAt: epoch},
+		{ID: "c2", StoryID: "s1", BlockID: "s1-b1", Author: "bob", Body: "orphaned", CreatedAt: epoch},
+	}
+	for _, c := range comments {
+		if err := repo.AppendComment(ctx, c); err != nil {
+			t.Fatalf("AppendComment: %v", err)
+		}
+	}
+	next := s
+	next.Blocks = s.Blocks[:1]
+	if err := repo.Commit(ctx, next, revisionOf(next, "r1", time.Minute), s.RevisionID); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	comments[1].BlockID = ""
+	assertEqual(t, "comments", mustGet(t, repo, "s1").Comments, comments)
+}
+
+func testCommitConflict(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	if err := repo.Commit(ctx, s, revisionOf(s, "r1", time.Minute), s.RevisionID); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	stale := s
+	stale.Title = "Stale"
+	if err := repo.Commit(ctx, stale, revisionOf(s, "r2", 2*time.Minute), s.RevisionID); err != story.ErrConflict {
+		t.Fatalf("stale Commit error = %v, want %v", err, story.ErrConflict)
+	}
+	got := mustGet(t, repo, "s1")
+	if got.RevisionID != "r1" || got.Title == "Stale" {
+		t.Fatalf("stale Commit leaked into store: %+v", got)
+	}
+	revs, err := repo.ListRevisions(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	if len(revs) != 1 {
+		t.Fatalf("stale Commit appended a revision: %d revisions", len(revs))
+	}
+}
+
+func testAppendComment(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	mustCreate(t, repo, fixture("s1", 0))
+	want := []story.Comment{
+		{ID: "c1", StoryID: "s1", Author: "ann", Body: "first", CreatedAt: epoch.Add(time.Hour)},
+		{ID: "c2", StoryID: "s1", BlockID: "s1-b1", Author: "bob", Body: "second", CreatedAt: epoch},
+	}
+	for _, c := range want {
+		if err := repo.AppendComment(ctx, c); err != nil {
+			t.Fatalf("AppendComment: %v", err)
+		}
+	}
+	assertEqual(t, "comments", mustGet(t, repo, "s1").Comments, want)
+}
+
+func testAppendCommentNotFound(t *testing.T, repo story.Repository) {
+	err := repo.AppendComment(context.Background(), story.Comment{ID: "c1", StoryID: "missing"})
+	if err != story.ErrNotFound {
+		t.Fatalf("AppendComment(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+}
+
+// testConcurrentCommits runs read-modify-commit loops in parallel; no write may be lost.
+func testConcurrentCommits(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	s.Blocks = nil
+	mustCreate(t, repo, s)
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				current, err := repo.Get(ctx, "s1")
+				if err != nil {
+					errs <- err
+					return
+				}
+				base := current.RevisionID
+				current.Blocks = append(current.Blocks, story.Block{ID: fmt.Sprintf("w%d", w), Position: len(current.Blocks)})
+				rev := revisionOf(current, fmt.Sprintf("rev-w%d", w), 0)
+				err = repo.Commit(ctx, current, rev, base)
+				if err == story.ErrConflict {
+					continue
+				}
+				errs <- err
+				return
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent Commit: %v", err)
+		}
+	}
+	got := mustGet(t, repo, "s1")
+	if len(got.Blocks) != writers {
+		t.Fatalf("got %d blocks after %d concurrent commits", len(got.Blocks), writers)
+	}
+	revs, err := repo.ListRevisions(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	if len(revs) != writers {
+		t.Fatalf("got %d revisions after %d concurrent commits", len(revs), writers)
+	}
+	for i, rev := range revs {
+		if len(rev.Blocks) != i+1 {
+			t.Fatalf("revision %d has %d blocks, want %d", i, len(rev.Blocks), i+1)
+		}
+	}
+}
+
+func testConcurrentComments(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	mustCreate(t, repo, fixture("s1", 0))
+
+	const writers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- repo.AppendComment(ctx, story.Comment{ID: fmt.Sprintf("c%d", w), StoryID: "s1", CreatedAt: epoch})
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent AppendComment: %v", err)
+		}
+	}
+	if got := len(mustGet(t, repo, "s1").Comments); got != writers {
+		t.Fatalf("got %d comments after %d concurrent appends", got, writers)
+	}
+}
+
+func executionOf(s story.Story, id, status string, offset time.Duration) story.Execution {
+	return story.Execution{
+		ID:           id,
+		StoryID:      s.ID,
+		Actor:        "ann",
+		Status:       status,
+		BaseRevision: s.RevisionID,
+		Revision:     id + "-rev",
+		StartedAt:    epoch.Add(offset),
+		FinishedAt:   epoch.Add(offset + time.Second),
+		Logs:         []string{"ran " + id},
+		Parameters:   map[string]interface{}{"region": "apac", "since": "2024-01-01", "top": 5.0},
+	}
+}
+
+func testAppendExecution(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	want := executionOf(s, "e1", "failed", 0)
+	want.Target = story.ExecutionTarget{Scope: story.ScopeFrom, BlockID: "s1-b1"}
+	want.Error = "boom"
+	if err := repo.AppendExecution(ctx, want); err != nil {
+		t.Fatalf("AppendExecution: %v", err)
+	}
+	got, total, err := repo.ListExecutions(ctx, "s1", story.ExecutionFilter{})
+	if err != nil {
+		t.Fatalf("ListExecutions: %v", err)
+	}
+	if total != 1 {
+		t.Fatalf("total = %d, want 1", total)
+	}
+	assertEqual(t, "executions", got, []story.Execution{want})
+
+	got[0].Logs[0] = "mutated"
+	again, _, err := repo.ListExecutions(ctx, "s1", story.ExecutionFilter{})
+	if err != nil {
+		t.Fatalf("ListExecutions: %v", err)
+	}
+	assertEqual(t, "executions after caller mutation", again, []story.Execution{want})
+}
+
+func testListExecutionsPaging(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	s := fixture("s1", 0)
+	mustCreate(t, repo, s)
+	mustCreate(t, repo, fixture("s2", 0))
+	statuses := []string{"completed", "failed", "completed", "completed", "failed"}
+	for i, status := range statuses {
+		if err := repo.AppendExecution(ctx, executionOf(s, fmt.Sprintf("e%d", i), status, time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("AppendExecution: %v", err)
+		}
+	}
+	if err := repo.AppendExecution(ctx, executionOf(fixture("s2", 0), "other", "completed", 0)); err != nil {
+		t.Fatalf("AppendExecution: %v", err)
+	}
+
+	ids := func(executions []story.Execution) []string {
+		var out []string
+		for _, e := range executions {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	cases := []struct {
+		filter story.ExecutionFilter
+		want   []string
+		total  int
+	}{
+		{story.ExecutionFilter{}, []string{"e4", "e3", "e2", "e1", "e0"}, 5},
+		{story.ExecutionFilter{Limit: 2}, []string{"e4", "e3"}, 5},
+		{story.ExecutionFilter{Limit: 2, Offset: 4}, []string{"e0"}, 5},
+		{story.ExecutionFilter{Offset: 9}, nil, 5},
+		{story.ExecutionFilter{Status: "completed", Limit: 1}, []string{"e3"}, 3},
+		{story.ExecutionFilter{Status: "failed"}, []string{"e4", "e1"}, 2},
+	}
+	for _, c := range cases {
+		got, total, err := repo.ListExecutions(ctx, "s1", c.filter)
+		if err != nil {
+			t.Fatalf("ListExecutions(%+v): %v", c.filter, err)
+		}
+		if total != c.total {
+			t.Fatalf("ListExecutions(%+v) total = %d, want %d", c.filter, total, c.total)
+		}
+		assertEqual(t, fmt.Sprintf("ListExecutions(%+v)", c.filter), ids(got), c.want)
+	}
+}
+
+func testExecutionsNotFound(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	if err := repo.AppendExecution(ctx, story.Execution{ID: "e1", StoryID: "missing"}); err != story.ErrNotFound {
+		t.Fatalf("AppendExecution(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+	if _, _, err := repo.ListExecutions(ctx, "missing", story.ExecutionFilter{}); err != story.ErrNotFound {
+		t.Fatalf("ListExecutions(missing) error = %v, want %v", err, story.ErrNotFound)
+	}
+}
+
+func scheduleOf(storyID, id string, offset time.Duration) story.Schedule {
+	next := epoch.Add(offset + time.Hour)
+	return story.Schedule{
+		ID:        id,
+		StoryID:   storyID,
+		Cron:      "0 * * * *",
+		Enabled:   true,
+		CreatedBy: "ann",
+		CreatedAt: epoch.Add(offset),
+		UpdatedAt: epoch.Add(offset),
+		NextRunAt: &next,
+	}
+}
+
+func testSaveSchedule(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	mustCreate(t, repo, fixture("s1", 0))
+	want := scheduleOf("s1", "sch1", 0)
+	if err := repo.SaveSchedule(ctx, want); err != nil {
+		t.Fatalf("SaveSchedule: %v", err)
+	}
+	got, err := repo.GetSchedule(ctx, "sch1")
+	if err != nil {
+		t.Fatalf("GetSchedule: %v", err)
+	}
+	assertEqual(t, "schedule", got, want)
+
+	last := epoch.Add(time.Hour)
+	want.Cron = "@daily"
+	want.Timezone = "Europe/Berlin"
+	want.Enabled = false
+	want.LastRunAt = &last
+	want.NextRunAt = nil
+	want.LastJobID = "job1"
+	if err := repo.SaveSchedule(ctx, want); err != nil {
+		t.Fatalf("SaveSchedule(update): %v", err)
+	}
+	got, err = repo.GetSchedule(ctx, "sch1")
+	if err != nil {
+		t.Fatalf("GetSchedule: %v", err)
+	}
+	assertEqual(t, "updated schedule", got, want)
+
+	if err := repo.SaveSchedule(ctx, scheduleOf("missing", "sch2", 0)); err != story.ErrNotFound {
+		t.Fatalf("SaveSchedule(missing story) error = %v, want %v", err, story.ErrNotFound)
+	}
+	if _, err := repo.GetSchedule(ctx, "nope"); err != story.ErrScheduleNotFound {
+		t.Fatalf("GetSchedule(nope) error = %v, want %v", err, story.ErrScheduleNotFound)
+	}
+}
+
+func testListSchedules(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	mustCreate(t, repo, fixture("s1", 0))
+	mustCreate(t, repo, fixture("s2", 0))
+	for _, sch := range []story.Schedule{
+		scheduleOf("s1", "b", time.Hour),
+		scheduleOf("s2", "c", 30*time.Minute),
+		scheduleOf("s1", "a", 0),
+	} {
+		if err := repo.SaveSchedule(ctx, sch); err != nil {
+			t.Fatalf("SaveSchedule: %v", err)
+		}
+	}
+	ids := func(storyID string) []string {
+		t.Helper()
+		schedules, err := repo.ListSchedules(ctx, storyID)
+		if err != nil {
+			t.Fatalf("ListSchedules(%q): %v", storyID, err)
+		}
+		out := []string{}
+		for _, sch := range schedules {
+			out = append(out, sch.ID)
+		}
+		return out
+	}
+	assertEqual(t, "s1 schedules", ids("s1"), []string{"a", "b"})
+	assertEqual(t, "all schedules", ids(""), []string{"a", "c", "b"})
+	assertEqual(t, "unknown story schedules", ids("missing"), []string{})
+}
+
+func testDeleteSchedule(t *testing.T, repo story.Repository) {
+	ctx := context.Background()
+	mustCreate(t, repo, fixture("s1", 0))
+	if err := repo.SaveSchedule(ctx, scheduleOf("s1", "sch1", 0)); err != nil {
+		t.Fatalf("SaveSchedule: %v", err)
+	}
+	if err := repo.DeleteSchedule(ctx, "sch1"); err != nil {
+		t.Fatalf("DeleteSchedule: %v", err)
+	}
+	if _, err := repo.GetSchedule(ctx, "sch1"); err != story.ErrScheduleNotFound {
+		t.Fatalf("GetSchedule after delete error = %v, want %v", err, story.ErrScheduleNotFound)
+	}
+	if err := repo.DeleteSchedule(ctx, "sch1"); err != story.ErrScheduleNotFound {
+		t.Fatalf("DeleteSchedule twice error = %v, want %v", err, story.ErrScheduleNotFound)
+	}
+}
@@ -0,0 +1,533 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// frame is one frame as the test client sees it.
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// encode renders f as a client would, masked unless unmasked is set.
+func (f frame) encode(unmasked bool) []byte {
+	var b []byte
+	first := f.opcode
+	if f.fin {
+		first |= 0x80
+	}
+	b = append(b, first)
+	maskBit := byte(0x80)
+	if unmasked {
+		maskBit = 0
+	}
+	switch n := len(f.payload); {
+	case n <= 125:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if unmasked {
+		return append(b, f.payload...)
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask[:]...)
+	for i, v := range f.payload {
+		b = append(b, v^mask[i%4])
+	}
+	return b
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// client is the far end of a Conn. It collects every frame the server sends.
+type client struct {
+	conn   net.Conn
+	frames chan frame
+}
+
+// newPair connects a server Conn to a test client over an in-memory pipe.
+func newPair(t *testing.T) (*Conn, *client) {
+	t.Helper()
+	server, peer := net.Pipe()
+	c := &Conn{conn: server, reader: bufio.NewReader(server), maxMessage: DefaultMaxMessageSize}
+	cl := &client{conn: peer, frames: make(chan frame, 1024)}
+	go cl.readFrames()
+	t.Cleanup(func() {
+		server.Close()
+		peer.Close()
+	})
+	return c, cl
+}
+
+func (cl *client) readFrames() {
+	defer close(cl.frames)
+	r := bufio.NewReader(cl.conn)
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		cl.frames <- frame{fin: header[0]&0x80 != 0, opcode: header[0] & 0x0f, payload: payload}
+	}
+}
+
+// send writes raw bytes in the background; the pipe blocks until the server reads them.
+func (cl *client) send(data ...[]byte) {
+	go cl.conn.Write(bytes.Join(data, nil))
+}
+
+func (cl *client) next(t *testing.T) frame {
+	t.Helper()
+	select {
+	case f, ok := <-cl.frames:
+		if !ok {
+			t.Fatalf("expected a frame, the connection closed")
+		}
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a frame")
+	}
+	return frame{}
+}
+
+// expectClose reads the next frame and checks it is a close frame with code.
+func (cl *client) expectClose(t *testing.T, code int) string {
+	t.Helper()
+	f := cl.next(t)
+	if f.opcode != opClose || len(f.payload) < 2 {
+		t.Fatalf("expected a close frame, got opcode %d with %q", f.opcode, f.payload)
+	}
+	if got := int(binary.BigEndian.Uint16(f.payload)); got != code {
+		t.Fatalf("expected close code %d, got %d", code, got)
+	}
+	return string(f.payload[2:])
+}
+
+func expectCloseError(t *testing.T, err error, code int) *CloseError {
+	t.Helper()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected a *CloseError, got %v", err)
+	}
+	if closeErr.Code != code {
+		t.Fatalf("expected close code %d, got %d", code, closeErr.Code)
+	}
+	return closeErr
+}
+
+func upgradeRequest(header map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, value := range header {
+		if value == "" {
+			r.Header.Del(name)
+		} else {
+			r.Header.Set(name, value)
+		}
+	}
+	return r
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn.Close(CloseNormal, "")
+	}))
+	defer srv.Close()
+
+	netConn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer netConn.Close()
+	req := upgradeRequest(nil)
+	req.URL.Host = srv.Listener.Addr().String()
+	req.RequestURI = ""
+	if err := req.Write(netConn); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(netConn), req)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept %q", got)
+	}
+	if !headerContains(resp.Header, "Upgrade", "websocket") || !headerContains(resp.Header, "Connection", "upgrade") {
+		t.Fatalf("expected upgrade headers, got %v", resp.Header)
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		header map[string]string
+	}{
+		{name: "not GET", method: http.MethodPost},
+		{name: "no Upgrade header", header: map[string]string{"Upgrade": ""}},
+		{name: "no Connection upgrade", header: map[string]string{"Connection": "keep-alive"}},
+		{name: "other protocol", header: map[string]string{"Upgrade": "h2c"}},
+		{name: "old version", header: map[string]string{"Sec-WebSocket-Version": "8"}},
+		{name: "missing key", header: map[string]string{"Sec-WebSocket-Key": ""}},
+		{name: "key not base64", header: map[string]string{"Sec-WebSocket-Key": "not base64!"}},
+		{name: "short key", header: map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := upgradeRequest(tc.header)
+			if tc.method != "" {
+				r.Method = tc.method
+			}
+			w := httptest.NewRecorder()
+			if _, err := Upgrade(w, r); !errors.Is(err, ErrBadHandshake) {
+				t.Fatalf("expected ErrBadHandshake, got %v", err)
+			}
+			if w.Code != http.StatusOK || w.Body.Len() != 0 {
+				t.Fatalf("expected nothing to be written, got %d %q", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	c, cl := newPair(t)
+	long := bytes.Repeat([]byte("x"), 70000)
+	cl.send(
+		frame{fin: true, opcode: opText, payload: []byte("hello")}.encode(false),
+		frame{fin: true, opcode: opBinary, payload: []byte{0xff, 0x00}}.encode(false),
+		frame{fin: true, opcode: opText, payload: long}.encode(false),
+	)
+	for _, want := range []struct {
+		messageType MessageType
+		data        []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{BinaryMessage, []byte{0xff, 0x00}},
+		{TextMessage, long},
+	} {
+		messageType, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if messageType != want.messageType || !bytes.Equal(data, want.data) {
+			t.Fatalf("expected a %d message of %d bytes, got a %d message of %d bytes", want.messageType, len(want.data), messageType, len(data))
+		}
+	}
+}
+
+func TestReadMessageFragmented(t *testing.T) {
+	c, cl := newPair(t)
+	pongs := 0
+	c.SetPongHandler(func() { pongs++ })
+	cl.send(
+		frame{fin: false, opcode: opText, payload: []byte("Hel")}.encode(false),
+		frame{fin: true, opcode: opPing, payload: []byte("are you there")}.encode(false),
+		frame{fin: false, opcode: opContinuation, payload: []byte("lo, ")}.encode(false),
+		frame{fin: true, opcode: opPong}.encode(false),
+		frame{fin: true, opcode: opContinuation, payload: []byte("world")}.encode(false),
+	)
+	messageType, data, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if messageType != TextMessage || string(data) != "Hello, world" {
+		t.Fatalf("expected the fragments to be joined, got %d %q", messageType, data)
+	}
+	if pongs != 1 {
+		t.Fatalf("expected the pong handler to run once, got %d", pongs)
+	}
+	if f := cl.next(t); f.opcode != opPong || string(f.payload) != "are you there" {
+		t.Fatalf("expected the ping to be answered with its payload, got opcode %d with %q", f.opcode, f.payload)
+	}
+}
+
+func TestPongGivesUpOnClientThatDoesNotRead(t *testing.T) {
+	server, peer := net.Pipe()
+	defer server.Close()
+	defer peer.Close()
+	c := &Conn{conn: server, reader: bufio.NewReader(server), maxMessage: DefaultMaxMessageSize}
+	c.SetWriteWait(50 * time.Millisecond)
+	// The client keeps pinging but never reads the pongs.
+	go peer.Write(frame{fin: true, opcode: opPing, payload: []byte("ping")}.encode(false))
+
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadMessage()
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("expected the pong to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the pong write to give up")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close(CloseGoingAway, "")
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Close not to wait on the stuck pong")
+	}
+}
+
+func TestReadMessageProtocolErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames [][]byte
+		max    int64
+		code   int
+	}{
+		{
+			name:   "unmasked frame",
+			frames: [][]byte{frame{fin: true, opcode: opText, payload: []byte("hi")}.encode(true)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "reserved bits",
+			frames: [][]byte{{0xc1, 0x80, 0, 0, 0, 0}},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "unknown opcode",
+			frames: [][]byte{frame{fin: true, opcode: 0x3}.encode(false)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "continuation without a message",
+			frames: [][]byte{frame{fin: true, opcode: opContinuation, payload: []byte("x")}.encode(false)},
+			code:   CloseProtocolError,
+		},
+		{
+			name: "new message inside a fragmented one",
+			frames: [][]byte{
+				frame{fin: false, opcode: opText, payload: []byte("a")}.encode(false),
+				frame{fin: true, opcode: opText, payload: []byte("b")}.encode(false),
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:   "fragmented control frame",
+			frames: [][]byte{frame{fin: false, opcode: opPing}.encode(false)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "oversized control frame",
+			frames: [][]byte{frame{fin: true, opcode: opPing, payload: bytes.Repeat([]byte("p"), 126)}.encode(false)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "oversized frame",
+			frames: [][]byte{frame{fin: true, opcode: opBinary, payload: bytes.Repeat([]byte("b"), 11)}.encode(false)},
+			max:    10,
+			code:   CloseTooBig,
+		},
+		{
+			name: "oversized message",
+			frames: [][]byte{
+				frame{fin: false, opcode: opBinary, payload: bytes.Repeat([]byte("b"), 6)}.encode(false),
+				frame{fin: true, opcode: opContinuation, payload: bytes.Repeat([]byte("b"), 6)}.encode(false),
+			},
+			max:  10,
+			code: CloseTooBig,
+		},
+		{
+			name:   "invalid utf-8",
+			frames: [][]byte{frame{fin: true, opcode: opText, payload: []byte{'o', 'k', 0xc3}}.encode(false)},
+			code:   CloseInvalidPayload,
+		},
+		{
+			name: "invalid utf-8 split across fragments",
+			frames: [][]byte{
+				frame{fin: false, opcode: opText, payload: []byte{0xe2, 0x82}}.encode(false),
+				frame{fin: true, opcode: opContinuation, payload: []byte{'x'}}.encode(false),
+			},
+			code: CloseInvalidPayload,
+		},
+		{
+			name:   "one byte close payload",
+			frames: [][]byte{frame{fin: true, opcode: opClose, payload: []byte{0x03}}.encode(false)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "invalid close reason",
+			frames: [][]byte{frame{fin: true, opcode: opClose, payload: closePayload(CloseNormal, "\xff")}.encode(false)},
+			code:   CloseProtocolError,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cl := newPair(t)
+			if tc.max > 0 {
+				c.SetMaxMessageSize(tc.max)
+			}
+			cl.send(tc.frames...)
+			_, _, err := c.ReadMessage()
+			expectCloseError(t, err, tc.code)
+			cl.expectClose(t, tc.code)
+			if err := c.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("expected writes to fail once closed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadMessageAcceptsSplitUTF8(t *testing.T) {
+	c, cl := newPair(t)
+	euro := []byte("€")
+	cl.send(
+		frame{fin: false, opcode: opText, payload: euro[:1]}.encode(false),
+		frame{fin: true, opcode: opContinuation, payload: euro[1:]}.encode(false),
+	)
+	if _, data, err := c.ReadMessage(); err != nil || string(data) != "€" {
+		t.Fatalf("expected a character split across frames to be accepted, got %q, %v", data, err)
+	}
+}
+
+func TestPeerCloseIsEchoed(t *testing.T) {
+	cases := []struct {
+		name    string
+		payload []byte
+		code    int
+		reason  string
+		echo    int
+	}{
+		{name: "code and reason", payload: closePayload(CloseGoingAway, "bye"), code: CloseGoingAway, reason: "bye", echo: CloseGoingAway},
+		{name: "code only", payload: closePayload(CloseNormal, ""), code: CloseNormal, echo: CloseNormal},
+		{name: "no status", payload: nil, code: CloseNoStatus, echo: CloseNormal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cl := newPair(t)
+			cl.send(frame{fin: true, opcode: opClose, payload: tc.payload}.encode(false))
+			_, _, err := c.ReadMessage()
+			closeErr := expectCloseError(t, err, tc.code)
+			if closeErr.Reason != tc.reason {
+				t.Fatalf("expected reason %q, got %q", tc.reason, closeErr.Reason)
+			}
+			cl.expectClose(t, tc.echo)
+			if _, ok := <-cl.frames; ok {
+				t.Fatalf("expected the connection to close after the echo")
+			}
+		})
+	}
+}
+
+func TestCloseTruncatesReasonOnRuneBoundary(t *testing.T) {
+	c, cl := newPair(t)
+	reason := strings.Repeat("é", 100)
+	go c.Close(CloseGoingAway, reason)
+	got := cl.expectClose(t, CloseGoingAway)
+	if len(got)+2 > 125 {
+		t.Fatalf("expected the close frame to fit in 125 bytes, got %d", len(got)+2)
+	}
+	if !utf8.ValidString(got) || !strings.HasPrefix(reason, got) || len(got) < maxCloseReason-1 {
+		t.Fatalf("expected a valid prefix of the reason, got %q", got)
+	}
+	if err := c.Close(CloseNormal, ""); err != nil {
+		t.Fatalf("expected a second Close to do nothing, got %v", err)
+	}
+}
+
+func TestConcurrentWritesKeepFramesWhole(t *testing.T) {
+	c, cl := newPair(t)
+	const writers, each = 8, 20
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Writers use different sizes so a torn frame would not parse back.
+			body := strings.Repeat(fmt.Sprintf("%d", w), 100+w*1000)
+			for i := 0; i < each; i++ {
+				if err := c.WriteMessage(TextMessage, []byte(body)); err != nil {
+					t.Errorf("write: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	counts := make(map[byte]int)
+	for i := 0; i < writers*each; i++ {
+		f := cl.next(t)
+		if f.opcode != opText || !f.fin || len(f.payload) == 0 {
+			t.Fatalf("expected a final text frame, got %+v", f)
+		}
+		w := f.payload[0]
+		if want := 100 + int(w-'0')*1000; len(f.payload) != want || strings.Trim(string(f.payload), string(w)) != "" {
+			t.Fatalf("expected %d bytes of %q, got a mixed or short frame of %d bytes", want, w, len(f.payload))
+		}
+		counts[w]++
+	}
+	wg.Wait()
+	for w := 0; w < writers; w++ {
+		if n := counts[byte('0'+w)]; n != each {
+			t.Fatalf("expected %d messages from writer %d, got %d", each, w, n)
+		}
+	}
+}
@@ -13,6 +13,7 @@ export interface Block {
   source: string;
   position: number;
   outputs: Output[];
+  dependsOn?: string[];
 }
 
 export interface Comment {
@@ -24,6 +25,16 @@ export interface Comment {
   createdAt: string;
 }
 
+export type ParameterValue = string | number;
+
+export interface Parameter {
+  name: string;
+  type: "string" | "number" | "date" | "enum";
+  label?: string;
+  default?: ParameterValue;
+  options?: string[];
+}
+
 export interface Story {
   id: string;
   title: string;
@@ -34,8 +45,10 @@ export interface Story {
   blocks: Block[];
   comments: Comment[];
   tags: string[];
+  parameters?: Parameter[];
   createdAt: string;
   updatedAt: string;
+  issues?: GraphIssue[];
 }
 
 export interface ExecutionResult {
@@ -46,6 +59,104 @@ export interface ExecutionResult {
   status: string;
   blocks: Block[];
   logs: string[];
+  parameters?: Record<string, ParameterValue>;
+}
+
+export type ExecutionScope = "all" | "block" | "from" | "upTo" | "downstream";
+
+export interface ExecutionTarget {
+  scope?: ExecutionScope;
+  blockId?: string;
+}
+
+export type ExecutionStatus = "queued" | "running" | "completed" | "failed" | "cancelled";
+
+export interface ExecutionJob {
+  id: string;
+  storyId: string;
+  actor: string;
+  target: ExecutionTarget;
+  force?: boolean;
+  parameters?: Record<string, ParameterValue>;
+  status: ExecutionStatus;
+  queuePosition?: number;
+  createdAt: string;
+  startedAt?: string;
+  finishedAt?: string;
+  result?: ExecutionResult;
+  error?: string;
+}
+
+export interface ExecutionStats {
+  workers: number;
+  running: number;
+  queued: number;
+}
+
+export interface Execution {
+  id: string;
+  storyId: string;
+  actor: string;
+  status: "completed" | "failed" | "cancelled";
+  baseRevision: string;
+  revision?: string;
+  target: ExecutionTarget;
+  startedAt: string;
+  finishedAt: string;
+  logs?: string[];
+  error?: string;
+  parameters?: Record<string, ParameterValue>;
+}
+
+export interface ExecutionPage {
+  executions: Execution[];
+  total: number;
+  limit: number;
+  offset: number;
+}
+
+export interface Schedule {
+  id: string;
+  storyId: string;
+  cron: string;
+  timezone?: string;
+  enabled: boolean;
+  createdBy: string;
+  createdAt: string;
+  updatedAt: string;
+  nextRunAt?: string;
+  lastRunAt?: string;
+  lastJobId?: string;
+}
+
+export interface GraphNode {
+  blockId: string;
+  language?: string;
+  position: number;
+  defines?: string[];
+  uses?: string[];
+  dependsOn?: string[];
+}
+
+export interface GraphEdge {
+  from: string;
+  to: string;
+  names?: string[];
+  declared?: boolean;
+}
+
+export interface GraphIssue {
+  kind: "cycle" | "undefined" | "order";
+  blockId: string;
+  blocks?: string[];
+  name?: string;
+  message: string;
+}
+
+export interface StoryGraph {
+  nodes: GraphNode[];
+  edges: GraphEdge[];
+  issues: GraphIssue[];
 }
 
 const API_BASE = process.env.NEXT_PUBLIC_API_BASE_URL ?? "http://localhost:8080";
@@ -75,6 +186,7 @@ export function createStory(payload: {
   owners: string[];
   visibility: Story["visibility"];
   tags: string[];
+  parameters?: Parameter[];
   blocks: Array<{
     type: BlockType;
     language?: string;
@@ -88,13 +200,33 @@ export function createStory(payload: {
   });
 }
 
-export function appendBlock(storyId: string, block: { type: BlockType; language?: string; source: string; position?: number }) {
+export function appendBlock(
+  storyId: string,
+  block: { type: BlockType; language?: string; source: string; position?: number; dependsOn?: string[] },
+) {
   return request<Story>(`/api/stories/${storyId}/blocks`, {
     method: "POST",
     body: JSON.stringify(block),
   });
 }
 
+// Edits never run anything unless `run` is set, which queues the block and its dependents once the
+// edit is saved.
+export function updateBlock(
+  storyId: string,
+  blockId: string,
+  patch: { type?: BlockType; language?: string; source?: string; dependsOn?: string[]; author?: string; run?: boolean },
+) {
+  return request<Story>(`/api/stories/${storyId}/blocks/${blockId}`, {
+    method: "PATCH",
+    body: JSON.stringify(patch),
+  });
+}
+
+export function getStoryGraph(storyId: string) {
+  return request<StoryGraph>(`/api/stories/${storyId}/graph`);
+}
+
 export function leaveComment(storyId: string, payload: { author: string; body: string; blockId?: string }) {
   return request<Story>(`/api/stories/${storyId}/comments`, {
     method: "POST",
@@ -102,16 +234,166 @@ export function leaveComment(storyId: string, payload: { author: string; body: s
   });
 }
 
-export function executeStory(storyId: string, actor: string) {
-  return request<ExecutionResult>(`/api/stories/${storyId}/execute`, {
+export function executeStory(
+  storyId: string,
+  actor: string,
+  target: ExecutionTarget = {},
+  force = false,
+  parameters: Record<string, ParameterValue> = {},
+) {
+  return request<ExecutionJob>(`/api/stories/${storyId}/execute`, {
     method: "POST",
-    body: JSON.stringify({ actor }),
+    body: JSON.stringify({ actor, ...target, force, parameters }),
+  });
+}
+
+export function updateParameters(storyId: string, parameters: Parameter[], actor?: string) {
+  return request<Story>(`/api/stories/${storyId}/parameters`, {
+    method: "PUT",
+    body: JSON.stringify({ parameters, actor }),
   });
 }
 
-export function openStoryEventStream(storyId: string, onMessage: (event: MessageEvent) => void) {
-  const url = `${API_BASE}/api/stories/${storyId}/events`;
+export function listExecutions(storyId: string, params: { status?: string; limit?: number; offset?: number } = {}) {
+  const query = new URLSearchParams();
+  if (params.status) query.set("status", params.status);
+  if (params.limit !== undefined) query.set("limit", String(params.limit));
+  if (params.offset !== undefined) query.set("offset", String(params.offset));
+  const suffix = query.toString() ? `?${query}` : "";
+  return request<ExecutionPage>(`/api/stories/${storyId}/executions${suffix}`);
+}
+
+export function getExecution(jobId: string) {
+  return request<ExecutionJob>(`/api/executions/${jobId}`);
+}
+
+export function getExecutionStats() {
+  return request<ExecutionStats>("/api/executions");
+}
+
+export function cancelExecution(jobId: string) {
+  return request<ExecutionJob>(`/api/executions/${jobId}`, { method: "DELETE" });
+}
+
+export function listSchedules(storyId: string) {
+  return request<Schedule[]>(`/api/stories/${storyId}/schedules`);
+}
+
+export function createSchedule(storyId: string, payload: { cron: string; timezone?: string; enabled?: boolean; actor?: string }) {
+  return request<Schedule>(`/api/stories/${storyId}/schedules`, {
+    method: "POST",
+    body: JSON.stringify(payload),
+  });
+}
+
+export function updateSchedule(scheduleId: string, payload: { cron?: string; timezone?: string; enabled?: boolean }) {
+  return request<Schedule>(`/api/schedules/${scheduleId}`, {
+    method: "PATCH",
+    body: JSON.stringify(payload),
+  });
+}
+
+export type StreamTicket = {
+  ticket: string;
+  expiresAt: string;
+};
+
+// Streams cannot send an Authorization header, so trade the token for a single-use ticket first.
+export function createStreamTicket(storyId: string, token: string) {
+  return request<StreamTicket>(`/api/stories/${storyId}/stream-ticket`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+  });
+}
+
+export function openStoryEventStream(
+  storyId: string,
+  onMessage: (event: MessageEvent) => void,
+  actor?: string,
+  ticket?: string,
+) {
+  const params = new URLSearchParams();
+  if (actor) params.set("actor", actor);
+  if (ticket) params.set("ticket", ticket);
+  const query = params.toString();
+  const url = `${API_BASE}/api/stories/${storyId}/events${query ? `?${query}` : ""}`;
   const source = new EventSource(url);
   source.onmessage = onMessage;
+  // Both mean events were missed, after a reconnect or while the client lagged; refetch the story.
+  source.addEventListener("stream.resync", onMessage);
+  source.addEventListener("stream.gap", onMessage);
+  for (const type of ["presence.session", "presence.joined", "presence.updated", "presence.left"]) {
+    source.addEventListener(type, onMessage);
+  }
   return source;
 }
+
+export type PresenceEntry = {
+  sessionId: string;
+  storyId: string;
+  user?: string;
+  blockId?: string;
+  joinedAt: string;
+  lastSeen: string;
+};
+
+export function getPresence(storyId: string) {
+  return request<PresenceEntry[]>(`/api/stories/${storyId}/presence`);
+}
+
+export type StorySocketEvent = {
+  id?: number;
+  storyId: string;
+  type: string;
+  payload: unknown;
+  streamId?: string;
+};
+
+export type StorySocketReply = {
+  type: "reply";
+  replyTo?: string;
+  ok: boolean;
+  status: number;
+  error?: string;
+  payload?: unknown;
+  executionId?: string;
+};
+
+export type StorySocketRequest =
+  | { id?: string; type: "presence.update"; payload: { blockId?: string } }
+  | {
+      id?: string;
+      type: "block.update";
+      payload: {
+        blockId: string;
+        type?: BlockType;
+        language?: string;
+        source?: string;
+        dependsOn?: string[];
+        message?: string;
+        expectedRevision?: string;
+        run?: boolean;
+      };
+    }
+  | { id?: string; type: "comment.create"; payload: { body: string; blockId?: string } };
+
+export function openStorySocket(
+  storyId: string,
+  onMessage: (message: StorySocketEvent | StorySocketReply) => void,
+  options: { ticket?: string; actor?: string; lastEventId?: string } = {},
+) {
+  const params = new URLSearchParams();
+  if (options.ticket) params.set("ticket", options.ticket);
+  if (options.actor) params.set("actor", options.actor);
+  if (options.lastEventId) params.set("lastEventId", options.lastEventId);
+  const query = params.toString();
+  const url = `${API_BASE.replace(/^http/, "ws")}/api/stories/${storyId}/ws${query ? `?${query}` : ""}`;
+  const socket = new WebSocket(url);
+  socket.onmessage = (event) => onMessage(JSON.parse(event.data));
+  return {
+    socket,
+    send(message: StorySocketRequest) {
+      socket.send(JSON.stringify(message));
+    },
+  };
+}